@@ -0,0 +1,377 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TheCreeper/HackBot/store"
+)
+
+// Errors
+var (
+	ErrUnauthorized   = errors.New("Missing or invalid bearer token")
+	ErrNoToken        = errors.New("No admin token configured, actions are disabled")
+	ErrNoSuchNetwork  = errors.New("No such network")
+	ErrNoSuchChannel  = errors.New("No such channel")
+	ErrMissingParam   = errors.New("Missing parameter")
+	ErrMethodNotAllow = errors.New("Method not allowed")
+)
+
+// AdminServer exposes the state of the running networks over HTTP. Read only
+// endpoints and the status page are open, actions need the bearer token.
+type AdminServer struct {
+	Listen   string
+	Token    string
+	Networks *NetworkList
+	Plugins  *PluginHost // nil without plugins
+
+	// Extra handlers mounted on the admin mux
+	Handlers map[string]http.Handler
+}
+
+type NetworkStatus struct {
+	Name           string        `json:"name"`
+	Server         string        `json:"server"`
+	Nick           string        `json:"nick"`
+	Connected      bool          `json:"connected"`
+	ConnectedSince *time.Time    `json:"connected_since,omitempty"`
+	Reconnects     int           `json:"reconnects"`
+	LagMillis      int64         `json:"lag_ms"`
+	QueueDepth     int           `json:"queue_depth"`
+	Channels       []string      `json:"channels"`
+	LastError      *NetworkError `json:"last_error,omitempty"`
+}
+
+type ChannelStatus struct {
+	Name  string `json:"name"`
+	Topic string `json:"topic"`
+	Users int    `json:"users"`
+}
+
+type UserStatus struct {
+	Nick string `json:"nick"`
+	Mode string `json:"mode"`
+}
+
+func networkStatus(n *Network) (s NetworkStatus) {
+
+	s = NetworkStatus{
+
+		Name:       n.Name,
+		Server:     n.Server,
+		Nick:       n.ClientConn.Nick,
+		Connected:  n.ClientConn.Connected(),
+		Reconnects: n.Reconnects(),
+		LagMillis:  int64(n.ClientConn.Lag() / time.Millisecond),
+		QueueDepth: n.ClientConn.QueueLen(),
+		Channels:   n.State.Channels(),
+	}
+	if t := n.ConnectedSince(); !t.IsZero() {
+
+		s.ConnectedSince = &t
+	}
+	if errs := n.Errors(); len(errs) > 0 {
+
+		s.LastError = &errs[len(errs)-1]
+	}
+	if s.Channels == nil {
+
+		s.Channels = []string{}
+	}
+
+	return
+}
+
+func (a *AdminServer) ListenAndServe() error {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", a.handleStatus)
+	mux.HandleFunc("/api/networks", a.handleNetworks)
+	mux.HandleFunc("/api/networks/", a.handleNetwork)
+	mux.HandleFunc("/api/plugins", a.handlePlugins)
+	mux.HandleFunc("/api/plugins/", a.handlePlugin)
+	for pattern, handler := range a.Handlers {
+
+		mux.Handle(pattern, handler)
+	}
+
+	return http.ListenAndServe(a.Listen, mux)
+}
+
+func (a *AdminServer) authorized(r *http.Request) error {
+
+	if len(a.Token) < 1 {
+
+		return ErrNoToken
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+
+		return ErrUnauthorized
+	}
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+
+		slog.Error("admin.writeJSON()", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (a *AdminServer) handleNetworks(w http.ResponseWriter, r *http.Request) {
+
+	status := []NetworkStatus{}
+	for _, n := range a.Networks.All() {
+
+		status = append(status, networkStatus(n))
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// Routes below /api/networks/<name>/
+func (a *AdminServer) handleNetwork(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/networks/"), "/", 2)
+	n, ok := a.Networks.Get(parts[0])
+	if !ok {
+
+		writeError(w, http.StatusNotFound, ErrNoSuchNetwork)
+		return
+	}
+
+	action := ""
+	if len(parts) > 1 {
+
+		action = parts[1]
+	}
+
+	switch action {
+
+	case "":
+
+		writeJSON(w, http.StatusOK, networkStatus(n))
+
+	case "channels":
+
+		a.handleChannels(w, r, n)
+
+	case "users":
+
+		a.handleUsers(w, r, n)
+
+	case "errors":
+
+		writeJSON(w, http.StatusOK, n.Errors())
+
+	case "send", "join", "part":
+
+		a.handleAction(w, r, n, action)
+
+	default:
+
+		http.NotFound(w, r)
+	}
+}
+
+func (a *AdminServer) handleChannels(w http.ResponseWriter, r *http.Request, n *Network) {
+
+	status := []ChannelStatus{}
+	for _, name := range n.State.Channels() {
+
+		c, ok := n.State.Channel(name)
+		if !ok {
+
+			continue
+		}
+		status = append(status, ChannelStatus{Name: c.Name, Topic: c.Topic, Users: len(c.Users)})
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// Users of a channel given by the channel query parameter
+func (a *AdminServer) handleUsers(w http.ResponseWriter, r *http.Request, n *Network) {
+
+	name := r.URL.Query().Get("channel")
+	if len(name) < 1 {
+
+		writeError(w, http.StatusBadRequest, ErrMissingParam)
+		return
+	}
+
+	c, ok := n.State.Channel(name)
+	if !ok {
+
+		writeError(w, http.StatusNotFound, ErrNoSuchChannel)
+		return
+	}
+
+	users := []UserStatus{}
+	for nick, mode := range c.Users {
+
+		users = append(users, UserStatus{Nick: nick, Mode: mode})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Nick < users[j].Nick })
+
+	writeJSON(w, http.StatusOK, users)
+}
+
+type actionRequest struct {
+	Target  string `json:"target"`
+	Channel string `json:"channel"`
+	Message string `json:"message"`
+}
+
+func (a *AdminServer) handleAction(w http.ResponseWriter, r *http.Request, n *Network, action string) {
+
+	if r.Method != "POST" {
+
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllow)
+		return
+	}
+	if err := a.authorized(r); err != nil {
+
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	var req actionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var err error
+	switch action {
+
+	case "send":
+
+		if len(req.Target) < 1 || len(req.Message) < 1 {
+
+			writeError(w, http.StatusBadRequest, ErrMissingParam)
+			return
+		}
+		err = n.ClientConn.PrivMsg(req.Target, req.Message)
+
+	case "join":
+
+		if len(req.Channel) < 1 {
+
+			writeError(w, http.StatusBadRequest, ErrMissingParam)
+			return
+		}
+		err = n.ClientConn.Join(req.Channel)
+		if err == nil {
+
+			err = DB.AddChannel(&store.Channel{Network: n.Name, Name: req.Channel})
+		}
+
+	case "part":
+
+		if len(req.Channel) < 1 {
+
+			writeError(w, http.StatusBadRequest, ErrMissingParam)
+			return
+		}
+		err = n.ClientConn.Part(req.Channel)
+		if err == nil {
+
+			err = DB.RemoveChannel(n.Name, req.Channel)
+		}
+	}
+	if err != nil {
+
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	slog.Info("admin action", "network", n.Name, "action", action, "target", req.Target, "channel", req.Channel)
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+
+	"since": func(t *time.Time) string {
+
+		if t == nil {
+
+			return "-"
+		}
+		return time.Since(*t).Truncate(time.Second).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>HackBot status</title>
+<style>
+body { font-family: monospace; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+.up { color: green; }
+.down { color: red; }
+</style>
+</head>
+<body>
+<h1>HackBot</h1>
+<table>
+<tr><th>Network</th><th>Server</th><th>Nick</th><th>State</th><th>Uptime</th><th>Reconnects</th><th>Lag</th><th>Queue</th><th>Channels</th><th>Last error</th></tr>
+{{range .}}<tr>
+<td>{{.Name}}</td>
+<td>{{.Server}}</td>
+<td>{{.Nick}}</td>
+<td>{{if .Connected}}<span class="up">connected</span>{{else}}<span class="down">disconnected</span>{{end}}</td>
+<td>{{since .ConnectedSince}}</td>
+<td>{{.Reconnects}}</td>
+<td>{{.LagMillis}}ms</td>
+<td>{{.QueueDepth}}</td>
+<td>{{range .Channels}}{{.}} {{end}}</td>
+<td>{{with .LastError}}{{.Time.Format "2006-01-02 15:04:05"}} {{.Error}}{{end}}</td>
+</tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
+func (a *AdminServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+
+	if r.URL.Path != "/" {
+
+		http.NotFound(w, r)
+		return
+	}
+
+	status := []NetworkStatus{}
+	for _, n := range a.Networks.All() {
+
+		status = append(status, networkStatus(n))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, status); err != nil {
+
+		slog.Error("admin.handleStatus()", "error", err)
+	}
+}
@@ -0,0 +1,255 @@
+/*
+   Channel logs written to <Dir>/<network>/<channel>.log, or
+   <channel>.YYYY-MM-DD.log when rotating daily.
+*/
+
+package chanlog
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrRotate  = errors.New("Unknown channel log rotation")
+	ErrMaxSize = errors.New("Size rotation needs a maximum size")
+)
+
+// Rotation modes
+const (
+	RotateNone  = ""
+	RotateDaily = "daily"
+	RotateSize  = "size"
+)
+
+type Logger struct {
+	Dir     string
+	Format  Format
+	Rotate  string
+	MaxSize int64 // Bytes, for RotateSize
+
+	// Channels that are not logged, matched against "channel" and
+	// "network/channel" with path.Match, case insensitive
+	Exclude []string
+
+	mu    sync.Mutex
+	files map[string]*logFile
+}
+
+type logFile struct {
+	f    *os.File
+	path string
+	day  string
+	size int64
+}
+
+func New(dir, format, rotate string, maxSize int64, exclude []string) (l *Logger, err error) {
+
+	f, err := GetFormat(format)
+	if err != nil {
+
+		return
+	}
+
+	switch rotate {
+
+	case RotateNone, RotateDaily, RotateSize:
+
+	default:
+
+		return nil, ErrRotate
+	}
+	if rotate == RotateSize && maxSize < 1 {
+
+		return nil, ErrMaxSize
+	}
+
+	return &Logger{
+
+		Dir:     dir,
+		Format:  f,
+		Rotate:  rotate,
+		MaxSize: maxSize,
+		Exclude: exclude,
+		files:   make(map[string]*logFile),
+	}, nil
+}
+
+// Excluded reports whether the channel should not be logged.
+func (l *Logger) Excluded(network, channel string) bool {
+
+	return Excluded(l.Exclude, network, channel)
+}
+
+// Excluded reports whether the channel matches one of the patterns, globs of
+// "channel" or "network/channel".
+func Excluded(patterns []string, network, channel string) bool {
+
+	channel = strings.ToLower(channel)
+	full := strings.ToLower(network) + "/" + channel
+	for _, pattern := range patterns {
+
+		pattern = strings.ToLower(pattern)
+		if ok, _ := path.Match(pattern, channel); ok {
+
+			return true
+		}
+		if ok, _ := path.Match(pattern, full); ok {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Log writes the event to the file of its channel.
+func (l *Logger) Log(e *Event) (err error) {
+
+	if l.Excluded(e.Network, e.Channel) {
+
+		return
+	}
+
+	line := l.Format.Line(e)
+	if len(line) < 1 {
+
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lf, err := l.file(e.Network, e.Channel, e.Time)
+	if err != nil {
+
+		return
+	}
+
+	n, err := lf.f.WriteString(line + "\n")
+	lf.size += int64(n)
+
+	return
+}
+
+// Close closes all open log files.
+func (l *Logger) Close() (err error) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, lf := range l.files {
+
+		if cerr := l.close(lf, time.Now()); cerr != nil {
+
+			err = cerr
+		}
+		delete(l.files, key)
+	}
+
+	return
+}
+
+// Clean a network or channel name for use as a file name
+func fileName(s string) string {
+
+	return strings.Map(func(r rune) rune {
+
+		switch r {
+
+		case '/', '\\', ':', 0:
+
+			return '_'
+		}
+		return r
+	}, strings.ToLower(s))
+}
+
+// Returns the open file for the channel, rotating it when due
+func (l *Logger) file(network, channel string, t time.Time) (lf *logFile, err error) {
+
+	key := strings.ToLower(network + "/" + channel)
+	day := t.Format("2006-01-02")
+
+	lf, ok := l.files[key]
+	if ok {
+
+		switch {
+
+		case l.Rotate == RotateDaily && lf.day != day:
+
+			l.close(lf, t)
+			delete(l.files, key)
+
+		case l.Rotate == RotateSize && l.MaxSize > 0 && lf.size >= l.MaxSize:
+
+			l.close(lf, t)
+			delete(l.files, key)
+			if err = os.Rename(lf.path, rotatedName(lf.path, t)); err != nil {
+
+				return nil, err
+			}
+
+		default:
+
+			return lf, nil
+		}
+	}
+
+	dir := filepath.Join(l.Dir, fileName(network))
+	if err = os.MkdirAll(dir, 0750); err != nil {
+
+		return
+	}
+
+	name := fileName(channel)
+	if l.Rotate == RotateDaily {
+
+		name += "." + day
+	}
+	p := filepath.Join(dir, name+".log")
+
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+
+		return
+	}
+	fi, err := f.Stat()
+	if err != nil {
+
+		f.Close()
+		return
+	}
+
+	lf = &logFile{f: f, path: p, day: day, size: fi.Size()}
+	if opened := l.Format.Opened(t); len(opened) > 0 {
+
+		n, _ := f.WriteString(opened + "\n")
+		lf.size += int64(n)
+	}
+	l.files[key] = lf
+
+	return
+}
+
+func (l *Logger) close(lf *logFile, t time.Time) error {
+
+	if closed := l.Format.Closed(t); len(closed) > 0 {
+
+		lf.f.WriteString(closed + "\n")
+	}
+
+	return lf.f.Close()
+}
+
+// Name a size rotated file after the time it was rotated
+func rotatedName(p string, t time.Time) string {
+
+	return strings.TrimSuffix(p, ".log") + "." + t.Format("20060102-150405") + ".log"
+}
@@ -0,0 +1,155 @@
+package chanlog
+
+import (
+	"strings"
+	"time"
+
+	"github.com/sorcix/irc"
+)
+
+// Event types
+const (
+	Message = "message"
+	Action  = "action"
+	Notice  = "notice"
+	Join    = "join"
+	Part    = "part"
+	Quit    = "quit"
+	Nick    = "nick"
+	Topic   = "topic"
+	Mode    = "mode"
+	Kick    = "kick"
+)
+
+// An Event is one line of a channel log
+type Event struct {
+	Time    time.Time `json:"time"`
+	Network string    `json:"network"`
+	Channel string    `json:"channel"`
+	Type    string    `json:"type"`
+	Nick    string    `json:"nick"`
+	Host    string    `json:"host,omitempty"`   // user@host of Nick
+	Target  string    `json:"target,omitempty"` // New nick or kicked user
+	Text    string    `json:"text,omitempty"`   // Message, reason, topic or mode
+}
+
+func IsChannel(target string) bool {
+
+	return len(target) > 0 && strings.ContainsRune("#&+!", rune(target[0]))
+}
+
+// Events converts a message into channel events. self is used as the nick for
+// messages without a prefix, i.e. the ones we sent. channels returns the
+// channels a nick is in, needed for QUIT and NICK which carry no channel.
+func Events(network string, m *irc.Message, self string, channels func(nick string) []string) (events []Event) {
+
+	e := Event{Time: time.Now(), Network: network, Nick: self}
+	if m.Prefix != nil && len(m.Prefix.Name) > 0 {
+
+		e.Nick = m.Prefix.Name
+		if len(m.Prefix.Host) > 0 {
+
+			e.Host = m.Prefix.User + "@" + m.Prefix.Host
+		}
+	}
+
+	// First parameter, or the trailing one for servers that send it there
+	first := m.Trailing
+	if len(m.Params) > 0 {
+
+		first = m.Params[0]
+	}
+
+	add := func(typ, channel, target, text string) {
+
+		if !IsChannel(channel) {
+
+			return
+		}
+		ev := e
+		ev.Type = typ
+		ev.Channel = channel
+		ev.Target = target
+		ev.Text = text
+		events = append(events, ev)
+	}
+
+	switch m.Command {
+
+	case irc.PRIVMSG:
+
+		if strings.HasPrefix(m.Trailing, "\x01ACTION ") {
+
+			add(Action, first, "", strings.TrimSuffix(strings.TrimPrefix(m.Trailing, "\x01ACTION "), "\x01"))
+			break
+		}
+		if strings.HasPrefix(m.Trailing, "\x01") {
+
+			// Other CTCP requests are not logged
+			break
+		}
+		add(Message, first, "", m.Trailing)
+
+	case irc.NOTICE:
+
+		add(Notice, first, "", m.Trailing)
+
+	case irc.JOIN:
+
+		add(Join, first, "", "")
+
+	case irc.PART:
+
+		if len(m.Params) > 0 {
+
+			add(Part, m.Params[0], "", m.Trailing)
+		}
+
+	case irc.KICK:
+
+		if len(m.Params) > 1 {
+
+			add(Kick, m.Params[0], m.Params[1], m.Trailing)
+		}
+
+	case irc.TOPIC:
+
+		add(Topic, first, "", m.Trailing)
+
+	case irc.MODE:
+
+		if len(m.Params) > 1 {
+
+			text := strings.Join(m.Params[1:], " ")
+			if len(m.Trailing) > 0 {
+
+				text += " " + m.Trailing
+			}
+			add(Mode, m.Params[0], "", text)
+		}
+
+	case irc.QUIT:
+
+		if channels == nil {
+
+			break
+		}
+		for _, c := range channels(e.Nick) {
+
+			add(Quit, c, "", m.Trailing)
+		}
+
+	case irc.NICK:
+
+		if channels == nil {
+
+			break
+		}
+		for _, c := range channels(e.Nick) {
+
+			add(Nick, c, first, "")
+		}
+	}
+
+	return
+}
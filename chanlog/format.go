@@ -0,0 +1,184 @@
+package chanlog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors
+var (
+	ErrFormat = errors.New("Unknown channel log format")
+)
+
+// A Format renders events as lines in a log file
+type Format interface {
+
+	// Line for the event, without the newline
+	Line(e *Event) string
+
+	// Lines written when a file is opened and closed, may be empty
+	Opened(t time.Time) string
+	Closed(t time.Time) string
+}
+
+// Formats by configuration name
+var Formats = map[string]Format{
+
+	"irssi":   IrssiFormat{},
+	"weechat": WeechatFormat{},
+	"json":    JSONFormat{},
+}
+
+func GetFormat(name string) (Format, error) {
+
+	if len(name) < 1 {
+
+		name = "irssi"
+	}
+
+	f, ok := Formats[name]
+	if !ok {
+
+		return nil, ErrFormat
+	}
+
+	return f, nil
+}
+
+// The default irssi text format
+type IrssiFormat struct{}
+
+func (IrssiFormat) Line(e *Event) string {
+
+	ts := e.Time.Format("15:04")
+	switch e.Type {
+
+	case Message:
+
+		return fmt.Sprintf("%s <%s> %s", ts, e.Nick, e.Text)
+
+	case Action:
+
+		return fmt.Sprintf("%s  * %s %s", ts, e.Nick, e.Text)
+
+	case Notice:
+
+		return fmt.Sprintf("%s -%s:%s- %s", ts, e.Nick, e.Channel, e.Text)
+
+	case Join:
+
+		return fmt.Sprintf("%s -!- %s [%s] has joined %s", ts, e.Nick, e.Host, e.Channel)
+
+	case Part:
+
+		return fmt.Sprintf("%s -!- %s [%s] has left %s [%s]", ts, e.Nick, e.Host, e.Channel, e.Text)
+
+	case Quit:
+
+		return fmt.Sprintf("%s -!- %s [%s] has quit [%s]", ts, e.Nick, e.Host, e.Text)
+
+	case Nick:
+
+		return fmt.Sprintf("%s -!- %s is now known as %s", ts, e.Nick, e.Target)
+
+	case Topic:
+
+		return fmt.Sprintf("%s -!- %s changed the topic of %s to: %s", ts, e.Nick, e.Channel, e.Text)
+
+	case Mode:
+
+		return fmt.Sprintf("%s -!- mode/%s [%s] by %s", ts, e.Channel, e.Text, e.Nick)
+
+	case Kick:
+
+		return fmt.Sprintf("%s -!- %s was kicked from %s by %s [%s]", ts, e.Target, e.Channel, e.Nick, e.Text)
+	}
+
+	return fmt.Sprintf("%s -!- %s %s", ts, e.Nick, e.Text)
+}
+
+func (IrssiFormat) Opened(t time.Time) string {
+
+	return "--- Log opened " + t.Format("Mon Jan 02 15:04:05 2006")
+}
+
+func (IrssiFormat) Closed(t time.Time) string {
+
+	return "--- Log closed " + t.Format("Mon Jan 02 15:04:05 2006")
+}
+
+// The weechat logger format, tab separated with the prefix in the middle
+type WeechatFormat struct{}
+
+func (WeechatFormat) Line(e *Event) string {
+
+	ts := e.Time.Format("2006-01-02 15:04:05")
+	switch e.Type {
+
+	case Message:
+
+		return fmt.Sprintf("%s\t%s\t%s", ts, e.Nick, e.Text)
+
+	case Action:
+
+		return fmt.Sprintf("%s\t *\t%s %s", ts, e.Nick, e.Text)
+
+	case Notice:
+
+		return fmt.Sprintf("%s\t--\tNotice(%s) -> %s: %s", ts, e.Nick, e.Channel, e.Text)
+
+	case Join:
+
+		return fmt.Sprintf("%s\t-->\t%s (%s) has joined %s", ts, e.Nick, e.Host, e.Channel)
+
+	case Part:
+
+		return fmt.Sprintf("%s\t<--\t%s (%s) has left %s (%s)", ts, e.Nick, e.Host, e.Channel, e.Text)
+
+	case Quit:
+
+		return fmt.Sprintf("%s\t<--\t%s (%s) has quit (%s)", ts, e.Nick, e.Host, e.Text)
+
+	case Nick:
+
+		return fmt.Sprintf("%s\t--\t%s is now known as %s", ts, e.Nick, e.Target)
+
+	case Topic:
+
+		return fmt.Sprintf("%s\t--\t%s has changed topic for %s to \"%s\"", ts, e.Nick, e.Channel, e.Text)
+
+	case Mode:
+
+		return fmt.Sprintf("%s\t--\tMode %s [%s] by %s", ts, e.Channel, e.Text, e.Nick)
+
+	case Kick:
+
+		return fmt.Sprintf("%s\t<--\t%s has kicked %s from %s (%s)", ts, e.Nick, e.Target, e.Channel, e.Text)
+	}
+
+	return fmt.Sprintf("%s\t--\t%s %s", ts, e.Nick, e.Text)
+}
+
+func (WeechatFormat) Opened(t time.Time) string { return "" }
+
+func (WeechatFormat) Closed(t time.Time) string { return "" }
+
+// One JSON object per line
+type JSONFormat struct{}
+
+func (JSONFormat) Line(e *Event) string {
+
+	b, err := json.Marshal(e)
+	if err != nil {
+
+		return ""
+	}
+
+	return string(b)
+}
+
+func (JSONFormat) Opened(t time.Time) string { return "" }
+
+func (JSONFormat) Closed(t time.Time) string { return "" }
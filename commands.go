@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sorcix/irc"
+)
+
+// Errors
+var (
+	ErrDuration = errors.New("Invalid duration")
+)
+
+// Prefix of bot commands
+const CommandPrefix = "!"
+
+// A Command is run for messages starting with CommandPrefix and its name
+type Command struct {
+	Name   string
+	Usage  string
+	Plugin string // Name of the plugin providing the command, empty when built in
+
+	// Called with the text following the command name
+	Run func(h *HandlerFuncs, m *irc.Message, args string) error
+}
+
+// Registry of the commands
+type CommandList struct {
+	mu       sync.RWMutex
+	commands map[string]*Command
+}
+
+var Commands = &CommandList{commands: make(map[string]*Command)}
+
+func (l *CommandList) Register(c *Command) {
+
+	l.mu.Lock()
+	l.commands[strings.ToLower(c.Name)] = c
+	l.mu.Unlock()
+}
+
+func (l *CommandList) Unregister(name string) {
+
+	l.mu.Lock()
+	delete(l.commands, strings.ToLower(name))
+	l.mu.Unlock()
+}
+
+func (l *CommandList) Get(name string) (c *Command, ok bool) {
+
+	l.mu.RLock()
+	c, ok = l.commands[strings.ToLower(name)]
+	l.mu.RUnlock()
+
+	return
+}
+
+// Names returns the registered command names in sorted order.
+func (l *CommandList) Names() (names []string) {
+
+	l.mu.RLock()
+	for name := range l.commands {
+
+		names = append(names, name)
+	}
+	l.mu.RUnlock()
+	sort.Strings(names)
+
+	return
+}
+
+// Split a message into command name and arguments
+func parseCommand(text string) (name, args string, ok bool) {
+
+	if !strings.HasPrefix(text, CommandPrefix) {
+
+		return
+	}
+
+	text = strings.TrimPrefix(text, CommandPrefix)
+	name, args, _ = strings.Cut(text, " ")
+	if len(name) < 1 {
+
+		return
+	}
+
+	return name, strings.TrimSpace(args), true
+}
+
+// Run the registered command in the message, if any. handled is false when the
+// message is not a known command.
+func (h *HandlerFuncs) RunCommand(m *irc.Message) (handled bool, err error) {
+
+	name, args, ok := parseCommand(m.Trailing)
+	if !ok {
+
+		return
+	}
+
+	c, ok := Commands.Get(name)
+	if !ok {
+
+		return
+	}
+
+	return true, c.Run(h, m, args)
+}
+
+// IsAdmin reports whether the sender of the message is a bot admin, either by
+// matching one of the configured Admins or as an admin user in the database
+// seen from the same user@host.
+func (h *HandlerFuncs) IsAdmin(m *irc.Message) bool {
+
+	if m.Prefix == nil {
+
+		return false
+	}
+
+	mask := strings.ToLower(m.Prefix.String())
+	account := strings.ToLower(h.ClientConn.State.Account(m.Prefix.Name))
+	for _, pattern := range h.Admins {
+
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "$a:") {
+
+			if len(account) > 0 && account == pattern[3:] {
+
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, mask); ok {
+
+			return true
+		}
+	}
+
+	u, err := DB.GetUser(h.Name, m.Prefix.Name)
+	if err != nil {
+
+		return false
+	}
+
+	return u.Admin && strings.EqualFold(u.Host, m.Prefix.User+"@"+m.Prefix.Host)
+}
+
+// Split arguments on spaces, keeping "quoted strings" together
+func splitArgs(s string) (args []string) {
+
+	var cur strings.Builder
+	quoted := false
+	inArg := false
+	for _, r := range s {
+
+		switch {
+
+		case r == '"':
+
+			quoted = !quoted
+			inArg = true
+
+		case r == ' ' && !quoted:
+
+			if inArg {
+
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+
+		default:
+
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+
+		args = append(args, cur.String())
+	}
+
+	return
+}
+
+// Longest duration parseDuration accepts, about 292 years
+const maxDuration = time.Duration(math.MaxInt64)
+
+// Parse durations like 90s, 2h30m, 3d or 1w2d
+func parseDuration(s string) (d time.Duration, err error) {
+
+	if len(s) < 1 {
+
+		return 0, ErrDuration
+	}
+
+	for len(s) > 0 {
+
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+
+			i++
+		}
+		if i == 0 || i == len(s) {
+
+			return 0, ErrDuration
+		}
+
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+
+			return 0, ErrDuration
+		}
+
+		var unit time.Duration
+		switch s[i] {
+
+		case 's':
+
+			unit = time.Second
+
+		case 'm':
+
+			unit = time.Minute
+
+		case 'h':
+
+			unit = time.Hour
+
+		case 'd':
+
+			unit = 24 * time.Hour
+
+		case 'w':
+
+			unit = 7 * 24 * time.Hour
+
+		default:
+
+			return 0, ErrDuration
+		}
+		// Stay within the range of a time.Duration
+		if time.Duration(n) > maxDuration/unit || d > maxDuration-time.Duration(n)*unit {
+
+			return 0, ErrDuration
+		}
+		d += time.Duration(n) * unit
+		s = s[i+1:]
+	}
+
+	return
+}
+
+// Format a duration for humans, e.g. 3h ago or 2d5h
+func formatDuration(d time.Duration) string {
+
+	d = d.Truncate(time.Second)
+	switch {
+
+	case d < time.Minute:
+
+		return strconv.Itoa(int(d/time.Second)) + "s"
+
+	case d < time.Hour:
+
+		return strconv.Itoa(int(d/time.Minute)) + "m"
+
+	case d < 24*time.Hour:
+
+		h := int(d / time.Hour)
+		if m := int(d%time.Hour) / int(time.Minute); m > 0 {
+
+			return strconv.Itoa(h) + "h" + strconv.Itoa(m) + "m"
+		}
+		return strconv.Itoa(h) + "h"
+	}
+
+	days := int(d / (24 * time.Hour))
+	if h := int(d%(24*time.Hour)) / int(time.Hour); h > 0 {
+
+		return strconv.Itoa(days) + "d" + strconv.Itoa(h) + "h"
+	}
+	return strconv.Itoa(days) + "d"
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+
+	tests := []struct {
+		in  string
+		d   time.Duration
+		err error
+	}{
+		{"90s", 90 * time.Second, nil},
+		{"5m", 5 * time.Minute, nil},
+		{"2h30m", 2*time.Hour + 30*time.Minute, nil},
+		{"3d", 72 * time.Hour, nil},
+		{"1w2d", 9 * 24 * time.Hour, nil},
+		{"0s", 0, nil},
+		{"15250w", 15250 * 7 * 24 * time.Hour, nil},
+		{"", 0, ErrDuration},
+		{"5", 0, ErrDuration},
+		{"h", 0, ErrDuration},
+		{"5y", 0, ErrDuration},
+		{"-5m", 0, ErrDuration},
+		{"2h 30m", 0, ErrDuration},
+
+		// Beyond the range of a time.Duration
+		{"31000w", 0, ErrDuration},
+		{"15250w15250w", 0, ErrDuration},
+		{"9223372036854775807s", 0, ErrDuration},
+		{"99999999999999999999s", 0, ErrDuration},
+	}
+
+	for _, test := range tests {
+
+		d, err := parseDuration(test.in)
+		if d != test.d || err != test.err {
+
+			t.Errorf("parseDuration(%q) = %v, %v, want %v, %v", test.in, d, err, test.d, test.err)
+		}
+	}
+}
@@ -2,12 +2,48 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/TheCreeper/HackBot/chanlog"
+	"github.com/TheCreeper/HackBot/plugin"
+	"github.com/TheCreeper/HackBot/store"
+)
+
+// Errors
+var (
+	ErrTriggersConfig = errors.New("Triggers moved to the Config of the triggers plugin")
 )
 
+// Directory of the bundled plugins when BundledPlugins.Dir is not set
+const defaultBundledDir = "bin/plugins"
+
+// Plugins shipped with the bot and the capabilities they are granted
+var bundledPlugins = []PluginConfig{
+	{Name: "ddg", Capabilities: []string{plugin.CapSend, plugin.CapHTTP}},
+	{Name: "urltitle", Capabilities: []string{plugin.CapSend, plugin.CapHTTP}},
+	{Name: "triggers", Capabilities: []string{plugin.CapSend, plugin.CapState}},
+}
+
 var (
 	ConfigFile string
+
+	// Channel logs, nil when disabled
+	ChannelLog *chanlog.Logger
+
+	// Bot database
+	DB store.Store
+
+	// Plugin host, nil when no plugins are configured
+	Plugins *PluginHost
+
+	// Channels kept out of the logs, the history and seen data
+	PrivateChannels []string
 )
 
 type ClientConfig struct {
@@ -19,6 +55,10 @@ type ClientConfig struct {
 		CTCPVersion string
 
 		ReconnectIntervalSeconds int
+		PingIntervalSeconds      int
+
+		// Bot admins, nick!user@host globs or $a:account
+		Admins []string
 	}
 
 	Proxys []struct {
@@ -31,6 +71,98 @@ type ClientConfig struct {
 	}
 
 	Servers []Server
+
+	// Logging
+	Log struct {
+		Level string // debug, info, warn or error
+		JSON  bool   // Write JSON lines instead of text
+	}
+
+	// Channel logs on disk
+	ChannelLog struct {
+		Dir       string   // Directory for the logs, disabled when empty
+		Format    string   // irssi, weechat or json
+		Rotate    string   // daily, size or empty for never
+		MaxSizeMB int      // Size at which logs are rotated, required for size
+		Exclude   []string // Channels not logged, e.g. "#secret" or "freenode/#ops"
+	}
+
+	// Channels kept out of the channel logs, the history and seen data,
+	// whether or not channel logs are written
+	Privacy struct {
+		Exclude []string // e.g. "#secret" or "freenode/#ops"
+	}
+
+	// Channel history searched with !grep and !last
+	History struct {
+		Enabled       bool // Index channel messages, off by default
+		RetentionDays int  // Messages older than this are removed, 30 by default
+	}
+
+	// Bot database
+	Database struct {
+		Driver string // sqlite (default) or mysql
+		Path   string // SQLite database file
+
+		// MySQL connection
+		Name     string
+		Host     string
+		User     string
+		Password string
+	}
+
+	// Messages left with !tell
+	Memos struct {
+		MaxPerSender int    // Pending memos per sender
+		ExpiryDays   int    // Memos not delivered by then are dropped
+		Delivery     string // notice (default) or channel
+	}
+
+	// Reminders set with !remind and !at
+	Reminders struct {
+		MaxPerSender int // Pending reminders per sender, 10 by default
+	}
+
+	// Karma rate limits
+	Karma struct {
+		PerHour         int // Karma changes per user an hour
+		CooldownSeconds int // Between changes of the same thing by a user
+	}
+
+	// Messages sent to channels on a cron schedule
+	Announcements []struct {
+		Network  string
+		Channel  string
+		Schedule string // e.g. "0 9 * * 1-5" or "@daily"
+		Message  string
+	}
+
+	// Plugin executables
+	Plugins []PluginConfig
+
+	// Plugins shipped with the bot, ddg, urltitle and triggers, started from
+	// Dir unless disabled or configured in Plugins under the same name
+	BundledPlugins struct {
+		Dir     string   // bin/plugins by default
+		Disable []string // Names of bundled plugins not to start
+	}
+
+	// TCP listener for remote plugins, TLS when a certificate is set which
+	// is required unless the address is loopback
+	PluginListen struct {
+		Address string
+		TLSCert string
+		TLSKey  string
+	}
+
+	// Lua scripts run inside the bot
+	Scripts ScriptsConfig
+
+	// Embedded HTTP admin api and status page
+	Admin struct {
+		Listen string // Address to listen on, disabled when empty
+		Token  string // Bearer token required for actions
+	}
 }
 
 type Server struct {
@@ -56,6 +188,127 @@ type Server struct {
 
 	ReconnectIntervalSeconds int
 	ReconnectMultiplier      int
+
+	PingIntervalSeconds int
+
+	// IRCv3 capabilities to request, extended-join and account-notify by default
+	Capabilities []string
+
+	// Bot admins on the network, the global admins when not set
+	Admins []string
+}
+
+type PluginConfig struct {
+	Name string
+	Path string   // Executable, or a WebAssembly module ending in .wasm
+	Args []string // Arguments passed to the executable
+
+	// Configuration of the plugin, passed to executables in
+	// HACKBOT_PLUGIN_CONFIG and to WebAssembly modules at init
+	Config json.RawMessage
+
+	// Manifest file, the executable path with .json appended by default
+	Manifest string
+
+	// Remote plugins are not started by the bot, they connect to the
+	// PluginListen address with the secret, of at least 16 characters, as
+	// their token
+	Remote bool
+	Secret string
+
+	// Capabilities granted of those the manifest requests, only send when
+	// not set
+	Capabilities []string
+
+	// Where the plugin is enabled, network names and "#channel" or
+	// "network/#channel" globs, everywhere when empty
+	Networks []string
+	Channels []string
+
+	CallTimeoutSeconds int // Deadline of calls into the plugin, 10 by default and 1 for WebAssembly events
+	HeartbeatSeconds   int // Interval of heartbeats, 30 by default
+
+	// Quotas of the plugin's storage, 1024KB and 10000 keys by default
+	StorageKB   int
+	StorageKeys int
+
+	// Resource limits of the process, Linux only, or of the WebAssembly
+	// module
+	Limits PluginLimits
+}
+
+type PluginLimits struct {
+	CPUSeconds int
+	MemoryMB   int // 64 by default for WebAssembly modules
+	OpenFiles  int
+	Calls      int // Function calls per event of WebAssembly modules, 10000000 by default
+}
+
+type ScriptsConfig struct {
+	Dir string // Directory of .lua files, disabled when empty
+
+	// Capabilities granted to every script, only send when not set
+	Capabilities []string
+
+	// Where scripts are enabled, as for plugins
+	Networks []string
+	Channels []string
+
+	TimeoutSeconds int // Longest a handler runs, 5 by default
+	MemoryKB       int // Data a script keeps between handlers, estimated after each, 4096 by default
+}
+
+// The private channels, those of Privacy along with the ones excluded from
+// the channel logs
+func (cfg *ClientConfig) privateChannels() (channels []string) {
+
+	channels = append(channels, cfg.Privacy.Exclude...)
+	channels = append(channels, cfg.ChannelLog.Exclude...)
+
+	return
+}
+
+// The configured plugins followed by the bundled ones that are neither
+// disabled nor configured. Bundled plugins that were not built are skipped.
+func (cfg *ClientConfig) plugins() (plugins []PluginConfig) {
+
+	plugins = append(plugins, cfg.Plugins...)
+
+	dir := cfg.BundledPlugins.Dir
+	if len(dir) < 1 {
+
+		dir = defaultBundledDir
+	}
+	for _, p := range bundledPlugins {
+
+		if contains(cfg.BundledPlugins.Disable, p.Name) || configured(cfg.Plugins, p.Name) {
+
+			continue
+		}
+
+		p.Path = filepath.Join(dir, p.Name)
+		if _, err := os.Stat(p.Path); err != nil {
+
+			slog.Warn("bundled plugin not started", "plugin", p.Name, "error", err)
+			continue
+		}
+		plugins = append(plugins, p)
+	}
+
+	return
+}
+
+func configured(plugins []PluginConfig, name string) bool {
+
+	for _, p := range plugins {
+
+		if strings.EqualFold(p.Name, name) {
+
+			return true
+		}
+	}
+
+	return false
 }
 
 func (cfg *ClientConfig) validate() (err error) {
@@ -96,6 +349,18 @@ func (cfg *ClientConfig) validate() (err error) {
 
 			srv[i].ReconnectIntervalSeconds = glob.ReconnectIntervalSeconds
 		}
+		if srv[i].Capabilities == nil {
+
+			srv[i].Capabilities = []string{"extended-join", "account-notify"}
+		}
+		if srv[i].PingIntervalSeconds == 0 {
+
+			srv[i].PingIntervalSeconds = glob.PingIntervalSeconds
+		}
+		if srv[i].Admins == nil {
+
+			srv[i].Admins = glob.Admins
+		}
 	}
 
 	return
@@ -109,6 +374,22 @@ func GetCFG(f string) (cfg ClientConfig, err error) {
 		return
 	}
 
+	// Settings that moved out of the bot
+	var keys map[string]json.RawMessage
+	err = json.Unmarshal(b, &keys)
+	if err != nil {
+
+		return
+	}
+	for k := range keys {
+
+		if strings.EqualFold(k, "Triggers") {
+
+			err = ErrTriggersConfig
+			return
+		}
+	}
+
 	err = json.Unmarshal(b, &cfg)
 	if err != nil {
 
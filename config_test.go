@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGetCFGTriggers(t *testing.T) {
+
+	tests := []struct {
+		config string
+		err    error
+	}{
+		{`{"Globals": {"Nick": "bot"}}`, nil},
+		{`{"Triggers": {"Packs": ["portal"]}}`, ErrTriggersConfig},
+		{`{"triggers": []}`, ErrTriggersConfig},
+	}
+
+	for _, test := range tests {
+
+		f := filepath.Join(t.TempDir(), "config.json")
+		if err := ioutil.WriteFile(f, []byte(test.config), 0600); err != nil {
+
+			t.Fatal(err)
+		}
+		if _, err := GetCFG(f); err != test.err {
+
+			t.Errorf("GetCFG(%s) = %v, want %v", test.config, err, test.err)
+		}
+	}
+}
+
+func TestBundledPlugins(t *testing.T) {
+
+	dir := t.TempDir()
+	for _, name := range []string{"ddg", "urltitle"} {
+
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0700); err != nil {
+
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		plugins []PluginConfig
+		disable []string
+		names   []string
+	}{
+		{nil, nil, []string{"ddg", "urltitle"}},
+		{nil, []string{"DDG"}, []string{"urltitle"}},
+		{[]PluginConfig{{Name: "urltitle", Path: "/opt/urltitle"}}, nil, []string{"urltitle", "ddg"}},
+	}
+
+	for _, test := range tests {
+
+		var cfg ClientConfig
+		cfg.Plugins = test.plugins
+		cfg.BundledPlugins.Dir = dir
+		cfg.BundledPlugins.Disable = test.disable
+
+		var names []string
+		for _, p := range cfg.plugins() {
+
+			names = append(names, p.Name)
+			if p.Path == filepath.Join(dir, p.Name) && len(p.Capabilities) < 1 {
+
+				t.Errorf("bundled plugin %s has no capabilities", p.Name)
+			}
+		}
+		if !reflect.DeepEqual(names, test.names) {
+
+			t.Errorf("plugins() with %v disabled = %v, want %v", test.disable, names, test.names)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Package cron parses standard five field cron schedules:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Fields accept *, numbers, ranges (1-5), lists (1,3,5) and steps (*/15,
+// 0-30/10). Day of week runs from 0 (Sunday) to 6, 7 is also Sunday.
+// Names of months and days are not supported. The shortcuts @hourly,
+// @daily, @weekly, @monthly and @yearly are.
+package cron
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors
+var (
+	ErrFields = errors.New("Cron schedule needs five fields")
+	ErrField  = errors.New("Invalid cron field")
+)
+
+var shortcuts = map[string]string{
+
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // Bit sets of allowed values
+
+	// Whether the day fields start with *, unrestricted as in */2. If
+	// neither does the day matches when either field does
+	domStar, dowStar bool
+}
+
+func Parse(spec string) (s *Schedule, err error) {
+
+	if full, ok := shortcuts[strings.TrimSpace(spec)]; ok {
+
+		spec = full
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+
+		return nil, ErrFields
+	}
+
+	s = &Schedule{
+
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	if s.minute, err = parseField(fields[0], 0, 59); err != nil {
+
+		return nil, err
+	}
+	if s.hour, err = parseField(fields[1], 0, 23); err != nil {
+
+		return nil, err
+	}
+	if s.dom, err = parseField(fields[2], 1, 31); err != nil {
+
+		return nil, err
+	}
+	if s.month, err = parseField(fields[3], 1, 12); err != nil {
+
+		return nil, err
+	}
+	if s.dow, err = parseField(fields[4], 0, 7); err != nil {
+
+		return nil, err
+	}
+
+	// Sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+
+		s.dow |= 1
+	}
+
+	return
+}
+
+func parseField(field string, min, max int) (bits uint64, err error) {
+
+	for _, part := range strings.Split(field, ",") {
+
+		step := 1
+		if rng, st, ok := strings.Cut(part, "/"); ok {
+
+			if step, err = strconv.Atoi(st); err != nil || step < 1 {
+
+				return 0, ErrField
+			}
+			part = rng
+		}
+
+		lo, hi := min, max
+		switch {
+
+		case part == "*":
+
+		case strings.Contains(part, "-"):
+
+			a, b, _ := strings.Cut(part, "-")
+			if lo, err = strconv.Atoi(a); err != nil {
+
+				return 0, ErrField
+			}
+			if hi, err = strconv.Atoi(b); err != nil {
+
+				return 0, ErrField
+			}
+
+		default:
+
+			if lo, err = strconv.Atoi(part); err != nil {
+
+				return 0, ErrField
+			}
+			hi = lo
+			if step > 1 {
+
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+
+			return 0, ErrField
+		}
+
+		for v := lo; v <= hi; v += step {
+
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return
+}
+
+// Match reports whether the schedule fires in the minute of t.
+func (s *Schedule) Match(t time.Time) bool {
+
+	if s.minute&(1<<uint(t.Minute())) == 0 ||
+		s.hour&(1<<uint(t.Hour())) == 0 ||
+		s.month&(1<<uint(t.Month())) == 0 {
+
+		return false
+	}
+
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+
+		return dom && dow
+	}
+
+	return dom || dow
+}
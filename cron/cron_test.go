@@ -0,0 +1,97 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+
+	tests := []struct {
+		spec string
+		err  error
+	}{
+		{"* * * *", ErrFields},
+		{"* * * * * *", ErrFields},
+		{"60 * * * *", ErrField},
+		{"* 24 * * *", ErrField},
+		{"* * 0 * *", ErrField},
+		{"* * * 13 *", ErrField},
+		{"* * * * 8", ErrField},
+		{"*/0 * * * *", ErrField},
+		{"5-1 * * * *", ErrField},
+		{"a * * * *", ErrField},
+		{"1-a * * * *", ErrField},
+	}
+
+	for _, test := range tests {
+
+		if _, err := Parse(test.spec); err != test.err {
+
+			t.Errorf("Parse(%q) = %v, want %v", test.spec, err, test.err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+
+	// 2024-01-01 is a Monday
+	at := func(day, hour, minute int) time.Time {
+
+		return time.Date(2024, time.January, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		spec  string
+		time  time.Time
+		match bool
+	}{
+		{"* * * * *", at(3, 12, 34), true},
+		{"*/15 * * * *", at(1, 0, 30), true},
+		{"*/15 * * * *", at(1, 0, 31), false},
+		{"0-30/10 * * * *", at(1, 0, 20), true},
+		{"0-30/10 * * * *", at(1, 0, 40), false},
+		{"5,35 * * * *", at(1, 0, 35), true},
+		{"0 9 * * 1-5", at(1, 9, 0), true},
+		{"0 9 * * 1-5", at(7, 9, 0), false},
+		{"0 9 * * 1-5", at(1, 10, 0), false},
+		{"0 0 * * 7", at(7, 0, 0), true},
+		{"0 0 * * 0", at(7, 0, 0), true},
+		{"0 0 1 * *", at(1, 0, 0), true},
+		{"0 0 1 * *", at(2, 0, 0), false},
+		{"0 0 * 2 *", at(1, 0, 0), false},
+
+		// Both day fields restricted, either matches
+		{"0 0 1 * 0", at(1, 0, 0), true},
+		{"0 0 1 * 0", at(7, 0, 0), true},
+		{"0 0 1 * 0", at(2, 0, 0), false},
+
+		// A day field starting with * is unrestricted, both must match
+		{"0 0 */2 * 1", at(15, 0, 0), true},
+		{"0 0 */2 * 1", at(3, 0, 0), false},
+		{"0 0 1 * */2", at(1, 0, 0), false},
+		{"0 0 1 * */2", at(7, 0, 0), false},
+
+		{"@hourly", at(2, 5, 0), true},
+		{"@hourly", at(2, 5, 1), false},
+		{"@daily", at(2, 0, 0), true},
+		{"@weekly", at(7, 0, 0), true},
+		{"@weekly", at(1, 0, 0), false},
+		{"@monthly", at(1, 0, 0), true},
+		{"@yearly", at(1, 0, 0), true},
+	}
+
+	for _, test := range tests {
+
+		s, err := Parse(test.spec)
+		if err != nil {
+
+			t.Errorf("Parse(%q): %v", test.spec, err)
+			continue
+		}
+		if got := s.Match(test.time); got != test.match {
+
+			t.Errorf("%q.Match(%s) = %v, want %v", test.spec, test.time.Format("Mon 2006-01-02 15:04"), got, test.match)
+		}
+	}
+}
@@ -1,58 +1,18 @@
 package main
 
 import (
-	"database/sql"
-	"fmt"
-
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/TheCreeper/HackBot/store"
 )
 
-type Database struct {
-	sql *sql.DB
-}
-
-func DatabaseConnect(database, host, user, password string) (db *Database, err error) {
-
-	sql, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s)/%s", user, password, host, database))
-	if err != nil {
-
-		return
-	}
-	db.sql = sql
-
-	return
-}
-
-func (db *Database) NewClient(nick, lastseen string) (r sql.Result, err error) {
-
-	err = db.sql.Ping()
-	if err != nil {
-
-		return
-	}
-
-	return db.sql.Exec("INSERT INTO clients VALUES (?)", nick, lastseen)
-}
-
-func (db *Database) UpdateClient(nick, lastseen string) (r sql.Result, err error) {
-
-	err = db.sql.Ping()
-	if err != nil {
-
-		return
-	}
-
-	return db.sql.Exec("UPDATE clients SET (?) WHERE nick=?", lastseen, nick)
-}
-
-func (db *Database) GetClient(nick string) (lastseen string, err error) {
+// Open the bot database configured in the Database section, SQLite unless
+// the mysql driver is selected.
+func OpenDatabase(cfg *ClientConfig) (store.Store, error) {
 
-	err = db.sql.Ping()
-	if err != nil {
+	db := cfg.Database
+	if db.Driver == "mysql" {
 
-		return
+		return store.Open(db.Driver, store.MySQLDSN(db.Name, db.Host, db.User, db.Password))
 	}
 
-	err = db.sql.QueryRow("SELECT lastseen FROM clients WHERE nick=?", nick).Scan(&lastseen)
-	return
+	return store.Open(db.Driver, db.Path)
 }
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TheCreeper/HackBot/store"
+	"github.com/sorcix/irc"
+)
+
+// Errors
+var (
+	ErrFactoidLoop = errors.New("Factoid aliases loop")
+)
+
+// Limits of factoids
+const (
+	maxFactoidName    = 64
+	maxFactoidText    = 400
+	maxFactoidAliases = 5 // Redirects followed on lookup
+	factoidHistory    = 5 // Edits shown by !factinfo
+)
+
+func registerFactoidCommands() {
+
+	Commands.Register(&Command{
+
+		Name:  "learn",
+		Usage: "!learn <name> is <text>",
+		Run:   runLearn,
+	})
+	Commands.Register(&Command{
+
+		Name:  "append",
+		Usage: "!append <name> <text>",
+		Run:   runAppend,
+	})
+	Commands.Register(&Command{
+
+		Name:  "forget",
+		Usage: "!forget <name>",
+		Run:   runForget,
+	})
+	Commands.Register(&Command{
+
+		Name:  "alias",
+		Usage: "!alias <name> <factoid>",
+		Run:   runAlias,
+	})
+	Commands.Register(&Command{
+
+		Name:  "lock",
+		Usage: "!lock <name>",
+		Run: func(h *HandlerFuncs, m *irc.Message, args string) error {
+
+			return runLock(h, m, args, true)
+		},
+	})
+	Commands.Register(&Command{
+
+		Name:  "unlock",
+		Usage: "!unlock <name>",
+		Run: func(h *HandlerFuncs, m *irc.Message, args string) error {
+
+			return runLock(h, m, args, false)
+		},
+	})
+	Commands.Register(&Command{
+
+		Name:  "factinfo",
+		Usage: "!factinfo <name>",
+		Run:   runFactInfo,
+	})
+}
+
+// Normalise a factoid name, empty when it is not usable
+func factoidName(s string) string {
+
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	if len(s) > maxFactoidName {
+
+		return ""
+	}
+
+	return s
+}
+
+// Get a factoid, nil when it does not exist
+func getFactoid(network, name string) (f *store.Factoid, err error) {
+
+	f, err = DB.GetFactoid(network, name)
+	if err == store.ErrNotFound {
+
+		return nil, nil
+	}
+
+	return
+}
+
+// Follow the aliases of a factoid to the one holding the text
+func resolveFactoid(network, name string) (f *store.Factoid, err error) {
+
+	for i := 0; i <= maxFactoidAliases; i++ {
+
+		if f, err = getFactoid(network, name); err != nil || f == nil {
+
+			return
+		}
+		if len(f.Redirect) < 1 {
+
+			return
+		}
+		name = f.Redirect
+	}
+
+	return nil, ErrFactoidLoop
+}
+
+// Whether the sender may change the factoid
+func (h *HandlerFuncs) canEdit(m *irc.Message, f *store.Factoid) bool {
+
+	return f == nil || !f.Locked || h.IsAdmin(m)
+}
+
+// LookupFactoid answers "name?" and "??name". A single word followed by a
+// question mark is only answered when the factoid exists.
+func (h *HandlerFuncs) LookupFactoid(m *irc.Message) (handled bool, err error) {
+
+	text := strings.TrimSpace(m.Trailing)
+	explicit := strings.HasPrefix(text, "??")
+
+	var name string
+	switch {
+
+	case explicit:
+
+		name = factoidName(strings.TrimPrefix(text, "??"))
+
+	case strings.HasSuffix(text, "?") && !strings.ContainsAny(text, " \t"):
+
+		name = factoidName(strings.TrimRight(text, "?"))
+	}
+	if len(name) < 1 {
+
+		return
+	}
+
+	f, err := resolveFactoid(h.Name, name)
+	if err != nil {
+
+		return true, err
+	}
+	if f == nil {
+
+		if explicit {
+
+			return true, h.Reply(m, fmt.Sprintf("I don't know anything about %s", name))
+		}
+		return
+	}
+
+	return true, h.ClientConn.PrivMsg(replyTarget(m), fmt.Sprintf("%s is %s", name, f.Text))
+}
+
+func runLearn(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	name, text, ok := strings.Cut(args, " is ")
+	if !ok {
+
+		name, text, ok = strings.Cut(args, " = ")
+	}
+	name = factoidName(name)
+	text = strings.TrimSpace(text)
+	if !ok || len(name) < 1 || len(text) < 1 {
+
+		return h.Reply(m, "Usage: !learn <name> is <text>")
+	}
+	if len(text) > maxFactoidText {
+
+		return h.Reply(m, fmt.Sprintf("That is too long, factoids are limited to %d characters", maxFactoidText))
+	}
+
+	f, err := getFactoid(h.Name, name)
+	if err != nil {
+
+		return
+	}
+	if f != nil {
+
+		return h.Reply(m, fmt.Sprintf("I already know about %s, use !append or !forget it first", name))
+	}
+
+	f = &store.Factoid{
+
+		Network: h.Name,
+		Name:    name,
+		Text:    text,
+		Nick:    m.Prefix.Name,
+	}
+	if err = DB.SaveFactoid(f, "learn"); err != nil {
+
+		return
+	}
+
+	return h.Reply(m, fmt.Sprintf("Okay, %s is %s", name, text))
+}
+
+func runAppend(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	argv := splitArgs(args)
+	if len(argv) < 2 {
+
+		return h.Reply(m, "Usage: !append <name|\"some name\"> <text>")
+	}
+	name := factoidName(argv[0])
+	text := strings.Join(argv[1:], " ")
+
+	f, err := resolveFactoid(h.Name, name)
+	if err != nil {
+
+		return
+	}
+	if f == nil {
+
+		return h.Reply(m, fmt.Sprintf("I don't know anything about %s, use !learn", name))
+	}
+	if !h.canEdit(m, f) {
+
+		return h.Reply(m, fmt.Sprintf("%s is locked", f.Name))
+	}
+	if len(f.Text)+1+len(text) > maxFactoidText {
+
+		return h.Reply(m, fmt.Sprintf("That is too long, factoids are limited to %d characters", maxFactoidText))
+	}
+
+	f.Text += " " + text
+	f.Nick = m.Prefix.Name
+	f.Updated = time.Time{}
+	if err = DB.SaveFactoid(f, "append"); err != nil {
+
+		return
+	}
+
+	return h.Reply(m, "Okay")
+}
+
+func runForget(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	name := factoidName(args)
+	if len(name) < 1 {
+
+		return h.Reply(m, "Usage: !forget <name>")
+	}
+
+	f, err := getFactoid(h.Name, name)
+	if err != nil {
+
+		return
+	}
+	if f == nil {
+
+		return h.Reply(m, fmt.Sprintf("I don't know anything about %s", name))
+	}
+	if !h.canEdit(m, f) {
+
+		return h.Reply(m, fmt.Sprintf("%s is locked", name))
+	}
+
+	if err = DB.DeleteFactoid(h.Name, name, m.Prefix.Name); err != nil {
+
+		return
+	}
+
+	return h.Reply(m, fmt.Sprintf("I forgot %s", name))
+}
+
+func runAlias(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	argv := splitArgs(args)
+	if len(argv) != 2 {
+
+		return h.Reply(m, "Usage: !alias <name|\"some name\"> <factoid|\"some factoid\">")
+	}
+	name, target := factoidName(argv[0]), factoidName(argv[1])
+	if len(name) < 1 || len(target) < 1 || name == target {
+
+		return h.Reply(m, "Usage: !alias <name> <factoid>")
+	}
+
+	existing, err := getFactoid(h.Name, name)
+	if err != nil {
+
+		return
+	}
+	if existing != nil {
+
+		return h.Reply(m, fmt.Sprintf("I already know about %s, !forget it first", name))
+	}
+
+	t, err := resolveFactoid(h.Name, target)
+	if err == ErrFactoidLoop {
+
+		return h.Reply(m, fmt.Sprintf("%s has too many aliases", target))
+	}
+	if err != nil {
+
+		return
+	}
+	if t == nil {
+
+		return h.Reply(m, fmt.Sprintf("I don't know anything about %s", target))
+	}
+
+	f := &store.Factoid{
+
+		Network:  h.Name,
+		Name:     name,
+		Redirect: t.Name,
+		Nick:     m.Prefix.Name,
+	}
+	if err = DB.SaveFactoid(f, "alias"); err != nil {
+
+		return
+	}
+
+	return h.Reply(m, fmt.Sprintf("Okay, %s is an alias of %s", name, t.Name))
+}
+
+func runLock(h *HandlerFuncs, m *irc.Message, args string, locked bool) (err error) {
+
+	if !h.IsAdmin(m) {
+
+		return h.Reply(m, "Only admins can lock factoids")
+	}
+
+	name := factoidName(args)
+	f, err := getFactoid(h.Name, name)
+	if err != nil {
+
+		return
+	}
+	if f == nil {
+
+		return h.Reply(m, fmt.Sprintf("I don't know anything about %s", name))
+	}
+
+	action := "unlock"
+	if locked {
+
+		action = "lock"
+	}
+
+	f.Locked = locked
+	f.Nick = m.Prefix.Name
+	f.Updated = time.Time{}
+	if err = DB.SaveFactoid(f, action); err != nil {
+
+		return
+	}
+
+	return h.Reply(m, fmt.Sprintf("Okay, %s is %sed", name, action))
+}
+
+// Show who changed a factoid and when, the recent edits are sent as notices
+func runFactInfo(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	name := factoidName(args)
+	if len(name) < 1 {
+
+		return h.Reply(m, "Usage: !factinfo <name>")
+	}
+
+	f, err := getFactoid(h.Name, name)
+	if err != nil {
+
+		return
+	}
+	edits, err := DB.FactoidHistory(h.Name, name, factoidHistory)
+	if err != nil {
+
+		return
+	}
+	if f == nil && len(edits) < 1 {
+
+		return h.Reply(m, fmt.Sprintf("I don't know anything about %s", name))
+	}
+
+	if f == nil {
+
+		err = h.Reply(m, fmt.Sprintf("%s was forgotten by %s %s ago", name, edits[0].Nick, formatDuration(time.Since(edits[0].Time))))
+	} else {
+
+		info := fmt.Sprintf("%s was last changed by %s %s ago", name, f.Nick, formatDuration(time.Since(f.Updated)))
+		if len(f.Redirect) > 0 {
+
+			info += ", alias of " + f.Redirect
+		}
+		if f.Locked {
+
+			info += ", locked"
+		}
+		err = h.Reply(m, info)
+	}
+	if err != nil {
+
+		return
+	}
+
+	for _, e := range edits {
+
+		line := fmt.Sprintf("%s %s ago by %s", e.Action, formatDuration(time.Since(e.Time)), e.Nick)
+		switch {
+
+		case len(e.Redirect) > 0:
+
+			line += ": alias of " + e.Redirect
+
+		case len(e.Text) > 0:
+
+			line += ": " + e.Text
+		}
+		if err = h.ClientConn.Notice(m.Prefix.Name, line); err != nil {
+
+			return
+		}
+	}
+
+	return
+}
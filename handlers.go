@@ -2,14 +2,9 @@ package main
 
 import (
 	"fmt"
-	"log"
-	"net"
-	"strings"
+	"log/slog"
 
 	"github.com/TheCreeper/HackBot/ircutil"
-	"github.com/TheCreeper/HackBot/responses"
-	"github.com/TheCreeper/HackBot/searchquery/crawler"
-	"github.com/TheCreeper/HackBot/searchquery/ddg"
 	"github.com/sorcix/irc"
 )
 
@@ -28,116 +23,113 @@ type HandlerFuncs struct {
 	// Client Connection
 	ClientConn *ircutil.ClientConn
 
-	// Dialer
-	Dial func(network, addr string) (net.Conn, error)
+	// Network the handlers belong to
+	Network *Network
+
+	// Logger with the network attached
+	Log *slog.Logger
+
+	// Admin hostmask globs and $a:account patterns
+	Admins []string
 }
 
 const UserAgent = "Mozilla/5.0 (Windows NT 6.1; rv: 24.0) Geck0/20100101 Firefox/24.0 (Tor Browser Bundle)"
 
-func (h *HandlerFuncs) HandleRPLWelcome(m *irc.Message) (err error) {
-
-	// Print MOTD
-	log.Printf("%s: %s %s\n", h.Name, m.Command, m.Trailing)
+// Target to answer a message on, the channel or the sender for private messages
+func replyTarget(m *irc.Message) string {
 
-	// Join some channels
-	err = h.ClientConn.Join(h.Channels)
-	if err != nil {
+	if len(m.Params) > 0 && isChannel(m.Params[0]) {
 
-		return
+		return m.Params[0]
 	}
 
-	return
+	return m.Prefix.Name
 }
 
-func (h *HandlerFuncs) HandleJoin(m *irc.Message) (err error) {
+// Reply answers the message addressed to its sender.
+func (h *HandlerFuncs) Reply(m *irc.Message, text string) (err error) {
+
+	err = h.ClientConn.PrivMsg(replyTarget(m), fmt.Sprintf("%s: %s", m.Prefix.Name, text))
+	if err != nil {
+
+		h.Log.Error("ircutil.PrivMsg()", "error", err)
+	}
 
-	// Print Join messages
-	log.Printf("%s: %s %s\n", h.Name, m.Command, m.Trailing)
 	return
 }
 
-func (h *HandlerFuncs) HandlePirvMsg(m *irc.Message) (err error) {
+func (h *HandlerFuncs) HandleRPLWelcome(m *irc.Message) (err error) {
 
-	// Print Private messagess
-	log.Printf("%s: %s %s %s\n", h.Name, m.Command, m.Prefix.Name, m.Trailing)
+	// Print MOTD
+	h.Log.Info("welcome", append(messageAttrs(m), "text", m.Trailing)...)
+	h.Network.setConnected(true)
 
-	// Check for portal reference
-	if val, ok := responses.Portal[m.Trailing]; ok {
+	// Join some channels
+	if len(h.Channels) > 0 {
 
-		err = h.ClientConn.PrivMsg(m.Params[0], val)
+		err = h.ClientConn.Join(h.Channels)
 		if err != nil {
 
-			log.Printf("ircutil.PrivMsg(): %s\n", err)
+			h.Network.Error(err)
 			return
 		}
+	}
 
+	// And the ones joined at runtime
+	channels, err := DB.Channels(h.Name)
+	if err != nil {
+
+		h.Network.Error(err)
 		return
 	}
+	for _, c := range channels {
 
-	// Check for DDG query
-	if strings.HasPrefix(m.Trailing, "!ddg") {
-
-		m.Trailing = strings.TrimPrefix(m.Trailing, "!ddg")
-		if m.Trailing == "" {
+		target := c.Name
+		if len(c.Key) > 0 {
 
-			err = h.ClientConn.PrivMsg(m.Params[0], fmt.Sprintf("%s: %s", m.Prefix.Name, "No Query Specified"))
-			if err != nil {
+			target += " " + c.Key
+		}
+		if err = h.ClientConn.Join(target); err != nil {
 
-				log.Printf("ircutil.PrivMsg(): %s\n", err)
-				return err
-			}
+			h.Network.Error(err)
 			return
 		}
+	}
 
-		q := &ddg.Client{
+	return
+}
 
-			Dial:   h.Dial,
-			NoHTML: true,
-		}
-		_, text, err := q.FeelingLucky(m.Trailing)
-		if err != nil {
+func (h *HandlerFuncs) HandleJoin(m *irc.Message) (err error) {
 
-			log.Printf("ddg.FeelingLucky(): %s\n", err)
-		}
-		if len(text) < 1 {
+	// Print Join messages
+	h.Log.Info("join", messageAttrs(m)...)
+	return
+}
 
-			text = "No Results"
-		}
+func (h *HandlerFuncs) HandlePirvMsg(m *irc.Message) (err error) {
+
+	// Print Private messagess
+	h.Log.Info("privmsg", append(messageAttrs(m), "text", m.Trailing)...)
+
+	// Check for registered commands
+	if handled, err := h.RunCommand(m); handled {
 
-		err = h.ClientConn.PrivMsg(m.Params[0], fmt.Sprintf("%s: %s", m.Prefix.Name, text))
 		if err != nil {
 
-			log.Printf("ircutil.PrivMsg(): %s\n", err)
-			return err
+			h.Log.Error("command", append(messageAttrs(m), "error", err)...)
+			h.Network.Error(err)
 		}
+		return nil
 	}
 
-	// Check if message contains URL
-	if crawler.IsURL(m.Trailing) {
-
-		if strings.HasPrefix(m.Trailing, "dontcrawl") {
-
-			return
-		}
+	// Check for factoid lookups
+	if handled, err := h.LookupFactoid(m); handled {
 
-		c := &crawler.Client{
-			Dial: h.Dial,
-		}
-		r, err := c.Crawl(crawler.ExtractUrl(m.Trailing))
 		if err != nil {
 
-			log.Printf("crawler.GetTitle(): %s\n", err)
-			return nil
-		}
-		if len(r.Title) > 1 {
-
-			err = h.ClientConn.PrivMsg(m.Params[0], fmt.Sprintf("^ %s", r.Title))
-			if err != nil {
-
-				log.Printf("ircutil.PrivMsg(): %s\n", err)
-				return err
-			}
+			h.Log.Error("factoid", append(messageAttrs(m), "error", err)...)
 		}
+		return nil
 	}
 
 	return
@@ -146,6 +138,6 @@ func (h *HandlerFuncs) HandlePirvMsg(m *irc.Message) (err error) {
 func (h *HandlerFuncs) HandleUnknownCMD(m *irc.Message) (err error) {
 
 	// Print Unknown commands
-	log.Printf("%s: %s %s\n", h.Name, m.Command, m.Trailing)
+	h.Log.Debug("unhandled", append(messageAttrs(m), "text", m.Trailing)...)
 	return
 }
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/TheCreeper/HackBot/chanlog"
+	"github.com/TheCreeper/HackBot/ircutil"
+	"github.com/TheCreeper/HackBot/store"
+	"github.com/sorcix/irc"
+)
+
+// Number of matches returned by !grep
+const historyResults = 3
+
+// Days messages are kept for when History.RetentionDays is not set
+const defaultHistoryRetentionDays = 30
+
+func historyRetention(cfg *ClientConfig) time.Duration {
+
+	days := cfg.History.RetentionDays
+	if days < 1 {
+
+		days = defaultHistoryRetentionDays
+	}
+
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Store channel messages for searching, apart from private channels
+func indexMessages(db store.Store, network string, cc *ircutil.ClientConn) func(*irc.Message) {
+
+	return func(m *irc.Message) {
+
+		if m.Command != irc.PRIVMSG {
+
+			return
+		}
+
+		for _, e := range chanlog.Events(network, m, cc.Nick, nil) {
+
+			// Commands would match their own searches
+			if strings.HasPrefix(e.Text, CommandPrefix) {
+
+				continue
+			}
+			if isPrivate(network, e.Channel) {
+
+				continue
+			}
+
+			text := e.Text
+			if e.Type == chanlog.Action {
+
+				text = "* " + text
+			}
+			msg := &store.Message{
+
+				Network: network,
+				Channel: e.Channel,
+				Nick:    e.Nick,
+				Text:    text,
+				Time:    e.Time,
+			}
+			if err := db.AddMessage(msg); err != nil {
+
+				slog.Error("store.AddMessage()", "network", network, "channel", e.Channel, "error", err)
+			}
+		}
+	}
+}
+
+func registerHistoryCommands() {
+
+	Commands.Register(&Command{
+
+		Name:  "grep",
+		Usage: "!grep <words|\"some words\"|/regex/> [nick] [since]",
+		Run:   runGrep,
+	})
+	Commands.Register(&Command{
+
+		Name:  "last",
+		Usage: "!last <nick>",
+		Run:   runLast,
+	})
+}
+
+func formatLogged(m store.Message) string {
+
+	return fmt.Sprintf("[%s] <%s> %s", m.Time.Format("2006-01-02 15:04"), m.Nick, m.Text)
+}
+
+// Parse a since argument, either a duration back from now or a date
+func parseSince(s string) (t time.Time, ok bool) {
+
+	if d, err := parseDuration(s); err == nil {
+
+		return time.Now().Add(-d), true
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+
+		return t, true
+	}
+
+	return
+}
+
+// History is only searched in the channel the question is asked in
+func historyChannel(h *HandlerFuncs, m *irc.Message) (channel string, ok bool) {
+
+	if len(m.Params) < 1 || !isChannel(m.Params[0]) {
+
+		h.Reply(m, "History can only be searched from the channel itself")
+		return
+	}
+
+	return m.Params[0], true
+}
+
+func runGrep(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	channel, ok := historyChannel(h, m)
+	if !ok {
+
+		return
+	}
+
+	argv := splitArgs(args)
+	if len(argv) < 1 || len(argv) > 3 {
+
+		return h.Reply(m, "Usage: !grep <words|\"some words\"|/regex/> [nick] [since]")
+	}
+
+	q := store.MessageQuery{Limit: historyResults}
+	pattern := argv[0]
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+
+		q.Regexp = pattern[1 : len(pattern)-1]
+		if _, err := regexp.Compile(q.Regexp); err != nil {
+
+			return h.Reply(m, "Invalid regular expression")
+		}
+	} else {
+
+		q.Words = pattern
+	}
+
+	for _, arg := range argv[1:] {
+
+		if since, ok := parseSince(arg); ok {
+
+			q.Since = since
+			continue
+		}
+		q.Nick = arg
+	}
+
+	msgs, err := DB.SearchMessages(h.Name, channel, q)
+	if err != nil {
+
+		return
+	}
+	if len(msgs) < 1 {
+
+		return h.Reply(m, "No matches")
+	}
+
+	for _, msg := range msgs {
+
+		if err = h.ClientConn.PrivMsg(channel, formatLogged(msg)); err != nil {
+
+			return
+		}
+	}
+
+	return
+}
+
+func runLast(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	channel, ok := historyChannel(h, m)
+	if !ok {
+
+		return
+	}
+
+	nick := strings.TrimSpace(args)
+	if len(nick) < 1 || strings.Contains(nick, " ") {
+
+		return h.Reply(m, "Usage: !last <nick>")
+	}
+
+	msgs, err := DB.SearchMessages(h.Name, channel, store.MessageQuery{Nick: nick, Limit: 1})
+	if err != nil {
+
+		return
+	}
+	if len(msgs) < 1 {
+
+		return h.Reply(m, fmt.Sprintf("Nothing from %s in %s", nick, channel))
+	}
+
+	return h.ClientConn.PrivMsg(channel, formatLogged(msgs[0]))
+}
@@ -12,6 +12,9 @@ import (
 	"fmt"
 	"net"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sorcix/irc"
@@ -21,8 +24,13 @@ import (
 var (
 	ErrParseMsg   = errors.New("Unable to parse message")
 	ErrInvalidMsg = errors.New("Message contains invalid characters")
+	ErrQueueFull  = errors.New("Send queue is full")
+	ErrNotConn    = errors.New("Not connected")
 )
 
+// Default size of the outgoing message queue
+const DefaultSendQueueSize = 64
+
 // Some regular expressions
 var (
 	// Valid message. Make sure it contains no newline chars
@@ -43,6 +51,31 @@ type ClientConn struct {
 	UserName   string
 	RealName   string
 	OpPassword string
+
+	// Outgoing messages are queued and written by a single goroutine
+	SendQueueSize int
+
+	// Interval between lag measuring pings, disabled when zero
+	PingInterval time.Duration
+
+	// Channel and user state, updated from incoming messages when set
+	State *State
+
+	// IRCv3 capabilities requested at registration, e.g. extended-join
+	Capabilities []string
+
+	// Called for every message read from and written to the server
+	OnReceive func(*irc.Message)
+	OnSend    func(*irc.Message)
+
+	mu     sync.Mutex
+	sendq  chan *irc.Message
+	done   chan struct{}
+	pingAt time.Time
+	lag    time.Duration
+
+	// Registration waits on the answer to CAP REQ
+	capPending bool
 }
 
 func (cc *ClientConn) dial(network, addr string) (net.Conn, error) {
@@ -73,6 +106,37 @@ func (cc *ClientConn) Connect(h *Handlers) (err error) {
 	}
 	cc.Conn = irc.NewConn(conn)
 
+	// Start the writer before anything is sent
+	size := cc.SendQueueSize
+	if size < 1 {
+
+		size = DefaultSendQueueSize
+	}
+	cc.mu.Lock()
+	cc.sendq = make(chan *irc.Message, size)
+	cc.done = make(chan struct{})
+	cc.lag = 0
+	go cc.writeLoop(cc.Conn, cc.sendq, cc.done)
+	if cc.PingInterval > 0 {
+
+		go cc.pingLoop(cc.PingInterval, cc.done)
+	}
+	cc.mu.Unlock()
+
+	// Tear the connection down on whatever error ends it
+	defer func() {
+
+		if err != nil {
+
+			cc.Close()
+		}
+	}()
+
+	if cc.State != nil {
+
+		cc.State.Reset()
+	}
+
 	// Run the RegisterConnection handler if ClientConnected not defined
 	if h.ClientConnected == nil {
 
@@ -106,9 +170,121 @@ func (cc *ClientConn) Connect(h *Handlers) (err error) {
 
 func (cc *ClientConn) Close() error {
 
+	cc.mu.Lock()
+	if cc.done != nil {
+
+		close(cc.done)
+		cc.done = nil
+		cc.sendq = nil
+	}
+	cc.mu.Unlock()
+
+	if cc.State != nil {
+
+		cc.State.Reset()
+	}
+
 	return cc.Conn.Close()
 }
 
+func (cc *ClientConn) writeLoop(conn *irc.Conn, q chan *irc.Message, done chan struct{}) {
+
+	for {
+
+		select {
+
+		case m := <-q:
+
+			if err := conn.Encode(m); err != nil {
+
+				return
+			}
+			if cc.OnSend != nil {
+
+				cc.OnSend(m)
+			}
+
+		case <-done:
+
+			return
+		}
+	}
+}
+
+func (cc *ClientConn) pingLoop(interval time.Duration, done chan struct{}) {
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+
+		select {
+
+		case now := <-t.C:
+
+			cc.mu.Lock()
+			cc.pingAt = now
+			cc.mu.Unlock()
+
+			cc.Ping(strconv.FormatInt(now.UnixNano(), 10))
+
+		case <-done:
+
+			return
+		}
+	}
+}
+
+// Measure the lag from the reply to our own ping
+func (cc *ClientConn) handlePong(m *irc.Message) {
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.pingAt.IsZero() || m.Trailing != strconv.FormatInt(cc.pingAt.UnixNano(), 10) {
+
+		return
+	}
+	cc.lag = time.Since(cc.pingAt)
+	cc.pingAt = time.Time{}
+}
+
+// Lag returns the round trip time of the last ping, or the time waited so far
+// when a ping is outstanding and that is longer.
+func (cc *ClientConn) Lag() time.Duration {
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if !cc.pingAt.IsZero() {
+
+		if wait := time.Since(cc.pingAt); wait > cc.lag {
+
+			return wait
+		}
+	}
+
+	return cc.lag
+}
+
+// QueueLen returns the number of messages waiting to be sent.
+func (cc *ClientConn) QueueLen() int {
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	return len(cc.sendq)
+}
+
+// Connected reports whether the connection is up.
+func (cc *ClientConn) Connected() bool {
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	return cc.done != nil
+}
+
 func (cc *ClientConn) Disconnect() error {
 
 	err := cc.Quit()
@@ -122,6 +298,17 @@ func (cc *ClientConn) Disconnect() error {
 
 func (cc *ClientConn) RegisterClient() (err error) {
 
+	// Registration is held until the server answers the request
+	if len(cc.Capabilities) > 0 {
+
+		err = cc.CapReq(cc.Capabilities)
+		if err != nil {
+
+			return
+		}
+		cc.setCapPending(true)
+	}
+
 	if len(cc.Password) > 1 {
 
 		err = cc.SetPassword()
@@ -163,7 +350,36 @@ func (cc *ClientConn) SendRaw(message string) (err error) {
 		return ErrParseMsg
 	}
 
-	return cc.Conn.Encode(m)
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.sendq == nil {
+
+		if cc.Conn == nil {
+
+			return ErrNotConn
+		}
+		if err := cc.Conn.Encode(m); err != nil {
+
+			return err
+		}
+		if cc.OnSend != nil {
+
+			cc.OnSend(m)
+		}
+		return nil
+	}
+
+	select {
+
+	case cc.sendq <- m:
+
+		return nil
+
+	default:
+
+		return ErrQueueFull
+	}
 }
 
 func (cc *ClientConn) PingPong(m *irc.Message) error {
@@ -193,10 +409,41 @@ func (cc *ClientConn) RunHandlers(h *Handlers) (err error) {
 		return
 	}
 
+	if cc.OnReceive != nil {
+
+		cc.OnReceive(message)
+	}
+
+	if cc.State != nil {
+
+		cc.State.Update(message, cc.Nick)
+	}
+
+	// Follow our own nick changes
+	if message.Command == irc.NICK && message.Prefix != nil && message.Prefix.Name == cc.Nick {
+
+		cc.Nick = message.Trailing
+		if len(message.Params) > 0 {
+
+			cc.Nick = message.Params[0]
+		}
+	}
+
 	switch message.Command {
 
+	case "CAP":
+
+		// Finish registration once the requested capabilities are answered,
+		// later ACKs and NAKs are for capabilities requested afterwards
+		if len(message.Params) > 1 && (message.Params[1] == "ACK" || message.Params[1] == "NAK") && cc.setCapPending(false) {
+
+			return cc.CapEnd()
+		}
+		return
+
 	case irc.RPL_WELCOME:
 
+		cc.setCapPending(false)
 		if h.RPLWelcome == nil {
 
 			return
@@ -222,6 +469,11 @@ func (cc *ClientConn) RunHandlers(h *Handlers) (err error) {
 
 		return h.PingPong(message)
 
+	case irc.PONG:
+
+		cc.handlePong(message)
+		return
+
 	case irc.PRIVMSG:
 
 		if h.PrivMsg == nil {
@@ -297,6 +549,37 @@ func (cc *ClientConn) QuitM(message string) error {
 	return cc.SendRaw(fmt.Sprintf("%s %s\r\n", irc.QUIT, message))
 }
 
+// IRCv3 details: ircv3.net/specs/core/capability-negotiation.html
+func (cc *ClientConn) CapReq(caps []string) error {
+
+	// Sanitise
+	for _, c := range caps {
+
+		if !ValidMsg.MatchString(c) {
+
+			return ErrInvalidMsg
+		}
+	}
+
+	return cc.SendRaw(fmt.Sprintf("CAP REQ :%s\r\n", strings.Join(caps, " ")))
+}
+
+// Set whether registration waits on CAP REQ, returning whether it did
+func (cc *ClientConn) setCapPending(pending bool) (was bool) {
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	was = cc.capPending
+	cc.capPending = pending
+	return
+}
+
+func (cc *ClientConn) CapEnd() error {
+
+	return cc.SendRaw("CAP END\r\n")
+}
+
 /*
    @Channel Operations
    RFC 1459 details: tools.ietf.org/html/rfc1459#section-4.2
@@ -393,7 +676,7 @@ func (cc *ClientConn) Ping(message string) error {
 		return ErrInvalidMsg
 	}
 
-	return cc.SendRaw(fmt.Sprintf("%s %d\r\n", irc.PING, message))
+	return cc.SendRaw(fmt.Sprintf("%s %s\r\n", irc.PING, message))
 }
 
 // RFC 1459 details: tools.ietf.org/html/rfc1459#section-4.6.3
@@ -0,0 +1,53 @@
+package ircutil
+
+import (
+	"strings"
+
+	"github.com/sorcix/irc"
+)
+
+// Replacement for secrets in logged messages
+const Redacted = "<redacted>"
+
+// Messages to services that carry passwords
+var secretServiceCommands = map[string]bool{
+
+	"IDENTIFY": true,
+	"REGISTER": true,
+	"GHOST":    true,
+	"RECOVER":  true,
+}
+
+// Redact returns the raw form of the message with passwords removed, for
+// PASS, OPER, AUTHENTICATE and password commands sent to NickServ.
+func Redact(m *irc.Message) string {
+
+	switch strings.ToUpper(m.Command) {
+
+	case irc.PASS, "AUTHENTICATE":
+
+		return m.Command + " " + Redacted
+
+	case irc.OPER:
+
+		if len(m.Params) > 0 {
+
+			return m.Command + " " + m.Params[0] + " " + Redacted
+		}
+		return m.Command + " " + Redacted
+
+	case irc.PRIVMSG:
+
+		if len(m.Params) < 1 || !strings.EqualFold(m.Params[0], "NickServ") {
+
+			break
+		}
+		fields := strings.Fields(m.Trailing)
+		if len(fields) > 0 && secretServiceCommands[strings.ToUpper(fields[0])] {
+
+			return m.Command + " " + m.Params[0] + " :" + fields[0] + " " + Redacted
+		}
+	}
+
+	return m.String()
+}
@@ -0,0 +1,272 @@
+package ircutil
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sorcix/irc"
+)
+
+// Channel membership as seen by the client
+type Channel struct {
+	Name  string
+	Topic string
+	Users map[string]string // Nick to mode prefix (@, +, ...)
+}
+
+// State tracks the channels the client is in and the users in them. It is
+// updated from incoming messages by RunHandlers when set on a ClientConn.
+type State struct {
+	mu       sync.RWMutex
+	channels map[string]*Channel
+	accounts map[string]string // Nick to services account, from extended-join and account-notify
+}
+
+func NewState() *State {
+
+	return &State{channels: make(map[string]*Channel), accounts: make(map[string]string)}
+}
+
+// Reset forgets everything, used when the connection is lost.
+func (s *State) Reset() {
+
+	s.mu.Lock()
+	s.channels = make(map[string]*Channel)
+	s.accounts = make(map[string]string)
+	s.mu.Unlock()
+}
+
+// Channels returns the names of the joined channels in sorted order.
+func (s *State) Channels() (names []string) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, c := range s.channels {
+
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+
+	return
+}
+
+// Channel returns a copy of the named channel.
+func (s *State) Channel(name string) (c Channel, ok bool) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ch, ok := s.channels[strings.ToLower(name)]
+	if !ok {
+
+		return
+	}
+
+	c = Channel{Name: ch.Name, Topic: ch.Topic, Users: make(map[string]string, len(ch.Users))}
+	for nick, mode := range ch.Users {
+
+		c.Users[nick] = mode
+	}
+
+	return
+}
+
+// Account returns the services account of the nick, empty when not known or
+// not logged in.
+func (s *State) Account(nick string) string {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.accounts[strings.ToLower(nick)]
+}
+
+func (s *State) setAccount(nick, account string) {
+
+	if len(account) < 1 || account == "*" {
+
+		delete(s.accounts, strings.ToLower(nick))
+		return
+	}
+	s.accounts[strings.ToLower(nick)] = account
+}
+
+// UserChannels returns the channels the nick is known to be in.
+func (s *State) UserChannels(nick string) (names []string) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, c := range s.channels {
+
+		if _, ok := c.Users[nick]; ok {
+
+			names = append(names, c.Name)
+		}
+	}
+	sort.Strings(names)
+
+	return
+}
+
+// Update applies a message to the state. self is the current nick of the client.
+func (s *State) Update(m *irc.Message, self string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch m.Command {
+
+	case irc.JOIN:
+
+		if m.Prefix == nil {
+
+			return
+		}
+		name := m.Trailing
+		if len(m.Params) > 0 {
+
+			name = m.Params[0]
+		}
+		if m.Prefix.Name == self {
+
+			s.channels[strings.ToLower(name)] = &Channel{Name: name, Users: make(map[string]string)}
+		}
+		if c, ok := s.channels[strings.ToLower(name)]; ok {
+
+			c.Users[m.Prefix.Name] = ""
+		}
+
+		// extended-join: JOIN #channel account :realname
+		if len(m.Params) > 1 {
+
+			s.setAccount(m.Prefix.Name, m.Params[1])
+		}
+
+	case "ACCOUNT":
+
+		if m.Prefix == nil {
+
+			return
+		}
+		account := m.Trailing
+		if len(m.Params) > 0 {
+
+			account = m.Params[0]
+		}
+		s.setAccount(m.Prefix.Name, account)
+
+	case irc.PART:
+
+		if m.Prefix == nil || len(m.Params) < 1 {
+
+			return
+		}
+		s.removeUser(m.Params[0], m.Prefix.Name, self)
+
+	case irc.KICK:
+
+		if len(m.Params) < 2 {
+
+			return
+		}
+		s.removeUser(m.Params[0], m.Params[1], self)
+
+	case irc.QUIT:
+
+		if m.Prefix == nil {
+
+			return
+		}
+		for _, c := range s.channels {
+
+			delete(c.Users, m.Prefix.Name)
+		}
+		delete(s.accounts, strings.ToLower(m.Prefix.Name))
+
+	case irc.NICK:
+
+		if m.Prefix == nil {
+
+			return
+		}
+		nick := m.Trailing
+		if len(m.Params) > 0 {
+
+			nick = m.Params[0]
+		}
+		for _, c := range s.channels {
+
+			if mode, ok := c.Users[m.Prefix.Name]; ok {
+
+				delete(c.Users, m.Prefix.Name)
+				c.Users[nick] = mode
+			}
+		}
+		if account, ok := s.accounts[strings.ToLower(m.Prefix.Name)]; ok {
+
+			delete(s.accounts, strings.ToLower(m.Prefix.Name))
+			s.accounts[strings.ToLower(nick)] = account
+		}
+
+	case irc.TOPIC:
+
+		if len(m.Params) < 1 {
+
+			return
+		}
+		if c, ok := s.channels[strings.ToLower(m.Params[0])]; ok {
+
+			c.Topic = m.Trailing
+		}
+
+	case irc.RPL_TOPIC:
+
+		if len(m.Params) < 2 {
+
+			return
+		}
+		if c, ok := s.channels[strings.ToLower(m.Params[1])]; ok {
+
+			c.Topic = m.Trailing
+		}
+
+	case irc.RPL_NAMREPLY:
+
+		// :server 353 self = #channel :@op +voice user
+		if len(m.Params) < 3 {
+
+			return
+		}
+		c, ok := s.channels[strings.ToLower(m.Params[2])]
+		if !ok {
+
+			return
+		}
+		for _, nick := range strings.Fields(m.Trailing) {
+
+			mode := ""
+			for len(nick) > 0 && strings.ContainsRune("~&@%+", rune(nick[0])) {
+
+				mode += nick[:1]
+				nick = nick[1:]
+			}
+			c.Users[nick] = mode
+		}
+	}
+}
+
+func (s *State) removeUser(channel, nick, self string) {
+
+	if nick == self {
+
+		delete(s.channels, strings.ToLower(channel))
+		return
+	}
+	if c, ok := s.channels[strings.ToLower(channel)]; ok {
+
+		delete(c.Users, nick)
+	}
+}
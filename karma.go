@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/TheCreeper/HackBot/ircutil"
+	"github.com/TheCreeper/HackBot/store"
+	"github.com/sorcix/irc"
+)
+
+// Defaults for the Karma configuration
+const (
+	defaultKarmaPerHour  = 20
+	defaultKarmaCooldown = 60 * time.Second
+)
+
+// Limits of karma
+const (
+	minKarmaWord  = 2 // Shorter words like the c of c++ or i of i++ need (c)++
+	maxKarmaThing = 64
+	karmaResults  = 5 // Things listed by !karma top and bottom
+)
+
+// (multi word)++ and (multi word)--
+var karmaGroup = regexp.MustCompile(`\(([^()]+)\)(\+\+|--)`)
+
+// Settings of karma, from the Karma section of the configuration
+type KarmaSettings struct {
+	PerHour  int           // Karma changes per user an hour
+	Cooldown time.Duration // Between changes of the same thing by a user
+}
+
+func newKarmaSettings(cfg *ClientConfig) KarmaSettings {
+
+	s := KarmaSettings{
+
+		PerHour:  cfg.Karma.PerHour,
+		Cooldown: time.Duration(cfg.Karma.CooldownSeconds) * time.Second,
+	}
+	if s.PerHour < 1 {
+
+		s.PerHour = defaultKarmaPerHour
+	}
+	if s.Cooldown <= 0 {
+
+		s.Cooldown = defaultKarmaCooldown
+	}
+
+	return s
+}
+
+// A karma change in a message
+type karmaChange struct {
+	Thing string
+	Delta int
+}
+
+// Normalise a thing, empty when it can't have karma
+func karmaThing(s string) string {
+
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	if len(s) > maxKarmaThing || strings.TrimRight(s, "+-") != s {
+
+		return ""
+	}
+
+	return s
+}
+
+// Find thing++, thing-- and (multi word)++ in a message. A thing is changed
+// once per message. Single words need a letter or digit and minKarmaWord
+// characters, so c++ and i++ are left alone.
+func parseKarma(text string) (changes []karmaChange) {
+
+	seen := make(map[string]bool)
+	add := func(thing, op string) {
+
+		thing = karmaThing(thing)
+		if len(thing) < 1 || seen[thing] {
+
+			return
+		}
+		seen[thing] = true
+
+		delta := 1
+		if op == "--" {
+
+			delta = -1
+		}
+		changes = append(changes, karmaChange{thing, delta})
+	}
+
+	for _, match := range karmaGroup.FindAllStringSubmatch(text, -1) {
+
+		add(match[1], match[2])
+	}
+	text = karmaGroup.ReplaceAllString(text, " ")
+
+	for _, word := range strings.Fields(text) {
+
+		word = strings.TrimRight(word, ",.;:!?")
+		for _, op := range []string{"++", "--"} {
+
+			thing := strings.TrimSuffix(word, op)
+			if thing == word || len(thing) < minKarmaWord || !strings.ContainsFunc(thing, isWordRune) {
+
+				continue
+			}
+			add(thing, op)
+		}
+	}
+
+	return
+}
+
+func isWordRune(r rune) bool {
+
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Rate limits of karma changes per user
+type karmaLimiter struct {
+	mu      sync.Mutex
+	changes map[string][]time.Time // Recent changes by user
+	last    map[string]time.Time   // Last change by user and thing
+}
+
+func newKarmaLimiter() *karmaLimiter {
+
+	return &karmaLimiter{
+
+		changes: make(map[string][]time.Time),
+		last:    make(map[string]time.Time),
+	}
+}
+
+// Allow records the change when the user is within the limits.
+func (l *karmaLimiter) Allow(settings KarmaSettings, user, thing string, now time.Time) bool {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget changes older than an hour and users without recent ones
+	for u, times := range l.changes {
+
+		var recent []time.Time
+		for _, t := range times {
+
+			if now.Sub(t) < time.Hour {
+
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) < 1 {
+
+			delete(l.changes, u)
+			continue
+		}
+		l.changes[u] = recent
+	}
+
+	recent := l.changes[user]
+	if len(recent) >= settings.PerHour {
+
+		return false
+	}
+
+	key := user + "\x00" + thing
+	if now.Sub(l.last[key]) < settings.Cooldown {
+
+		return false
+	}
+
+	// Forget cooldowns long expired
+	for k, t := range l.last {
+
+		if now.Sub(t) >= settings.Cooldown {
+
+			delete(l.last, k)
+		}
+	}
+
+	l.last[key] = now
+	l.changes[user] = append(recent, now)
+
+	return true
+}
+
+// Apply karma changes in channel messages. Users are told by notice when
+// they try to change their own karma or go over the limits.
+func countKarma(db store.Store, settings KarmaSettings, network string, cc *ircutil.ClientConn) func(*irc.Message) {
+
+	limiter := newKarmaLimiter()
+	return func(m *irc.Message) {
+
+		if m.Command != irc.PRIVMSG || m.Prefix == nil || len(m.Params) < 1 || !isChannel(m.Params[0]) {
+
+			return
+		}
+		if strings.HasPrefix(m.Trailing, CommandPrefix) || strings.HasPrefix(m.Trailing, "\x01") {
+
+			return
+		}
+
+		nick := m.Prefix.Name
+
+		// Limits follow the account or user@host rather than the nick
+		user := cc.State.Account(nick)
+		if len(user) < 1 {
+
+			user = m.Prefix.User + "@" + m.Prefix.Host
+		}
+		user = strings.ToLower(user)
+
+		for _, c := range parseKarma(m.Trailing) {
+
+			if c.Thing == strings.ToLower(nick) {
+
+				cc.Notice(nick, "You can't change your own karma")
+				continue
+			}
+			if !limiter.Allow(settings, user, c.Thing, time.Now()) {
+
+				cc.Notice(nick, fmt.Sprintf("You're changing karma too often, %s was not changed", c.Thing))
+				continue
+			}
+			if err := db.AddKarma(network, c.Thing, c.Delta); err != nil {
+
+				slog.Error("store.AddKarma()", "network", network, "thing", c.Thing, "error", err)
+			}
+		}
+	}
+}
+
+func registerKarmaCommands() {
+
+	Commands.Register(&Command{
+
+		Name:  "karma",
+		Usage: "!karma <thing|top|bottom>",
+		Run:   runKarma,
+	})
+}
+
+func runKarma(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	arg := strings.TrimSpace(args)
+	switch arg {
+
+	case "":
+
+		return h.Reply(m, "Usage: !karma <thing|top|bottom>")
+
+	case "top", "bottom":
+
+		karma, err := DB.TopKarma(h.Name, karmaResults, arg == "bottom")
+		if err != nil {
+
+			return err
+		}
+		if len(karma) < 1 {
+
+			return h.Reply(m, "Nothing has karma yet")
+		}
+
+		var list []string
+		for _, k := range karma {
+
+			list = append(list, fmt.Sprintf("%s (%d)", k.Thing, k.Score))
+		}
+		return h.Reply(m, strings.Join(list, ", "))
+	}
+
+	thing := karmaThing(strings.Trim(arg, "()"))
+	if len(thing) < 1 {
+
+		return h.Reply(m, "Usage: !karma <thing|top|bottom>")
+	}
+
+	score, err := DB.GetKarma(h.Name, thing)
+	if err != nil {
+
+		return
+	}
+
+	return h.Reply(m, fmt.Sprintf("%s has karma of %d", thing, score))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseKarma(t *testing.T) {
+
+	tests := []struct {
+		text    string
+		changes []karmaChange
+	}{
+		{"nothing here", nil},
+		{"go++", []karmaChange{{"go", 1}}},
+		{"Go++ and rust--", []karmaChange{{"go", 1}, {"rust", -1}}},
+		{"thanks bob++!", []karmaChange{{"bob", 1}}},
+		{"bob++ bob++ BOB--", []karmaChange{{"bob", 1}}},
+		{"(free software)++", []karmaChange{{"free software", 1}}},
+		{"(c)++ but not c++", []karmaChange{{"c", 1}}},
+		{"i++; j--", nil},
+		{"c++ and g++", nil},
+		{"++ -- +++ ----", nil},
+		{"x+++", nil},
+		{"--verbose", nil},
+		{"()++", nil},
+	}
+
+	for _, test := range tests {
+
+		if changes := parseKarma(test.text); !reflect.DeepEqual(changes, test.changes) {
+
+			t.Errorf("parseKarma(%q) = %v, want %v", test.text, changes, test.changes)
+		}
+	}
+}
+
+func TestKarmaLimiter(t *testing.T) {
+
+	settings := KarmaSettings{PerHour: 2, Cooldown: time.Minute}
+	l := newKarmaLimiter()
+	now := time.Now()
+
+	steps := []struct {
+		user  string
+		thing string
+		after time.Duration
+		allow bool
+	}{
+		{"a", "go", 0, true},
+		{"a", "go", 30 * time.Second, false},
+		{"a", "rust", 30 * time.Second, true},
+		{"a", "lua", 40 * time.Second, false},
+		{"b", "lua", 40 * time.Second, true},
+		{"a", "lua", time.Hour + time.Second, true},
+	}
+
+	for i, step := range steps {
+
+		if allow := l.Allow(settings, step.user, step.thing, now.Add(step.after)); allow != step.allow {
+
+			t.Errorf("step %d: Allow(%s, %s) = %v, want %v", i, step.user, step.thing, allow, step.allow)
+		}
+	}
+
+	// Users without changes in the last hour are forgotten
+	l.Allow(settings, "c", "go", now.Add(3*time.Hour))
+	if len(l.changes) != 1 {
+
+		t.Errorf("limiter remembers %d users, want 1", len(l.changes))
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/TheCreeper/HackBot/chanlog"
+	"github.com/TheCreeper/HackBot/ircutil"
+	"github.com/sorcix/irc"
+)
+
+// Errors
+var (
+	ErrLogLevel = errors.New("Unknown log level")
+)
+
+// Build the logger from the Log section of the configuration
+func NewLogger(w io.Writer, level string, json bool) (*slog.Logger, error) {
+
+	var lvl slog.Level
+	switch strings.ToLower(level) {
+
+	case "debug":
+
+		lvl = slog.LevelDebug
+
+	case "", "info":
+
+		lvl = slog.LevelInfo
+
+	case "warn", "warning":
+
+		lvl = slog.LevelWarn
+
+	case "error":
+
+		lvl = slog.LevelError
+
+	default:
+
+		return nil, ErrLogLevel
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	if json {
+
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+
+	return slog.New(slog.NewTextHandler(w, opts)), nil
+}
+
+// Attributes describing an irc message
+func messageAttrs(m *irc.Message) (attrs []any) {
+
+	attrs = append(attrs, "command", m.Command)
+	if m.Prefix != nil && len(m.Prefix.Name) > 0 {
+
+		attrs = append(attrs, "nick", m.Prefix.Name)
+	}
+	if len(m.Params) > 0 && isChannel(m.Params[0]) {
+
+		attrs = append(attrs, "channel", m.Params[0])
+	}
+
+	return
+}
+
+func isChannel(target string) bool {
+
+	return len(target) > 0 && strings.ContainsRune("#&+!", rune(target[0]))
+}
+
+// Whether the channel is private, kept out of the channel logs, the history
+// and seen data
+func isPrivate(network, channel string) bool {
+
+	return chanlog.Excluded(PrivateChannels, network, channel)
+}
+
+// Log raw traffic at debug level with secrets removed
+func logReceived(l *slog.Logger) func(*irc.Message) {
+
+	return func(m *irc.Message) {
+
+		l.Debug("received", append(messageAttrs(m), "raw", ircutil.Redact(m))...)
+	}
+}
+
+func logSent(l *slog.Logger) func(*irc.Message) {
+
+	return func(m *irc.Message) {
+
+		l.Debug("sent", "command", m.Command, "raw", ircutil.Redact(m))
+	}
+}
+
+// Chain message hooks
+func onMessage(hooks ...func(*irc.Message)) func(*irc.Message) {
+
+	return func(m *irc.Message) {
+
+		for _, hook := range hooks {
+
+			hook(m)
+		}
+	}
+}
+
+// Write channel events to the channel logs
+func logChannel(l *chanlog.Logger, network string, cc *ircutil.ClientConn) func(*irc.Message) {
+
+	return func(m *irc.Message) {
+
+		for _, e := range chanlog.Events(network, m, cc.Nick, cc.State.UserChannels) {
+
+			if err := l.Log(&e); err != nil {
+
+				slog.Error("chanlog.Log()", "network", network, "channel", e.Channel, "error", err)
+			}
+		}
+	}
+}
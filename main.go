@@ -2,16 +2,22 @@ package main
 
 import (
 	"flag"
-	"io"
 	"log"
+	"log/slog"
+	"net/http"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/TheCreeper/HackBot/chanlog"
 	"github.com/TheCreeper/HackBot/ircutil"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func (cfg *ClientConfig) LaunchClient(wg *sync.WaitGroup, srv Server) {
 
+	logger := slog.Default().With("network", srv.Name)
+
 	// Setup the proxy connection
 	conn := &ConnHandler{
 
@@ -30,7 +36,45 @@ func (cfg *ClientConfig) LaunchClient(wg *sync.WaitGroup, srv Server) {
 		UserName: srv.UserName,
 		RealName: srv.RealName,
 		Dial:     conn.HandleConnection,
+
+		PingInterval: time.Duration(srv.PingIntervalSeconds) * time.Second,
+		State:        ircutil.NewState(),
+		Capabilities: srv.Capabilities,
+	}
+	cc.OnReceive = onMessage(countReceived(srv.Name), logReceived(logger))
+	cc.OnSend = onMessage(countSent(srv.Name), logSent(logger))
+	if ChannelLog != nil {
+
+		cc.OnReceive = onMessage(cc.OnReceive, logChannel(ChannelLog, srv.Name, cc))
+		cc.OnSend = onMessage(cc.OnSend, logChannel(ChannelLog, srv.Name, cc))
+	}
+	if cfg.History.Enabled {
+
+		cc.OnReceive = onMessage(cc.OnReceive, indexMessages(DB, srv.Name, cc))
+		cc.OnSend = onMessage(cc.OnSend, indexMessages(DB, srv.Name, cc))
 	}
+	cc.OnReceive = onMessage(cc.OnReceive, recordSeen(DB, srv.Name, cc))
+	cc.OnReceive = onMessage(cc.OnReceive, deliverMemos(DB, newMemoSettings(cfg), srv.Name, cc))
+	cc.OnReceive = onMessage(cc.OnReceive, countKarma(DB, newKarmaSettings(cfg), srv.Name, cc))
+	if Plugins != nil {
+
+		cc.OnReceive = onMessage(cc.OnReceive, forwardPlugins(Plugins, srv.Name, cc))
+	}
+
+	// Plugins fetch through the proxy as well but don't reach local addresses
+	fetch := *conn
+	fetch.Control = publicOnly
+
+	// Make the network visible to the admin api
+	n := &Network{
+
+		Name:       srv.Name,
+		Server:     srv.Server,
+		ClientConn: cc,
+		State:      cc.State,
+		Dial:       fetch.HandleConnection,
+	}
+	Networks.Add(n)
 
 	// Pass some vars to the handlers
 	h := &HandlerFuncs{
@@ -45,7 +89,9 @@ func (cfg *ClientConfig) LaunchClient(wg *sync.WaitGroup, srv Server) {
 		CTCPVersion: srv.CTCPVersion,
 
 		ClientConn: cc,
-		Dial:       conn.HandleConnection,
+		Network:    n,
+		Log:        logger,
+		Admins:     srv.Admins,
 	}
 
 	// Setup the handlers
@@ -62,17 +108,14 @@ func (cfg *ClientConfig) LaunchClient(wg *sync.WaitGroup, srv Server) {
 
 		time.Sleep(time.Duration(srv.ReconnectIntervalSeconds) * time.Second)
 
+		// Connect closes the connection when it fails
 		err := cc.Connect(handlers)
-		if err == io.EOF {
-
-			cc.Close()
-		}
+		n.setConnected(false)
 		if err != nil {
 
-			log.Printf("irc.Connect(): %s\n", err)
-			continue
+			logger.Error("irc.Connect()", "error", err)
+			n.Error(err)
 		}
-		defer cc.Close()
 	}
 
 	wg.Done()
@@ -81,19 +124,166 @@ func (cfg *ClientConfig) LaunchClient(wg *sync.WaitGroup, srv Server) {
 func init() {
 
 	flag.StringVar(&ConfigFile, "config", "./config.json", "The configuration file location")
-	flag.Parse()
 }
 
 func main() {
 
 	var wg sync.WaitGroup
 
+	flag.Parse()
+
 	cfg, err := GetCFG(ConfigFile)
 	if err != nil {
 
 		log.Fatal(err)
 	}
 
+	logger, err := NewLogger(os.Stderr, cfg.Log.Level, cfg.Log.JSON)
+	if err != nil {
+
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	DB, err = OpenDatabase(&cfg)
+	if err != nil {
+
+		log.Fatal(err)
+	}
+	defer DB.Close()
+
+	if flag.Arg(0) == "migrate" {
+
+		if err = runMigrate(DB, os.Stdout, flag.Args()[1:]); err != nil {
+
+			log.Fatal(err)
+		}
+		return
+	}
+	if err = migrateDatabase(DB); err != nil {
+
+		log.Fatal(err)
+	}
+	if flag.Arg(0) == "plugins" {
+
+		if err = runPluginStorage(&cfg, os.Stdout, os.Stdin, flag.Args()[1:]); err != nil {
+
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "quotes" {
+
+		if err = runQuotes(DB, os.Stdout, flag.Args()[1:]); err != nil {
+
+			log.Fatal(err)
+		}
+		return
+	}
+	PrivateChannels = cfg.privateChannels()
+	if len(cfg.ChannelLog.Dir) > 0 {
+
+		ChannelLog, err = chanlog.New(cfg.ChannelLog.Dir,
+			cfg.ChannelLog.Format,
+			cfg.ChannelLog.Rotate,
+			int64(cfg.ChannelLog.MaxSizeMB)<<20,
+			PrivateChannels)
+		if err != nil {
+
+			log.Fatal(err)
+		}
+		defer ChannelLog.Close()
+	}
+
+	if cfg.History.Enabled {
+
+		registerHistoryCommands()
+	}
+	registerSeenCommands()
+	registerMemoCommands(newMemoSettings(&cfg))
+	registerReminderCommands(reminderLimit(&cfg))
+	registerFactoidCommands()
+	registerQuoteCommands(cfg.History.Enabled)
+	registerKarmaCommands()
+	registerPollCommands()
+	registerPluginCommands()
+
+	announcements, err := newAnnouncements(&cfg)
+	if err != nil {
+
+		log.Fatal(err)
+	}
+	scheduler := &Scheduler{
+
+		DB:            DB,
+		Networks:      Networks,
+		Announcements: announcements,
+	}
+	if cfg.History.Enabled {
+
+		scheduler.HistoryRetention = historyRetention(&cfg)
+	}
+	go scheduler.Run()
+
+	plugins := cfg.plugins()
+	if len(plugins) > 0 || len(cfg.Scripts.Dir) > 0 {
+
+		l, err := NewServer()
+		if err != nil {
+
+			log.Fatal(err)
+		}
+		Plugins = NewPluginHost(l, Networks)
+		Plugins.Configs = plugins
+		Plugins.Scripts = cfg.Scripts
+		go func() {
+
+			slog.Error("plugins.Serve()", "error", Plugins.Serve())
+		}()
+		if len(cfg.PluginListen.Address) > 0 {
+
+			rl, err := RemoteListener(cfg.PluginListen.Address, cfg.PluginListen.TLSCert, cfg.PluginListen.TLSKey)
+			if err != nil {
+
+				log.Fatal(err)
+			}
+			go func() {
+
+				slog.Error("plugins.ServeListener()", "error", Plugins.ServeListener(rl))
+			}()
+		}
+		for _, p := range plugins {
+
+			if err = Plugins.Start(p); err != nil {
+
+				log.Fatal(err)
+			}
+		}
+		if len(cfg.Scripts.Dir) > 0 {
+
+			go Plugins.WatchScripts(cfg.Scripts)
+		}
+	}
+
+	if len(cfg.Admin.Listen) > 0 {
+
+		admin := &AdminServer{
+
+			Listen:   cfg.Admin.Listen,
+			Token:    cfg.Admin.Token,
+			Networks: Networks,
+			Plugins:  Plugins,
+			Handlers: map[string]http.Handler{
+
+				"/metrics": promhttp.Handler(),
+			},
+		}
+		go func() {
+
+			slog.Error("admin.ListenAndServe()", "error", admin.ListenAndServe())
+		}()
+	}
+
 	for _, v := range cfg.Servers {
 
 		if v.AutoConnect {
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheCreeper/HackBot/ircutil"
+	"github.com/TheCreeper/HackBot/store"
+	"github.com/sorcix/irc"
+)
+
+// Memo delivery methods
+const (
+	MemoNotice  = "notice"
+	MemoChannel = "channel"
+)
+
+// Defaults for the Memos configuration
+const (
+	defaultMemosPerSender = 5
+	defaultMemoExpiryDays = 30
+)
+
+// Settings of !tell, from the Memos section of the configuration
+type MemoSettings struct {
+	MaxPerSender int
+	Expiry       time.Duration
+	Delivery     string
+}
+
+func newMemoSettings(cfg *ClientConfig) MemoSettings {
+
+	s := MemoSettings{
+
+		MaxPerSender: cfg.Memos.MaxPerSender,
+		Expiry:       time.Duration(cfg.Memos.ExpiryDays) * 24 * time.Hour,
+		Delivery:     cfg.Memos.Delivery,
+	}
+	if s.MaxPerSender < 1 {
+
+		s.MaxPerSender = defaultMemosPerSender
+	}
+	if s.Expiry <= 0 {
+
+		s.Expiry = defaultMemoExpiryDays * 24 * time.Hour
+	}
+	if s.Delivery != MemoChannel {
+
+		s.Delivery = MemoNotice
+	}
+
+	return s
+}
+
+// Nicks and accounts with pending memos by network. They are loaded from the
+// database when first needed and again once memos are removed, so messages
+// of everyone else need no query.
+type memoIndex struct {
+	mu       sync.Mutex
+	nicks    map[string]map[string]bool
+	accounts map[string]map[string]bool
+}
+
+var pendingMemos = &memoIndex{
+
+	nicks:    make(map[string]map[string]bool),
+	accounts: make(map[string]map[string]bool),
+}
+
+// Whether a nick or account may have memos waiting on a network
+func (x *memoIndex) Pending(db store.Store, network, nick, account string) (bool, error) {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if _, ok := x.nicks[network]; !ok {
+
+		nicks, accounts, err := db.MemoRecipients(network)
+		if err != nil {
+
+			return false, err
+		}
+		x.nicks[network] = make(map[string]bool)
+		x.accounts[network] = make(map[string]bool)
+		for _, n := range nicks {
+
+			x.nicks[network][strings.ToLower(n)] = true
+		}
+		for _, a := range accounts {
+
+			x.accounts[network][a] = true
+		}
+	}
+
+	return x.nicks[network][strings.ToLower(nick)] || (len(account) > 0 && x.accounts[network][account]), nil
+}
+
+func (x *memoIndex) Add(m *store.Memo) {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if _, ok := x.nicks[m.Network]; !ok {
+
+		return
+	}
+	x.nicks[m.Network][strings.ToLower(m.Recipient)] = true
+	if len(m.Account) > 0 {
+
+		x.accounts[m.Network][m.Account] = true
+	}
+}
+
+// Reload a network, or every network when empty, once memos were removed
+func (x *memoIndex) Reset(network string) {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if len(network) < 1 {
+
+		x.nicks = make(map[string]map[string]bool)
+		x.accounts = make(map[string]map[string]bool)
+		return
+	}
+	delete(x.nicks, network)
+	delete(x.accounts, network)
+}
+
+// Deliver pending memos when their recipient speaks or joins a channel
+func deliverMemos(db store.Store, settings MemoSettings, network string, cc *ircutil.ClientConn) func(*irc.Message) {
+
+	return func(m *irc.Message) {
+
+		if m.Prefix == nil || (m.Command != irc.PRIVMSG && m.Command != irc.JOIN) {
+
+			return
+		}
+
+		channel := m.Trailing
+		if len(m.Params) > 0 {
+
+			channel = m.Params[0]
+		}
+		if !isChannel(channel) {
+
+			return
+		}
+
+		// Hooks run before the state is updated, a join brings the account
+		// along with extended-join, * when not logged in
+		nick := m.Prefix.Name
+		account := ""
+		if m.Command == irc.JOIN && len(m.Params) > 1 && m.Params[1] != "*" {
+
+			account = m.Params[1]
+		}
+		if len(account) < 1 {
+
+			account = cc.State.Account(nick)
+		}
+		pending, err := pendingMemos.Pending(db, network, nick, account)
+		if err != nil {
+
+			slog.Error("store.MemoRecipients()", "network", network, "error", err)
+			return
+		}
+		if !pending {
+
+			return
+		}
+
+		memos, err := db.PendingMemos(network, nick, account)
+		if err != nil {
+
+			slog.Error("store.PendingMemos()", "network", network, "nick", nick, "error", err)
+			return
+		}
+
+		defer pendingMemos.Reset(network)
+		for _, memo := range memos {
+
+			text := fmt.Sprintf("%s left you a message %s ago: %s", memo.Sender, formatDuration(time.Since(memo.Created)), memo.Text)
+			if settings.Delivery == MemoChannel {
+
+				err = cc.PrivMsg(channel, fmt.Sprintf("%s: %s", nick, text))
+			} else {
+
+				err = cc.Notice(nick, text)
+			}
+			if err != nil {
+
+				slog.Error("memo delivery", "network", network, "nick", nick, "error", err)
+				return
+			}
+
+			if err = db.DeleteMemo(network, memo.ID); err != nil {
+
+				slog.Error("store.DeleteMemo()", "network", network, "id", memo.ID, "error", err)
+			}
+		}
+	}
+}
+
+func registerMemoCommands(settings MemoSettings) {
+
+	Commands.Register(&Command{
+
+		Name:  "tell",
+		Usage: "!tell <nick> <message>",
+		Run: func(h *HandlerFuncs, m *irc.Message, args string) error {
+
+			return runTell(h, m, args, settings)
+		},
+	})
+	Commands.Register(&Command{
+
+		Name:  "memos",
+		Usage: "!memos [cancel <id>]",
+		Run:   runMemos,
+	})
+}
+
+func runTell(h *HandlerFuncs, m *irc.Message, args string, settings MemoSettings) (err error) {
+
+	nick, text, _ := strings.Cut(args, " ")
+	text = strings.TrimSpace(text)
+	if len(nick) < 1 || len(text) < 1 {
+
+		return h.Reply(m, "Usage: !tell <nick> <message>")
+	}
+
+	switch {
+
+	case strings.EqualFold(nick, m.Prefix.Name):
+
+		return h.Reply(m, "You can tell yourself that")
+
+	case strings.EqualFold(nick, h.ClientConn.Nick):
+
+		return h.Reply(m, "I'm listening already")
+	}
+
+	sent, err := DB.SentMemos(h.Name, m.Prefix.Name)
+	if err != nil {
+
+		return
+	}
+	if len(sent) >= settings.MaxPerSender {
+
+		return h.Reply(m, fmt.Sprintf("You already have %d memos waiting, see !memos", len(sent)))
+	}
+
+	channel := ""
+	if len(m.Params) > 0 && isChannel(m.Params[0]) {
+
+		channel = m.Params[0]
+	}
+
+	now := time.Now()
+	memo := &store.Memo{
+
+		Network:   h.Name,
+		Sender:    m.Prefix.Name,
+		Recipient: nick,
+		Account:   h.ClientConn.State.Account(nick),
+		Channel:   channel,
+		Text:      text,
+		Created:   now,
+		Expires:   now.Add(settings.Expiry),
+	}
+	if err = DB.AddMemo(memo); err != nil {
+
+		return
+	}
+	pendingMemos.Add(memo)
+
+	return h.Reply(m, fmt.Sprintf("I'll pass that on when %s is around", nick))
+}
+
+// List or cancel the memos the sender is waiting on
+func runMemos(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	sent, err := DB.SentMemos(h.Name, m.Prefix.Name)
+	if err != nil {
+
+		return
+	}
+
+	argv := strings.Fields(args)
+	if len(argv) == 2 && argv[0] == "cancel" {
+
+		id, err := strconv.ParseInt(strings.TrimPrefix(argv[1], "#"), 10, 64)
+		if err != nil {
+
+			return h.Reply(m, "Usage: !memos [cancel <id>]")
+		}
+		for _, memo := range sent {
+
+			if memo.ID == id {
+
+				if err = DB.DeleteMemo(h.Name, id); err != nil {
+
+					return err
+				}
+				pendingMemos.Reset(h.Name)
+				return h.Reply(m, fmt.Sprintf("Memo #%d to %s cancelled", id, memo.Recipient))
+			}
+		}
+		return h.Reply(m, fmt.Sprintf("You have no memo #%d", id))
+	}
+	if len(argv) > 0 {
+
+		return h.Reply(m, "Usage: !memos [cancel <id>]")
+	}
+
+	if len(sent) < 1 {
+
+		return h.ClientConn.Notice(m.Prefix.Name, "You have no memos waiting")
+	}
+	for _, memo := range sent {
+
+		err = h.ClientConn.Notice(m.Prefix.Name, fmt.Sprintf("#%d to %s, %s ago: %s",
+			memo.ID, memo.Recipient, formatDuration(time.Since(memo.Created)), memo.Text))
+		if err != nil {
+
+			return
+		}
+	}
+
+	return
+}
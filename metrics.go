@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sorcix/irc"
+)
+
+const metricsNamespace = "hackbot"
+
+var (
+	messagesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+
+		Namespace: metricsNamespace,
+		Name:      "messages_received_total",
+		Help:      "IRC messages received from the server by command.",
+	}, []string{"network", "command"})
+
+	messagesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+
+		Namespace: metricsNamespace,
+		Name:      "messages_sent_total",
+		Help:      "IRC messages written to the server by command.",
+	}, []string{"network", "command"})
+
+	crawlerRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+
+		Namespace: metricsNamespace,
+		Name:      "crawler_requests_total",
+		Help:      "URL title lookups by HTTP status and detected MIME type.",
+	}, []string{"status", "mime"})
+
+	ddgDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+
+		Namespace: metricsNamespace,
+		Name:      "ddg_request_duration_seconds",
+		Help:      "Latency of DuckDuckGo queries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	ddgErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+
+		Namespace: metricsNamespace,
+		Name:      "ddg_errors_total",
+		Help:      "DuckDuckGo queries that failed.",
+	}, []string{"network"})
+
+	pluginRPCDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+
+		Namespace: metricsNamespace,
+		Name:      "plugin_rpc_duration_seconds",
+		Help:      "Latency of RPC calls between the bot and its plugins.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"plugin", "method"})
+)
+
+// Collects the per network gauges from the registry at scrape time
+type networkCollector struct {
+	networks *NetworkList
+
+	connected  *prometheus.Desc
+	reconnects *prometheus.Desc
+	queueDepth *prometheus.Desc
+	lag        *prometheus.Desc
+}
+
+func newNetworkCollector(l *NetworkList) *networkCollector {
+
+	return &networkCollector{
+
+		networks: l,
+
+		connected: prometheus.NewDesc(metricsNamespace+"_connected",
+			"Whether the network is connected (1) or not (0).",
+			[]string{"network", "server"}, nil),
+		reconnects: prometheus.NewDesc(metricsNamespace+"_reconnects_total",
+			"Number of times the network has reconnected.",
+			[]string{"network"}, nil),
+		queueDepth: prometheus.NewDesc(metricsNamespace+"_send_queue_depth",
+			"Messages waiting in the send queue.",
+			[]string{"network"}, nil),
+		lag: prometheus.NewDesc(metricsNamespace+"_lag_seconds",
+			"Round trip time of the last lag ping.",
+			[]string{"network"}, nil),
+	}
+}
+
+func (c *networkCollector) Describe(ch chan<- *prometheus.Desc) {
+
+	ch <- c.connected
+	ch <- c.reconnects
+	ch <- c.queueDepth
+	ch <- c.lag
+}
+
+func (c *networkCollector) Collect(ch chan<- prometheus.Metric) {
+
+	for _, n := range c.networks.All() {
+
+		connected := 0.0
+		if n.ClientConn.Connected() {
+
+			connected = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.connected, prometheus.GaugeValue, connected, n.Name, n.Server)
+		ch <- prometheus.MustNewConstMetric(c.reconnects, prometheus.CounterValue, float64(n.Reconnects()), n.Name)
+		ch <- prometheus.MustNewConstMetric(c.queueDepth, prometheus.GaugeValue, float64(n.ClientConn.QueueLen()), n.Name)
+		ch <- prometheus.MustNewConstMetric(c.lag, prometheus.GaugeValue, n.ClientConn.Lag().Seconds(), n.Name)
+	}
+}
+
+func init() {
+
+	prometheus.MustRegister(
+		messagesReceived,
+		messagesSent,
+		crawlerRequests,
+		ddgDuration,
+		ddgErrors,
+		pluginRPCDuration,
+		newNetworkCollector(Networks),
+	)
+}
+
+// Message counters for a network, set as the ClientConn hooks
+func countReceived(network string) func(*irc.Message) {
+
+	return func(m *irc.Message) {
+
+		messagesReceived.WithLabelValues(network, m.Command).Inc()
+	}
+}
+
+func countSent(network string) func(*irc.Message) {
+
+	return func(m *irc.Message) {
+
+		messagesSent.WithLabelValues(network, m.Command).Inc()
+	}
+}
+
+func countCrawl(status int, mime string) {
+
+	crawlerRequests.WithLabelValues(strconv.Itoa(status), mime).Inc()
+}
+
+func observeDDG(network string, d time.Duration, err error) {
+
+	result := "ok"
+	if err != nil {
+
+		result = "error"
+		ddgErrors.WithLabelValues(network).Inc()
+	}
+	ddgDuration.WithLabelValues(result).Observe(d.Seconds())
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/TheCreeper/HackBot/store"
+)
+
+// Errors
+var (
+	ErrNoMigrations = errors.New("Database does not support migrations")
+	ErrMigrateUsage = errors.New("Usage: hackbot migrate [status | up | down [n] | to <version>]")
+)
+
+// Apply pending migrations at startup
+func migrateDatabase(db store.Store) error {
+
+	m, ok := db.(store.Migrator)
+	if !ok {
+
+		return nil
+	}
+
+	return m.Migrate()
+}
+
+// The migrate subcommand
+func runMigrate(db store.Store, w io.Writer, args []string) (err error) {
+
+	m, ok := db.(store.Migrator)
+	if !ok {
+
+		return ErrNoMigrations
+	}
+
+	cmd := "status"
+	if len(args) > 0 {
+
+		cmd = args[0]
+	}
+
+	switch cmd {
+
+	case "status":
+
+		status, err := m.MigrationStatus()
+		if err != nil {
+
+			return err
+		}
+		for _, s := range status {
+
+			applied := "pending"
+			if !s.Applied.IsZero() {
+
+				applied = "applied " + s.Applied.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d %-30s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+
+	case "up":
+
+		err = m.Migrate()
+
+	case "down":
+
+		steps := 1
+		if len(args) > 1 {
+
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+
+				return ErrMigrateUsage
+			}
+		}
+
+		version, err := m.Version()
+		if err != nil {
+
+			return err
+		}
+		status, err := m.MigrationStatus()
+		if err != nil {
+
+			return err
+		}
+
+		// Find the version steps below the current one
+		var applied []int
+		for _, s := range status {
+
+			if !s.Applied.IsZero() && s.Version <= version {
+
+				applied = append(applied, s.Version)
+			}
+		}
+		target := 0
+		if steps < len(applied) {
+
+			target = applied[len(applied)-steps-1]
+		}
+		if err = m.MigrateTo(target); err != nil {
+
+			return err
+		}
+
+	case "to":
+
+		if len(args) < 2 {
+
+			return ErrMigrateUsage
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+
+			return ErrMigrateUsage
+		}
+		if err = m.MigrateTo(version); err != nil {
+
+			return err
+		}
+
+	default:
+
+		return ErrMigrateUsage
+	}
+	if err != nil {
+
+		return
+	}
+
+	version, err := m.Version()
+	if err != nil {
+
+		return
+	}
+	fmt.Fprintf(w, "Schema version %d\n", version)
+
+	return
+}
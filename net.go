@@ -2,6 +2,7 @@ package main
 
 import (
 	"net"
+	"syscall"
 	"time"
 
 	"code.google.com/p/go.net/proxy"
@@ -13,6 +14,9 @@ type ConnHandler struct {
 	ProxyUsername string
 	ProxyPassword string
 	Timeout       time.Duration
+
+	// Checks the addresses of direct connections, not of the proxy
+	Control func(network, address string, c syscall.RawConn) error
 }
 
 func (handler *ConnHandler) HandleConnection(network, addr string) (conn net.Conn, err error) {
@@ -40,5 +44,6 @@ func (handler *ConnHandler) HandleConnection(network, addr string) (conn net.Con
 		return dialer.Dial(network, addr)
 	}
 
+	forwardDialer.Control = handler.Control
 	return forwardDialer.Dial(network, addr)
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TheCreeper/HackBot/ircutil"
+)
+
+// Number of recent errors kept per network
+const maxNetworkErrors = 20
+
+type NetworkError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// A Network is a running client for one configured server
+type Network struct {
+	Name   string
+	Server string
+
+	ClientConn *ircutil.ClientConn
+	State      *ircutil.State
+
+	// Dialer of plugin fetches on the network, through its proxy
+	Dial func(network, addr string) (net.Conn, error)
+
+	mu             sync.Mutex
+	transport      *http.Transport
+	connectedSince time.Time
+	connects       int
+	errors         []NetworkError
+}
+
+// Record an error against the network
+func (n *Network) Error(err error) {
+
+	if err == nil {
+
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.errors = append(n.errors, NetworkError{Time: time.Now(), Error: err.Error()})
+	if len(n.errors) > maxNetworkErrors {
+
+		n.errors = n.errors[len(n.errors)-maxNetworkErrors:]
+	}
+}
+
+// FetchTransport is the HTTP transport of plugin fetches through Dial, made
+// once so connections are kept alive and reused.
+func (n *Network) FetchTransport() *http.Transport {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.transport == nil {
+
+		n.transport = &http.Transport{
+
+			Dial:            n.Dial,
+			IdleConnTimeout: 90 * time.Second,
+		}
+	}
+
+	return n.transport
+}
+
+// Errors returns the recent errors, oldest first.
+func (n *Network) Errors() []NetworkError {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]NetworkError(nil), n.errors...)
+}
+
+func (n *Network) setConnected(connected bool) {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if connected {
+
+		n.connects++
+		n.connectedSince = time.Now()
+		return
+	}
+	n.connectedSince = time.Time{}
+}
+
+// ConnectedSince returns when the current connection was established, zero when
+// disconnected.
+func (n *Network) ConnectedSince() time.Time {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.connectedSince
+}
+
+// Reconnects returns how many times the network has reconnected.
+func (n *Network) Reconnects() int {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.connects < 1 {
+
+		return 0
+	}
+
+	return n.connects - 1
+}
+
+// Registry of the running networks
+type NetworkList struct {
+	mu       sync.RWMutex
+	networks map[string]*Network
+}
+
+var Networks = &NetworkList{networks: make(map[string]*Network)}
+
+func (l *NetworkList) Add(n *Network) {
+
+	l.mu.Lock()
+	l.networks[n.Name] = n
+	l.mu.Unlock()
+}
+
+func (l *NetworkList) Get(name string) (n *Network, ok bool) {
+
+	l.mu.RLock()
+	n, ok = l.networks[name]
+	l.mu.RUnlock()
+
+	return
+}
+
+// All returns the networks sorted by name.
+func (l *NetworkList) All() (networks []*Network) {
+
+	l.mu.RLock()
+	for _, n := range l.networks {
+
+		networks = append(networks, n)
+	}
+	l.mu.RUnlock()
+
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
+
+	return
+}
@@ -1,13 +1,72 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
+	"math"
 	"net"
 	"net/rpc"
 	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheCreeper/HackBot/ircutil"
+	"github.com/TheCreeper/HackBot/plugin"
+	"github.com/sorcix/irc"
+)
+
+// Errors
+var (
+	ErrPluginExists  = errors.New("Plugin is already running")
+	ErrNoSuchPlugin  = errors.New("No such plugin")
+	ErrPluginToken   = errors.New("Unknown plugin token")
+	ErrCommandExists = errors.New("Command is already registered")
+	ErrInvalidText   = errors.New("Text contains line breaks")
+	ErrPluginTimeout = errors.New("Plugin call timed out")
+	ErrDisabled      = errors.New("Plugin is not enabled there")
+	ErrDeniedCommand = errors.New("IRC command not allowed for plugins")
+	ErrUndeclared    = errors.New("Not declared in the plugin manifest")
+	ErrNoSecret      = errors.New("Remote plugins need a Secret and a Manifest")
+	ErrShortSecret   = errors.New("Remote plugin secrets need at least 16 characters")
+	ErrPluginTLS     = errors.New("PluginListen needs TLSCert and TLSKey on addresses other than loopback")
+	ErrPluginName    = errors.New("Invalid plugin name")
+	ErrInvalidTarget = errors.New("Invalid target")
+	ErrLocalAddress  = errors.New("Plugins can't fetch local or private addresses")
+)
+
+// Events queued per plugin before new ones are dropped
+const pluginEventQueue = 64
+
+// Longest accepted connection preamble
+const maxPreamble = 256
+
+// Time a plugin connection has to finish the TLS handshake and send the
+// preamble
+const preambleTimeout = 10 * time.Second
+
+// Shortest secret of remote plugins
+const minPluginSecret = 16
+
+// Supervision of plugin processes
+const (
+	defaultPluginCallTimeout = 10 * time.Second
+	defaultPluginHeartbeat   = 30 * time.Second
+	pluginMinBackoff         = time.Second
+	pluginMaxBackoff         = 5 * time.Minute
+	pluginStableAfter        = time.Minute // Runs this long reset the backoff
 )
 
 func NewServer() (net.Listener, error) {
@@ -15,16 +74,56 @@ func NewServer() (net.Listener, error) {
 	return serverListener_unix()
 }
 
-func Listen(l net.Listener) error {
+// RemoteListener listens on TCP for remote plugins, with TLS when a
+// certificate is given. Secrets are only sent in the clear over loopback.
+func RemoteListener(address, cert, key string) (l net.Listener, err error) {
+
+	if len(cert) < 1 && !loopbackAddress(address) {
+
+		return nil, ErrPluginTLS
+	}
+
+	var config *tls.Config
+	if len(cert) > 0 {
+
+		c, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+
+			return nil, err
+		}
+		config = &tls.Config{
+
+			Certificates: []tls.Certificate{c},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+
+	if l, err = net.Listen("tcp", address); err != nil {
+
+		return
+	}
+	if config != nil {
+
+		l = tls.NewListener(l, config)
+	}
+
+	return
+}
+
+func loopbackAddress(address string) bool {
 
-	conn, err := l.Accept()
+	host, _, err := net.SplitHostPort(address)
 	if err != nil {
 
-		return err
+		return false
 	}
-	rpc.ServeConn(conn)
+	if strings.EqualFold(host, "localhost") {
 
-	return nil
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
 }
 
 func serverListener(minPort, maxPort int64) (net.Listener, error) {
@@ -72,3 +171,1313 @@ func serverListener_unix() (net.Listener, error) {
 
 	return net.Listen("unix", path)
 }
+
+// A pluginRuntime runs a plugin inside the bot instead of in a process
+type pluginRuntime interface {
+	Event(e plugin.Event) error
+	Close()
+}
+
+// A Plugin is a supervised plugin process, restarted when it exits, a remote
+// plugin or one embedded in the bot
+type Plugin struct {
+	Config   PluginConfig
+	Manifest *plugin.Manifest
+
+	caps     map[string]bool // Granted capabilities
+	log      *slog.Logger
+	queue    chan plugin.Event
+	stop     chan struct{} // Closed when the plugin is stopped
+	done     chan struct{} // Closed once the plugin is removed from the host
+	embedded bool          // Runs inside the bot, nothing supervises it
+	storage  *pluginStorage
+
+	mu       sync.Mutex
+	stopped  bool
+	restarts int
+	channels map[string]bool // Enabled or disabled at runtime, by network/#channel
+
+	// State of the current process
+	token    string
+	cmd      *exec.Cmd
+	client   *rpc.Client   // Calls to the Plugin service
+	runtime  pluginRuntime // Runs an embedded plugin instead
+	conns    []pluginConn
+	started  time.Time
+	version  string          // Reported by the plugin in the handshake
+	events   map[string]bool // Subscribed event types, nil before the handshake
+	commands []string
+}
+
+// A connection of a plugin, numbered in the order connections were accepted
+type pluginConn struct {
+	net.Conn
+	seq uint64
+}
+
+// Detaches all connections of a plugin
+const allConns = math.MaxUint64
+
+// Capabilities returns the granted capabilities.
+func (p *Plugin) Capabilities() (caps []string) {
+
+	for c := range p.caps {
+
+		caps = append(caps, c)
+	}
+	sort.Strings(caps)
+
+	return
+}
+
+func contains(list []string, s string) bool {
+
+	for _, v := range list {
+
+		if strings.EqualFold(v, s) {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Whether a network, and a channel on it when not empty, match patterns
+// of "network", "#channel" or "network/#channel" globs. Empty patterns match
+// everything.
+func matchChannel(patterns []string, network, channel string) bool {
+
+	if len(patterns) < 1 {
+
+		return true
+	}
+
+	network = strings.ToLower(network)
+	channel = strings.ToLower(channel)
+	for _, pattern := range patterns {
+
+		pattern = strings.ToLower(pattern)
+		if len(channel) > 0 {
+
+			if ok, _ := path.Match(pattern, channel); ok {
+
+				return true
+			}
+			if ok, _ := path.Match(pattern, network+"/"+channel); ok {
+
+				return true
+			}
+		}
+		if ok, _ := path.Match(pattern, network); ok {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Whether the plugin is enabled on the network and in the channel, private
+// messages only need the network. Channels enabled or disabled at runtime
+// take precedence over the configured Channels.
+func (p *Plugin) enabled(network, channel string) bool {
+
+	if !matchChannel(p.Config.Networks, network, "") {
+
+		return false
+	}
+	if len(channel) < 1 {
+
+		return true
+	}
+
+	p.mu.Lock()
+	on, ok := p.channels[channelKey(network, channel)]
+	p.mu.Unlock()
+	if ok {
+
+		return on
+	}
+
+	return matchChannel(p.Config.Channels, network, channel)
+}
+
+// Whether the plugin is enabled in any of the channels
+func (p *Plugin) enabledIn(network string, channels []string) bool {
+
+	for _, c := range channels {
+
+		if p.enabled(network, c) {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func channelKey(network, channel string) string {
+
+	return strings.ToLower(network + "/" + channel)
+}
+
+// Namespace of the kv table keeping the channels a plugin was enabled or
+// disabled in
+func channelsNamespace(name string) string {
+
+	return "plugin-channels:" + name
+}
+
+// Load the channels the plugin was enabled or disabled in
+func (p *Plugin) loadChannels() (err error) {
+
+	entries, err := DB.Entries(channelsNamespace(p.Config.Name))
+	if err != nil {
+
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.channels = make(map[string]bool)
+	for _, e := range entries {
+
+		p.channels[e.Key] = string(e.Value) == "1"
+	}
+
+	return
+}
+
+// SetEnabled enables or disables the plugin in a channel, which is kept
+// across restarts.
+func (p *Plugin) SetEnabled(network, channel string, on bool) (err error) {
+
+	if !isChannel(channel) {
+
+		return ErrNoSuchChannel
+	}
+
+	value := "0"
+	if on {
+
+		value = "1"
+	}
+	key := channelKey(network, channel)
+	if err = DB.Set(channelsNamespace(p.Config.Name), key, []byte(value)); err != nil {
+
+		return
+	}
+
+	p.mu.Lock()
+	if p.channels == nil {
+
+		p.channels = make(map[string]bool)
+	}
+	p.channels[key] = on
+	p.mu.Unlock()
+	p.log.Info("plugin channel", "network", network, "channel", channel, "enabled", on)
+
+	return
+}
+
+// Channels returns the channels the plugin was enabled or disabled in at
+// runtime, as network/#channel.
+func (p *Plugin) Channels() (enabled, disabled []string) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, on := range p.channels {
+
+		if on {
+
+			enabled = append(enabled, key)
+		} else {
+
+			disabled = append(disabled, key)
+		}
+	}
+	sort.Strings(enabled)
+	sort.Strings(disabled)
+
+	return
+}
+
+// Kind of the plugin: process, remote, wasm or script
+func (p *Plugin) Kind() string {
+
+	return pluginKind(p.Config)
+}
+
+func pluginKind(cfg PluginConfig) string {
+
+	switch {
+
+	case cfg.Remote:
+
+		return "remote"
+
+	case filepath.Ext(cfg.Path) == ".wasm":
+
+		return "wasm"
+
+	case filepath.Ext(cfg.Path) == ".lua":
+
+		return "script"
+	}
+
+	return "process"
+}
+
+// Started returns when the current process was started.
+func (p *Plugin) Started() time.Time {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.started
+}
+
+// Restarts returns how often the plugin was restarted.
+func (p *Plugin) Restarts() int {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.restarts
+}
+
+// Version returns the version the plugin reported, empty before the handshake.
+func (p *Plugin) Version() string {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.version
+}
+
+// Events returns the subscribed event types.
+func (p *Plugin) Events() (events []string) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for e := range p.events {
+
+		events = append(events, e)
+	}
+	sort.Strings(events)
+
+	return
+}
+
+// Commands returns the commands registered by the plugin.
+func (p *Plugin) Commands() []string {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.commands...)
+}
+
+func (p *Plugin) subscribed(event string) bool {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.events != nil && (event == plugin.EventCommand || p.events[event])
+}
+
+// Queue an event, dropped when the plugin is not keeping up
+func (p *Plugin) enqueue(e plugin.Event) {
+
+	select {
+
+	case p.queue <- e:
+
+	default:
+
+		p.log.Warn("plugin event queue full, event dropped", "event", e.Type)
+	}
+}
+
+func (p *Plugin) callTimeout() time.Duration {
+
+	if p.Config.CallTimeoutSeconds > 0 {
+
+		return time.Duration(p.Config.CallTimeoutSeconds) * time.Second
+	}
+
+	return defaultPluginCallTimeout
+}
+
+// Call a method of the Plugin service, giving up after the call timeout
+func (p *Plugin) call(method string, args, reply interface{}) (err error) {
+
+	p.mu.Lock()
+	client, rt := p.client, p.runtime
+	p.mu.Unlock()
+	if rt != nil {
+
+		return p.callRuntime(rt, method, args, reply)
+	}
+	if client == nil {
+
+		return plugin.ErrHandshake
+	}
+
+	start := time.Now()
+	t := time.NewTimer(p.callTimeout())
+	defer t.Stop()
+
+	c := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+
+	case <-c.Done:
+
+		err = c.Error
+
+	case <-t.C:
+
+		err = ErrPluginTimeout
+	}
+	pluginRPCDuration.WithLabelValues(p.Config.Name, method).Observe(time.Since(start).Seconds())
+
+	return
+}
+
+// Embedded runtimes are called directly and enforce the timeout themselves
+func (p *Plugin) callRuntime(rt pluginRuntime, method string, args, reply interface{}) (err error) {
+
+	start := time.Now()
+	switch method {
+
+	case plugin.MethodEvent:
+
+		err = rt.Event(args.(plugin.Event))
+		*reply.(*bool) = err == nil
+
+	case plugin.MethodPing:
+
+		*reply.(*int) = args.(int)
+
+	default:
+
+		err = fmt.Errorf("Unknown plugin method %s", method)
+	}
+	pluginRPCDuration.WithLabelValues(p.Config.Name, method).Observe(time.Since(start).Seconds())
+
+	return
+}
+
+// Deliver queued events in order
+func (p *Plugin) deliver() {
+
+	for {
+
+		select {
+
+		case e := <-p.queue:
+
+			var ack bool
+			if err := p.call(plugin.MethodEvent, e, &ack); err != nil {
+
+				p.log.Error("plugin event", "event", e.Type, "error", err)
+			}
+
+		case <-p.stop:
+
+			return
+		}
+	}
+}
+
+// Ping the plugin, killing a process that does not answer so it is restarted
+func (p *Plugin) heartbeat() {
+
+	interval := defaultPluginHeartbeat
+	if p.Config.HeartbeatSeconds > 0 {
+
+		interval = time.Duration(p.Config.HeartbeatSeconds) * time.Second
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for seq := 0; ; seq++ {
+
+		select {
+
+		case <-t.C:
+
+		case <-p.stop:
+
+			return
+		}
+
+		p.mu.Lock()
+		ready := p.client != nil && p.events != nil
+		p.mu.Unlock()
+		if !ready {
+
+			continue
+		}
+
+		var reply int
+		if err := p.call(plugin.MethodPing, seq, &reply); err != nil {
+
+			p.log.Error("plugin heartbeat failed, killing it", "error", err)
+			p.kill()
+		}
+	}
+}
+
+// Kill the current process, or drop the connections of a remote plugin
+func (p *Plugin) kill() {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cmd != nil && p.cmd.Process != nil {
+
+		p.cmd.Process.Kill()
+		return
+	}
+	for _, conn := range p.conns {
+
+		conn.Close()
+	}
+}
+
+// The PluginHost runs the configured plugins and serves their connections
+type PluginHost struct {
+	Listener net.Listener
+	Networks *NetworkList
+
+	// Plugins and scripts that can be loaded at runtime
+	Configs []PluginConfig
+	Scripts ScriptsConfig
+
+	mu       sync.Mutex
+	plugins  map[string]*Plugin // By name
+	tokens   map[string]*Plugin
+	accepted uint64 // Connections accepted
+}
+
+func NewPluginHost(l net.Listener, networks *NetworkList) *PluginHost {
+
+	return &PluginHost{
+
+		Listener: l,
+		Networks: networks,
+		plugins:  make(map[string]*Plugin),
+		tokens:   make(map[string]*Plugin),
+	}
+}
+
+// Address of the plugin socket as passed to plugins
+func (h *PluginHost) Address() string {
+
+	return h.Listener.Addr().Network() + ":" + h.Listener.Addr().String()
+}
+
+// Plugin returns the running plugin with the name.
+func (h *PluginHost) Plugin(name string) (p *Plugin, ok bool) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	p, ok = h.plugins[name]
+	return
+}
+
+// All returns the running plugins.
+func (h *PluginHost) All() (plugins []*Plugin) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, p := range h.plugins {
+
+		plugins = append(plugins, p)
+	}
+
+	return
+}
+
+// Serve accepts plugin connections until the listener is closed.
+func (h *PluginHost) Serve() error {
+
+	return h.ServeListener(h.Listener)
+}
+
+// ServeListener accepts plugin connections on another listener, such as one
+// for remote plugins.
+func (h *PluginHost) ServeListener(l net.Listener) error {
+
+	for {
+
+		conn, err := l.Accept()
+		if err != nil {
+
+			return err
+		}
+		h.mu.Lock()
+		h.accepted++
+		seq := h.accepted
+		h.mu.Unlock()
+		go h.serveConn(conn, seq)
+	}
+}
+
+// Read the preamble a byte at a time, so nothing of the RPC stream following
+// it is buffered away
+func readPreamble(conn net.Conn) (role, token string, err error) {
+
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < maxPreamble {
+
+		if _, err = conn.Read(b); err != nil {
+
+			return
+		}
+		if b[0] == '\n' {
+
+			fields := strings.Fields(string(line))
+			if len(fields) != 3 || fields[0] != "HACKBOT" {
+
+				return "", "", plugin.ErrPreamble
+			}
+			return fields[1], fields[2], nil
+		}
+		line = append(line, b[0])
+	}
+
+	return "", "", plugin.ErrPreamble
+}
+
+func (h *PluginHost) serveConn(conn net.Conn, seq uint64) {
+
+	// Connections that never finish the handshake are dropped
+	conn.SetDeadline(time.Now().Add(preambleTimeout))
+	role, token, err := readPreamble(conn)
+	if err != nil {
+
+		slog.Warn("plugin connection", "remote", conn.RemoteAddr().String(), "error", err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	h.mu.Lock()
+	p, ok := h.tokens[tokenKey(token)]
+	h.mu.Unlock()
+	if !ok {
+
+		slog.Warn("plugin connection", "remote", conn.RemoteAddr().String(), "error", ErrPluginToken)
+		conn.Close()
+		return
+	}
+
+	// A remote plugin connecting again replaces its old connections. It
+	// connects to serve first, so its new connection to call may be in
+	// already and is kept.
+	if role == plugin.RoleServe && p.Config.Remote {
+
+		if rt := h.detach(p, seq); rt != nil {
+
+			rt.Close()
+		}
+		p.log.Info("remote plugin connected", "remote", conn.RemoteAddr().String())
+	}
+
+	p.mu.Lock()
+	p.conns = append(p.conns, pluginConn{conn, seq})
+	p.mu.Unlock()
+
+	switch role {
+
+	case plugin.RoleCall:
+
+		srv := rpc.NewServer()
+		if err = srv.RegisterName("Host", &pluginAPI{host: h, plugin: p}); err != nil {
+
+			p.log.Error("rpc.RegisterName()", "error", err)
+			conn.Close()
+			return
+		}
+		srv.ServeConn(conn)
+
+		if p.Config.Remote && p.hasConn(conn) {
+
+			p.log.Info("remote plugin disconnected")
+			h.cleanup(p)
+		}
+
+	case plugin.RoleServe:
+
+		p.mu.Lock()
+		p.client = rpc.NewClient(conn)
+		p.mu.Unlock()
+
+	default:
+
+		p.log.Warn("plugin connection", "role", role, "error", plugin.ErrPreamble)
+		conn.Close()
+	}
+}
+
+func (p *Plugin) hasConn(conn net.Conn) bool {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.conns {
+
+		if c.Conn == conn {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Tokens are looked up by their hash, so the time taken does not depend on
+// how much of a guessed token is right
+func tokenKey(token string) string {
+
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Writes the output of a plugin process to the log a line at a time
+type lineLogger struct {
+	log    *slog.Logger
+	stream string
+	buf    []byte
+}
+
+func (l *lineLogger) Write(b []byte) (int, error) {
+
+	l.buf = append(l.buf, b...)
+	for {
+
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+
+			break
+		}
+		l.log.Info("plugin output", "stream", l.stream, "line", string(bytes.TrimRight(l.buf[:i], "\r")))
+		l.buf = l.buf[i+1:]
+	}
+
+	// Overlong lines are logged in pieces
+	if len(l.buf) > 4096 {
+
+		l.log.Info("plugin output", "stream", l.stream, "line", string(l.buf))
+		l.buf = nil
+	}
+
+	return len(b), nil
+}
+
+func newToken() (string, error) {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func newPlugin(cfg PluginConfig, manifest *plugin.Manifest) *Plugin {
+
+	p := &Plugin{
+
+		Config:   cfg,
+		Manifest: manifest,
+		caps:     make(map[string]bool),
+		log:      slog.Default().With("plugin", cfg.Name),
+		queue:    make(chan plugin.Event, pluginEventQueue),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		storage:  newPluginStorage(cfg),
+	}
+	if err := p.loadChannels(); err != nil {
+
+		p.log.Error("plugin channels", "error", err)
+	}
+
+	// Requested capabilities are granted when the configuration does, only
+	// send when it does not say
+	granted := cfg.Capabilities
+	if granted == nil {
+
+		granted = []string{plugin.CapSend}
+	}
+	for _, c := range manifest.Capabilities {
+
+		if contains(granted, c) {
+
+			p.caps[c] = true
+		} else {
+
+			p.log.Warn("plugin capability not granted", "capability", c)
+		}
+	}
+
+	return p
+}
+
+// Start runs a plugin executable and supervises it, restarting it with a
+// backoff when it exits. Remote plugins are not started, they connect with
+// their secret, and WebAssembly modules are run inside the bot.
+func (h *PluginHost) Start(cfg PluginConfig) (err error) {
+
+	if cfg.Remote && (len(cfg.Secret) < 1 || len(cfg.Manifest) < 1) {
+
+		return ErrNoSecret
+	}
+	if cfg.Remote && len(cfg.Secret) < minPluginSecret {
+
+		return ErrShortSecret
+	}
+
+	file := cfg.Manifest
+	if len(file) < 1 {
+
+		file = cfg.Path + ".json"
+	}
+	manifest, err := plugin.LoadManifest(file)
+	if err != nil {
+
+		return
+	}
+
+	p := newPlugin(cfg, manifest)
+	if filepath.Ext(cfg.Path) == ".wasm" {
+
+		p.embedded = true
+		return h.loadWasm(p)
+	}
+
+	h.mu.Lock()
+	if _, ok := h.plugins[cfg.Name]; ok {
+
+		h.mu.Unlock()
+		return ErrPluginExists
+	}
+	h.plugins[cfg.Name] = p
+	if cfg.Remote {
+
+		h.tokens[tokenKey(cfg.Secret)] = p
+	}
+	h.mu.Unlock()
+
+	if cfg.Remote {
+
+		go p.deliver()
+		go p.heartbeat()
+		return
+	}
+
+	if err = h.launch(p); err != nil {
+
+		h.mu.Lock()
+		delete(h.plugins, cfg.Name)
+		h.mu.Unlock()
+		return
+	}
+
+	go p.deliver()
+	go p.heartbeat()
+	go h.supervise(p)
+
+	return
+}
+
+// Stop kills a plugin without restarting it, returning once it is removed.
+func (h *PluginHost) Stop(name string) error {
+
+	p, ok := h.Plugin(name)
+	if !ok {
+
+		return ErrNoSuchPlugin
+	}
+
+	p.mu.Lock()
+	if !p.stopped {
+
+		p.stopped = true
+		close(p.stop)
+	}
+	p.mu.Unlock()
+	p.kill()
+
+	// Nothing supervises remote and embedded plugins
+	if p.Config.Remote || p.embedded {
+
+		h.cleanup(p)
+		if p.Config.Remote {
+
+			h.mu.Lock()
+			delete(h.tokens, tokenKey(p.Config.Secret))
+			h.mu.Unlock()
+		}
+		h.remove(p)
+	}
+	<-p.done
+
+	return nil
+}
+
+// Remove a stopped plugin from the host
+func (h *PluginHost) remove(p *Plugin) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.plugins[p.Config.Name] == p {
+
+		delete(h.plugins, p.Config.Name)
+	}
+	select {
+
+	case <-p.done:
+
+	default:
+
+		close(p.done)
+	}
+}
+
+// Load starts a configured plugin, or a script in the scripts directory, by
+// name.
+func (h *PluginHost) Load(name string) error {
+
+	if len(name) < 1 || filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+
+		return ErrPluginName
+	}
+	if _, ok := h.Plugin(name); ok {
+
+		return ErrPluginExists
+	}
+
+	for _, cfg := range h.Configs {
+
+		if cfg.Name == name {
+
+			return h.Start(cfg)
+		}
+	}
+	if len(h.Scripts.Dir) > 0 {
+
+		file := filepath.Join(h.Scripts.Dir, name+".lua")
+		if _, err := os.Stat(file); err == nil {
+
+			return h.LoadScript(file, h.Scripts)
+		}
+	}
+
+	return ErrNoSuchPlugin
+}
+
+// Reload stops a plugin and starts it again, scripts are reloaded in place.
+func (h *PluginHost) Reload(name string) (err error) {
+
+	p, ok := h.Plugin(name)
+	if !ok {
+
+		return ErrNoSuchPlugin
+	}
+	if p.Kind() == "script" {
+
+		return h.LoadScript(p.Config.Path, h.Scripts)
+	}
+
+	if err = h.Stop(name); err != nil {
+
+		return
+	}
+	for _, cfg := range h.Configs {
+
+		if cfg.Name == name {
+
+			return h.Start(cfg)
+		}
+	}
+
+	return h.Start(p.Config)
+}
+
+// Attach the runtime of an embedded plugin once it is loaded, doing the
+// handshake and registering its commands, and add a new plugin to the host.
+func (h *PluginHost) attach(p *Plugin, rt pluginRuntime, version string, events []string, usages map[string]string, reload bool) (err error) {
+
+	api := &pluginAPI{host: h, plugin: p}
+	var reply plugin.HandshakeReply
+	err = api.Handshake(plugin.HandshakeArgs{
+
+		Version:       plugin.ProtocolVersion,
+		Name:          p.Manifest.Name,
+		PluginVersion: version,
+		Events:        events,
+	}, &reply)
+	if err != nil {
+
+		return
+	}
+
+	var names []string
+	for name := range usages {
+
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+
+		var ack bool
+		if err = api.RegisterCommand(plugin.CommandArgs{Name: name, Usage: usages[name]}, &ack); err != nil {
+
+			h.cleanup(p)
+			return fmt.Errorf("command %s: %w", name, err)
+		}
+	}
+
+	p.mu.Lock()
+	p.runtime = rt
+	p.started = time.Now()
+	if reload {
+
+		p.restarts++
+	}
+	p.mu.Unlock()
+
+	if reload {
+
+		return
+	}
+
+	h.mu.Lock()
+	if _, ok := h.plugins[p.Config.Name]; ok {
+
+		h.mu.Unlock()
+		h.cleanup(p)
+		return ErrPluginExists
+	}
+	h.plugins[p.Config.Name] = p
+	h.mu.Unlock()
+	go p.deliver()
+
+	return
+}
+
+// Start a process of the plugin
+func (h *PluginHost) launch(p *Plugin) (err error) {
+
+	token, err := newToken()
+	if err != nil {
+
+		return
+	}
+
+	cfg := p.Config
+	cmd := exec.Command(cfg.Path, cfg.Args...)
+	cmd.Env = append(os.Environ(),
+		plugin.EnvAddress+"="+h.Address(),
+		plugin.EnvToken+"="+token,
+		plugin.EnvName+"="+cfg.Name,
+		plugin.EnvConfig+"="+string(cfg.Config))
+	cmd.Stdout = &lineLogger{log: p.log, stream: "stdout"}
+	cmd.Stderr = &lineLogger{log: p.log, stream: "stderr"}
+	setProcAttr(cmd)
+
+	h.mu.Lock()
+	h.tokens[tokenKey(token)] = p
+	h.mu.Unlock()
+
+	if err = cmd.Start(); err != nil {
+
+		h.mu.Lock()
+		delete(h.tokens, tokenKey(token))
+		h.mu.Unlock()
+		return
+	}
+	if err = applyLimits(cmd.Process.Pid, cfg.Limits); err != nil {
+
+		cmd.Process.Kill()
+		cmd.Wait()
+		h.mu.Lock()
+		delete(h.tokens, tokenKey(token))
+		h.mu.Unlock()
+		return fmt.Errorf("plugin %s limits: %w", cfg.Name, err)
+	}
+
+	p.mu.Lock()
+	p.token = token
+	p.cmd = cmd
+	p.started = time.Now()
+
+	// Stop may have killed the previous process while this one started, the
+	// supervisor then sees this one exit and the plugin stopped
+	if p.stopped {
+
+		cmd.Process.Kill()
+	}
+	p.mu.Unlock()
+	p.log.Info("plugin started", "path", cfg.Path, "pid", cmd.Process.Pid)
+
+	return
+}
+
+// Wait for the plugin process to exit and restart it until it is stopped
+func (h *PluginHost) supervise(p *Plugin) {
+
+	backoff := pluginMinBackoff
+	for {
+
+		p.mu.Lock()
+		cmd := p.cmd
+		p.mu.Unlock()
+
+		err := cmd.Wait()
+		h.cleanup(p)
+
+		select {
+
+		case <-p.stop:
+
+			p.log.Info("plugin stopped")
+			h.remove(p)
+			return
+
+		default:
+		}
+
+		if time.Since(p.Started()) >= pluginStableAfter {
+
+			backoff = pluginMinBackoff
+		}
+		p.log.Warn("plugin exited, restarting", "error", err, "backoff", backoff.String())
+
+		for {
+
+			select {
+
+			case <-time.After(backoff):
+
+			case <-p.stop:
+
+				h.remove(p)
+				return
+			}
+
+			backoff *= 2
+			if backoff > pluginMaxBackoff {
+
+				backoff = pluginMaxBackoff
+			}
+
+			err = h.launch(p)
+			if err == nil {
+
+				break
+			}
+			p.log.Error("plugin restart", "error", err, "backoff", backoff.String())
+		}
+
+		p.mu.Lock()
+		p.restarts++
+		p.mu.Unlock()
+	}
+}
+
+// Drop the commands, connections and token of an exited process
+func (h *PluginHost) cleanup(p *Plugin) {
+
+	// The runtime is closed without holding the lock, as a running handler
+	// may be calling the Host service
+	if rt := h.detach(p, allConns); rt != nil {
+
+		rt.Close()
+	}
+}
+
+// Detach drops the commands of a plugin and its connections accepted before
+// the given one, or all of them, and returns its embedded runtime, left open
+// for the caller.
+func (h *PluginHost) detach(p *Plugin, before uint64) (rt pluginRuntime) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rt = p.runtime
+	p.runtime = nil
+
+	// Remote plugins keep their secret to connect again
+	if !p.Config.Remote {
+
+		h.mu.Lock()
+		delete(h.tokens, tokenKey(p.token))
+		h.mu.Unlock()
+	}
+
+	for _, name := range p.commands {
+
+		if c, ok := Commands.Get(name); ok && c.Plugin == p.Config.Name {
+
+			Commands.Unregister(name)
+		}
+	}
+	p.commands = nil
+	var kept []pluginConn
+	for _, conn := range p.conns {
+
+		if conn.seq >= before {
+
+			kept = append(kept, conn)
+			continue
+		}
+		conn.Close()
+	}
+	p.conns = kept
+	p.client = nil
+	p.events = nil
+
+	return
+}
+
+// Publish queues an event for the plugins subscribed to it and enabled where
+// it happened. QUIT and NICK have no channel, they reach the plugins enabled
+// in one of the channels of the nick.
+func (h *PluginHost) Publish(e plugin.Event, channels []string) {
+
+	for _, p := range h.All() {
+
+		if !p.subscribed(e.Type) {
+
+			continue
+		}
+
+		enabled := p.enabled(e.Network, e.Channel)
+		if e.Type == plugin.EventQuit || e.Type == plugin.EventNick {
+
+			enabled = p.enabledIn(e.Network, channels)
+		}
+		if enabled {
+
+			p.enqueue(e)
+		}
+	}
+}
+
+// Convert a message into a plugin event
+func pluginEvent(network string, m *irc.Message, account string) (e plugin.Event, ok bool) {
+
+	if m.Prefix == nil || len(m.Prefix.Name) < 1 {
+
+		return
+	}
+
+	e = plugin.Event{
+
+		Network: network,
+		Nick:    m.Prefix.Name,
+		User:    m.Prefix.User,
+		Host:    m.Prefix.Host,
+		Account: account,
+		Text:    m.Trailing,
+		Time:    time.Now(),
+	}
+
+	first := m.Trailing
+	if len(m.Params) > 0 {
+
+		first = m.Params[0]
+	}
+
+	switch m.Command {
+
+	case irc.PRIVMSG, irc.NOTICE:
+
+		e.Type = plugin.EventMessage
+		if m.Command == irc.NOTICE {
+
+			e.Type = plugin.EventNotice
+		}
+		if strings.HasPrefix(m.Trailing, "\x01ACTION ") {
+
+			e.Type = plugin.EventAction
+			e.Text = strings.Trim(strings.TrimPrefix(m.Trailing, "\x01ACTION "), "\x01")
+		}
+		if isChannel(first) {
+
+			e.Channel = first
+		}
+
+	case irc.JOIN:
+
+		e.Type = plugin.EventJoin
+		e.Channel = first
+		e.Text = ""
+
+	case irc.PART:
+
+		e.Type = plugin.EventPart
+		e.Channel = first
+
+	case irc.QUIT:
+
+		e.Type = plugin.EventQuit
+
+	case irc.KICK:
+
+		if len(m.Params) < 2 {
+
+			return
+		}
+		e.Type = plugin.EventKick
+		e.Channel = m.Params[0]
+		e.Target = m.Params[1]
+
+	case irc.NICK:
+
+		e.Type = plugin.EventNick
+		e.Target = first
+		e.Text = ""
+
+	case irc.TOPIC:
+
+		e.Type = plugin.EventTopic
+		e.Channel = first
+
+	default:
+
+		return
+	}
+
+	return e, true
+}
+
+// Forward received messages to the plugins
+func forwardPlugins(host *PluginHost, network string, cc *ircutil.ClientConn) func(*irc.Message) {
+
+	return func(m *irc.Message) {
+
+		if m.Prefix == nil {
+
+			return
+		}
+		if e, ok := pluginEvent(network, m, cc.State.Account(m.Prefix.Name)); ok {
+
+			host.Publish(e, cc.State.UserChannels(m.Prefix.Name))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Capabilities a plugin can be granted
+const (
+	CapSend     = "send"       // Send messages and notices to enabled channels and to nicks
+	CapJoin     = "join"       // Join and part channels
+	CapRaw      = "raw"        // Send raw IRC lines, apart from connection and operator commands
+	CapDatabase = "database"   // Keep data in the bot database
+	CapHTTP     = "http-fetch" // Fetch URLs through the bot
+	CapState    = "state"      // Query the channels and users the bot sees
+)
+
+// A Manifest declares what a plugin does and the capabilities it needs. It is
+// read from a JSON file next to the plugin:
+//
+//	{
+//		"name": "weather",
+//		"version": "1.2",
+//		"description": "Weather forecasts",
+//		"events": ["message"],
+//		"commands": ["weather"],
+//		"capabilities": ["send", "http-fetch"]
+//	}
+type Manifest struct {
+	Name         string   `json:"name"`
+	Version      string   `json:"version"`
+	Description  string   `json:"description"`
+	Events       []string `json:"events"`
+	Commands     []string `json:"commands"`
+	Capabilities []string `json:"capabilities"`
+}
+
+func LoadManifest(file string) (m *Manifest, err error) {
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+
+		return
+	}
+
+	m = new(Manifest)
+	if err = json.Unmarshal(b, m); err != nil {
+
+		return nil, fmt.Errorf("manifest %s: %w", file, err)
+	}
+	if len(m.Name) < 1 {
+
+		return nil, fmt.Errorf("manifest %s: no name", file)
+	}
+
+	return
+}
+
+func contains(list []string, s string) bool {
+
+	for _, v := range list {
+
+		if v == s {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasEvent reports whether the manifest declares the event.
+func (m *Manifest) HasEvent(event string) bool {
+
+	return contains(m.Events, event)
+}
+
+// HasCommand reports whether the manifest declares the command.
+func (m *Manifest) HasCommand(name string) bool {
+
+	return contains(m.Commands, name)
+}
+
+// HasCapability reports whether the manifest requests the capability.
+func (m *Manifest) HasCapability(capability string) bool {
+
+	return contains(m.Capabilities, capability)
+}
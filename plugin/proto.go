@@ -0,0 +1,253 @@
+// Package plugin defines the protocol spoken between HackBot and its plugins.
+//
+// Plugins are executables started by the bot. They find the address of the
+// bot's plugin socket and their token in the environment and make two
+// connections to it, each opened with a preamble line naming its role:
+//
+//	HACKBOT call <token>
+//	HACKBOT serve <token>
+//
+// On the call connection the plugin is a net/rpc client of the Host service,
+// on the serve connection the bot is a client of the Plugin service the plugin
+// serves. The first call must be Host.Handshake, which checks the protocol
+// version and subscribes the plugin to events.
+//
+// Remote plugins run elsewhere and connect over TCP, optionally with TLS,
+// using the shared secret from the bot's configuration as their token.
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Version of the protocol, bumped on incompatible changes
+const ProtocolVersion = 1
+
+// Environment passed to plugin executables
+const (
+	EnvAddress = "HACKBOT_PLUGIN_ADDRESS" // network:address of the socket, e.g. unix:/tmp/irc-plugin123 or tls:bot.example.org:7000
+	EnvToken   = "HACKBOT_PLUGIN_TOKEN"   // The token, or the shared secret of a remote plugin
+	EnvName    = "HACKBOT_PLUGIN_NAME"
+	EnvConfig  = "HACKBOT_PLUGIN_CONFIG" // JSON configuration of the plugin
+	EnvCA      = "HACKBOT_PLUGIN_CA"     // PEM file of the CA verifying the bot for tls addresses, the system pool by default
+)
+
+// Connection roles
+const (
+	RoleCall  = "call"  // The plugin calls the Host service
+	RoleServe = "serve" // The bot calls the Plugin service
+)
+
+// Errors
+var (
+	ErrVersion   = errors.New("Unsupported plugin protocol version")
+	ErrHandshake = errors.New("Plugin has not completed the handshake")
+	ErrPreamble  = errors.New("Invalid plugin connection preamble")
+	ErrDenied    = errors.New("Capability not granted")
+)
+
+// Preamble returns the line opening a connection.
+func Preamble(role, token string) string {
+
+	return fmt.Sprintf("HACKBOT %s %s\n", role, token)
+}
+
+// Event types
+const (
+	EventMessage = "message" // PRIVMSG to a channel or the bot
+	EventAction  = "action"  // CTCP ACTION
+	EventNotice  = "notice"
+	EventJoin    = "join"
+	EventPart    = "part"
+	EventQuit    = "quit"
+	EventKick    = "kick"
+	EventNick    = "nick"
+	EventTopic   = "topic"
+	EventCommand = "command" // A command registered by the plugin
+)
+
+// An Event sent to plugins
+type Event struct {
+	Type    string
+	Network string
+	Channel string // Empty for private messages, quits and nick changes
+	Nick    string
+	User    string
+	Host    string
+	Account string // Services account of the nick, when known
+	Text    string
+	Target  string // The kicked nick or the new nick
+	Time    time.Time
+
+	// Name and arguments of a command
+	Command string
+	Args    string
+}
+
+// Private reports whether the event is a private message to the bot.
+func (e *Event) Private() bool {
+
+	return len(e.Channel) < 1 && (e.Type == EventMessage || e.Type == EventAction || e.Type == EventCommand)
+}
+
+// ReplyTarget is where an answer to the event goes, the channel or the nick.
+func (e *Event) ReplyTarget() string {
+
+	if len(e.Channel) > 0 {
+
+		return e.Channel
+	}
+
+	return e.Nick
+}
+
+/*
+   Host service, called by plugins
+*/
+
+type HandshakeArgs struct {
+	Version       int
+	Name          string
+	PluginVersion string
+	Events        []string // Event types to receive, commands are always sent
+}
+
+type HandshakeReply struct {
+	Version  int
+	Networks []string
+}
+
+type SendArgs struct {
+	Network string
+	Target  string
+	Text    string
+	Notice  bool
+	Action  bool
+}
+
+type JoinArgs struct {
+	Network string
+	Channel string
+}
+
+type RawArgs struct {
+	Network string
+	Line    string
+}
+
+type FetchArgs struct {
+	URL       string
+	Network   string // Fetched through the proxy of the network when set
+	UserAgent string
+}
+
+type FetchReply struct {
+	Status      int
+	ContentType string
+	Body        []byte // At most 1MB
+}
+
+// Kinds of lookups reported by the bundled plugins
+const (
+	ReportDDG   = "ddg"
+	ReportCrawl = "crawl"
+)
+
+// ReportArgs tell the bot how a lookup went. Kinds are counted in the bot's
+// metrics and errors are added to those of the network.
+type ReportArgs struct {
+	Network  string
+	Kind     string        // ReportDDG, ReportCrawl or empty for only the error
+	Duration time.Duration // Of ddg queries
+	Status   int           // HTTP status of crawls, 0 when there was no response
+	MIME     string        // Detected MIME type of crawls
+	Error    string        // Empty when the lookup worked
+}
+
+type StateArgs struct {
+	Network string
+	Channel string // The channels of the network when empty
+}
+
+type StateReply struct {
+	Nick     string // Of the bot
+	Channels []string
+	Topic    string
+	Users    map[string]string // Nick to mode prefix (@, +, ...)
+}
+
+// Keys of plugin storage are up to 250 bytes. Values, lists and sorted sets
+// each have keys of their own.
+type KVArgs struct {
+	Key   string
+	Value []byte
+}
+
+type KVReply struct {
+	Value []byte
+	Found bool
+}
+
+type KeysArgs struct {
+	Prefix string
+}
+
+type KeysReply struct {
+	Values     []string
+	Lists      []string
+	SortedSets []string
+}
+
+// Ranges are from Start to Stop, inclusive, with negative indexes counting
+// from the end as in Redis
+type ListArgs struct {
+	Key    string
+	Values []string // Pushed
+	Front  bool     // Push and pop at the front instead of the end
+	Start  int
+	Stop   int
+}
+
+type ListReply struct {
+	Values []string
+	Length int
+	Found  bool
+}
+
+type ZArgs struct {
+	Key     string
+	Member  string
+	Score   float64
+	Incr    bool // Add to the score of the member
+	Start   int
+	Stop    int
+	Reverse bool // Highest scores first
+}
+
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+type ZReply struct {
+	Members []ZMember
+	Score   float64
+	Found   bool
+}
+
+type CommandArgs struct {
+	Name  string
+	Usage string
+}
+
+/*
+   Plugin service, called by the bot
+*/
+
+// Methods of the Plugin service
+const (
+	MethodEvent = "Plugin.Event"
+	MethodPing  = "Plugin.Ping" // Heartbeat, answered with the argument
+)
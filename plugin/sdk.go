@@ -0,0 +1,529 @@
+package plugin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Errors
+var (
+	ErrNoAddress = errors.New("Not started by HackBot, " + EnvAddress + " is not set")
+	ErrNoCA      = errors.New("No certificates found in " + EnvCA)
+)
+
+// Handler handles an event.
+type Handler func(c *Client, e *Event)
+
+type command struct {
+	usage   string
+	handler Handler
+}
+
+// A Plugin dispatches the events of the bot to its handlers. Events are only
+// subscribed to when a handler is set for them.
+//
+//	p := plugin.New("echo", "1.0")
+//	p.OnCommand("echo", "!echo <text>", func(c *plugin.Client, e *plugin.Event) {
+//
+//		c.Reply(e, e.Args)
+//	})
+//	log.Fatal(p.Run())
+type Plugin struct {
+	Name    string
+	Version string
+
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+	commands map[string]command
+}
+
+func New(name, version string) *Plugin {
+
+	return &Plugin{
+
+		Name:     name,
+		Version:  version,
+		handlers: make(map[string][]Handler),
+		commands: make(map[string]command),
+	}
+}
+
+// LoadConfig decodes the Config of the plugin set in the bot configuration,
+// leaving v as it is when there is none.
+func LoadConfig(v interface{}) error {
+
+	config := os.Getenv(EnvConfig)
+	if len(config) < 1 || config == "null" {
+
+		return nil
+	}
+
+	return json.Unmarshal([]byte(config), v)
+}
+
+// On adds a handler for an event type.
+func (p *Plugin) On(event string, h Handler) {
+
+	p.mu.Lock()
+	p.handlers[event] = append(p.handlers[event], h)
+	p.mu.Unlock()
+}
+
+// OnMessage handles messages to channels and the bot.
+func (p *Plugin) OnMessage(h Handler) {
+
+	p.On(EventMessage, h)
+}
+
+// OnAction handles /me actions.
+func (p *Plugin) OnAction(h Handler) {
+
+	p.On(EventAction, h)
+}
+
+// OnJoin handles users joining channels.
+func (p *Plugin) OnJoin(h Handler) {
+
+	p.On(EventJoin, h)
+}
+
+// OnPart handles users leaving channels.
+func (p *Plugin) OnPart(h Handler) {
+
+	p.On(EventPart, h)
+}
+
+// OnCommand registers a bot command with the handler, Event.Args holds the
+// text following the command.
+func (p *Plugin) OnCommand(name, usage string, h Handler) {
+
+	p.mu.Lock()
+	p.commands[strings.ToLower(name)] = command{usage, h}
+	p.mu.Unlock()
+}
+
+func (p *Plugin) events() (events []string) {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for e := range p.handlers {
+
+		events = append(events, e)
+	}
+
+	return
+}
+
+func (p *Plugin) dispatch(c *Client, e *Event) (err error) {
+
+	defer func() {
+
+		if r := recover(); r != nil {
+
+			err = fmt.Errorf("%s: panic handling %s event: %v", p.Name, e.Type, r)
+		}
+	}()
+
+	p.mu.RLock()
+	handlers := p.handlers[e.Type]
+	if e.Type == EventCommand {
+
+		if cmd, ok := p.commands[e.Command]; ok {
+
+			handlers = []Handler{cmd.handler}
+		}
+	}
+	p.mu.RUnlock()
+
+	for _, h := range handlers {
+
+		h(c, e)
+	}
+
+	return
+}
+
+// TLS configuration for remote plugins, trusting the CA in EnvCA when set
+func tlsConfig(addr string) (config *tls.Config, err error) {
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+
+		return
+	}
+	config = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+
+	file := os.Getenv(EnvCA)
+	if len(file) < 1 {
+
+		return
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+
+		return nil, err
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(b) {
+
+		return nil, ErrNoCA
+	}
+
+	return
+}
+
+// Dial a connection to the bot and send its preamble
+func dial(address, role, token string) (conn net.Conn, err error) {
+
+	network, addr, ok := strings.Cut(address, ":")
+	if !ok {
+
+		return nil, fmt.Errorf("Invalid plugin address %q", address)
+	}
+	if network == "tls" {
+
+		var config *tls.Config
+		if config, err = tlsConfig(addr); err != nil {
+
+			return
+		}
+		conn, err = tls.Dial("tcp", addr, config)
+	} else {
+
+		conn, err = net.Dial(network, addr)
+	}
+	if err != nil {
+
+		return
+	}
+	if _, err = conn.Write([]byte(Preamble(role, token))); err != nil {
+
+		conn.Close()
+		return nil, err
+	}
+
+	return
+}
+
+// Run connects to the bot, registers the commands and handles events until
+// the bot closes the connection.
+func (p *Plugin) Run() (err error) {
+
+	address := os.Getenv(EnvAddress)
+	token := os.Getenv(EnvToken)
+	if len(address) < 1 {
+
+		return ErrNoAddress
+	}
+
+	// The serve connection comes first so no event is sent before the
+	// plugin can receive it
+	serveConn, err := dial(address, RoleServe, token)
+	if err != nil {
+
+		return
+	}
+	defer serveConn.Close()
+
+	callConn, err := dial(address, RoleCall, token)
+	if err != nil {
+
+		return
+	}
+	c := &Client{rpc: rpc.NewClient(callConn)}
+	defer c.Close()
+
+	srv := rpc.NewServer()
+	if err = srv.RegisterName("Plugin", &service{plugin: p, client: c}); err != nil {
+
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+
+		srv.ServeConn(serveConn)
+		close(done)
+	}()
+
+	var reply HandshakeReply
+	err = c.rpc.Call("Host.Handshake", HandshakeArgs{
+
+		Version:       ProtocolVersion,
+		Name:          p.Name,
+		PluginVersion: p.Version,
+		Events:        p.events(),
+	}, &reply)
+	if err != nil {
+
+		return
+	}
+	c.Networks = reply.Networks
+
+	p.mu.RLock()
+	for name, cmd := range p.commands {
+
+		var ack bool
+		if err = c.rpc.Call("Host.RegisterCommand", CommandArgs{Name: name, Usage: cmd.usage}, &ack); err != nil {
+
+			p.mu.RUnlock()
+			return fmt.Errorf("command %s: %w", name, err)
+		}
+	}
+	p.mu.RUnlock()
+
+	<-done
+
+	return
+}
+
+// The Plugin service called by the bot
+type service struct {
+	plugin *Plugin
+	client *Client
+}
+
+func (s *service) Event(e Event, ack *bool) error {
+
+	if err := s.plugin.dispatch(s.client, &e); err != nil {
+
+		return err
+	}
+	*ack = true
+
+	return nil
+}
+
+func (s *service) Ping(seq int, reply *int) error {
+
+	*reply = seq
+	return nil
+}
+
+// A Client calls the bot
+type Client struct {
+	Networks []string // Networks of the bot at the handshake
+
+	rpc *rpc.Client
+}
+
+func (c *Client) Close() error {
+
+	return c.rpc.Close()
+}
+
+func (c *Client) send(args SendArgs) error {
+
+	var ack bool
+	return c.rpc.Call("Host.Send", args, &ack)
+}
+
+// Send a message to a channel or nick.
+func (c *Client) Send(network, target, text string) error {
+
+	return c.send(SendArgs{Network: network, Target: target, Text: text})
+}
+
+// Notice sends a notice to a channel or nick.
+func (c *Client) Notice(network, target, text string) error {
+
+	return c.send(SendArgs{Network: network, Target: target, Text: text, Notice: true})
+}
+
+// Action sends a /me action to a channel or nick.
+func (c *Client) Action(network, target, text string) error {
+
+	return c.send(SendArgs{Network: network, Target: target, Text: text, Action: true})
+}
+
+// Reply answers an event, addressed to the nick in channels.
+func (c *Client) Reply(e *Event, text string) error {
+
+	if e.Private() {
+
+		return c.Send(e.Network, e.Nick, text)
+	}
+
+	return c.Send(e.Network, e.Channel, fmt.Sprintf("%s: %s", e.Nick, text))
+}
+
+func (c *Client) Join(network, channel string) error {
+
+	var ack bool
+	return c.rpc.Call("Host.Join", JoinArgs{Network: network, Channel: channel}, &ack)
+}
+
+func (c *Client) Part(network, channel string) error {
+
+	var ack bool
+	return c.rpc.Call("Host.Part", JoinArgs{Network: network, Channel: channel}, &ack)
+}
+
+// Raw sends a line to the server, needs the raw capability.
+func (c *Client) Raw(network, line string) error {
+
+	var ack bool
+	return c.rpc.Call("Host.Raw", RawArgs{Network: network, Line: line}, &ack)
+}
+
+// State returns the channels the bot is in on a network, or the topic and
+// users of a channel, needs the state capability.
+func (c *Client) State(network, channel string) (reply *StateReply, err error) {
+
+	reply = new(StateReply)
+	if err = c.rpc.Call("Host.State", StateArgs{Network: network, Channel: channel}, reply); err != nil {
+
+		return nil, err
+	}
+
+	return
+}
+
+// Fetch gets a URL through the bot, needs the http-fetch capability.
+func (c *Client) Fetch(url string) (reply *FetchReply, err error) {
+
+	return c.Request(FetchArgs{URL: url})
+}
+
+// Request gets a URL through the bot like Fetch, through the proxy of a
+// network or with a user agent.
+func (c *Client) Request(args FetchArgs) (reply *FetchReply, err error) {
+
+	reply = new(FetchReply)
+	if err = c.rpc.Call("Host.Fetch", args, reply); err != nil {
+
+		return nil, err
+	}
+
+	return
+}
+
+// Report tells the bot how a lookup on a network went, for its metrics and
+// the errors of the network, needs the http-fetch capability.
+func (c *Client) Report(args ReportArgs) error {
+
+	var ack bool
+	return c.rpc.Call("Host.Report", args, &ack)
+}
+
+/*
+   Storage, needs the database capability
+*/
+
+// Get returns a stored value and whether it was found.
+func (c *Client) Get(key string) (value []byte, found bool, err error) {
+
+	var reply KVReply
+	if err = c.rpc.Call("Host.KVGet", KVArgs{Key: key}, &reply); err != nil {
+
+		return
+	}
+
+	return reply.Value, reply.Found, nil
+}
+
+func (c *Client) Set(key string, value []byte) error {
+
+	var ack bool
+	return c.rpc.Call("Host.KVSet", KVArgs{Key: key, Value: value}, &ack)
+}
+
+// Delete a value, list or sorted set.
+func (c *Client) Delete(key string) error {
+
+	var ack bool
+	return c.rpc.Call("Host.KVDelete", KVArgs{Key: key}, &ack)
+}
+
+// Keys returns the keys starting with prefix.
+func (c *Client) Keys(prefix string) (reply *KeysReply, err error) {
+
+	reply = new(KeysReply)
+	if err = c.rpc.Call("Host.KVKeys", KeysArgs{Prefix: prefix}, reply); err != nil {
+
+		return nil, err
+	}
+
+	return
+}
+
+// Push values onto the end of a list, or the front, returning its length.
+func (c *Client) Push(key string, front bool, values ...string) (length int, err error) {
+
+	var reply ListReply
+	err = c.rpc.Call("Host.ListPush", ListArgs{Key: key, Values: values, Front: front}, &reply)
+
+	return reply.Length, err
+}
+
+// Pop a value from the end of a list, or the front.
+func (c *Client) Pop(key string, front bool) (value string, found bool, err error) {
+
+	var reply ListReply
+	if err = c.rpc.Call("Host.ListPop", ListArgs{Key: key, Front: front}, &reply); err != nil || !reply.Found {
+
+		return
+	}
+
+	return reply.Values[0], true, nil
+}
+
+// Range returns the values of a list from start to stop, inclusive. Negative
+// indexes count from the end, Range(key, 0, -1) returns the whole list.
+func (c *Client) Range(key string, start, stop int) (values []string, err error) {
+
+	var reply ListReply
+	err = c.rpc.Call("Host.ListRange", ListArgs{Key: key, Start: start, Stop: stop}, &reply)
+
+	return reply.Values, err
+}
+
+// ZAdd sets the score of a member of a sorted set.
+func (c *Client) ZAdd(key, member string, score float64) error {
+
+	var reply ZReply
+	return c.rpc.Call("Host.ZAdd", ZArgs{Key: key, Member: member, Score: score}, &reply)
+}
+
+// ZIncr adds to the score of a member, returning the new score.
+func (c *Client) ZIncr(key, member string, delta float64) (score float64, err error) {
+
+	var reply ZReply
+	err = c.rpc.Call("Host.ZAdd", ZArgs{Key: key, Member: member, Score: delta, Incr: true}, &reply)
+
+	return reply.Score, err
+}
+
+func (c *Client) ZRemove(key, member string) error {
+
+	var ack bool
+	return c.rpc.Call("Host.ZRemove", ZArgs{Key: key, Member: member}, &ack)
+}
+
+// ZScore returns the score of a member and whether it is in the set.
+func (c *Client) ZScore(key, member string) (score float64, found bool, err error) {
+
+	var reply ZReply
+	err = c.rpc.Call("Host.ZScore", ZArgs{Key: key, Member: member}, &reply)
+
+	return reply.Score, reply.Found, err
+}
+
+// ZRange returns members by rank from start to stop, inclusive, lowest
+// scores first or highest with reverse.
+func (c *Client) ZRange(key string, start, stop int, reverse bool) (members []ZMember, err error) {
+
+	var reply ZReply
+	err = c.rpc.Call("Host.ZRange", ZArgs{Key: key, Start: start, Stop: stop, Reverse: reverse}, &reply)
+
+	return reply.Members, err
+}
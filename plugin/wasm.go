@@ -0,0 +1,52 @@
+package plugin
+
+import "encoding/json"
+
+// WebAssembly plugins are modules run inside the bot. They import a single
+// function from the "hackbot" module:
+//
+//	call(method, method_len, args, args_len i32) i64
+//
+// which calls a method of the Host service with the JSON encoding of its
+// arguments, such as "Send" with SendArgs. The WasmReply is written to memory
+// the module allocates and returned as its address in the high 32 bits and
+// its length in the low ones.
+//
+// Modules export their memory and:
+//
+//	alloc(size i32) i32               memory for the bot to write to
+//	init(config, config_len i32) i32  called once with the plugin's Config
+//	event(event, event_len i32) i32   called with the JSON encoding of an Event
+//
+// init and event return 0 on success. "Subscribe" and "RegisterCommand" can
+// only be called from init. Modules may use WASI for their output, which goes
+// to the bot log, but get no files, environment or arguments.
+const (
+	WasmModule = "hackbot"
+	WasmCall   = "call"
+	WasmAlloc  = "alloc"
+	WasmInit   = "init"
+	WasmEvent  = "event"
+)
+
+// Methods only WebAssembly plugins call, the others are those of the Host
+// service
+const (
+	WasmSubscribe = "Subscribe"
+	WasmLog       = "Log"
+)
+
+type SubscribeArgs struct {
+	Event string
+}
+
+type LogArgs struct {
+	Text string
+}
+
+// WasmReply is the result of a call, Result holds the reply of methods
+// having one.
+type WasmReply struct {
+	Error  string          `json:",omitempty"`
+	Result json.RawMessage `json:",omitempty"`
+}
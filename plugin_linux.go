@@ -0,0 +1,51 @@
+//go:build linux
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+// Kill plugins along with the bot and keep them out of its process group
+func setProcAttr(cmd *exec.Cmd) {
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+}
+
+// Set the resource limits of a started plugin with prlimit(2). The process
+// runs unlimited for the moment between starting and this call.
+func applyLimits(pid int, l PluginLimits) (err error) {
+
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+
+		{syscall.RLIMIT_CPU, uint64(l.CPUSeconds)},
+		{syscall.RLIMIT_AS, uint64(l.MemoryMB) << 20},
+		{syscall.RLIMIT_NOFILE, uint64(l.OpenFiles)},
+	}
+	for _, limit := range limits {
+
+		if limit.value == 0 {
+
+			continue
+		}
+
+		rlim := syscall.Rlimit{Cur: limit.value, Max: limit.value}
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(limit.resource),
+			uintptr(unsafe.Pointer(&rlim)), 0, 0, 0)
+		if errno != 0 {
+
+			return errno
+		}
+	}
+
+	return
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Errors
+var (
+	ErrLimitsUnsupported = errors.New("Plugin resource limits are only supported on Linux")
+)
+
+func setProcAttr(cmd *exec.Cmd) {}
+
+func applyLimits(pid int, l PluginLimits) error {
+
+	if l != (PluginLimits{}) {
+
+		return ErrLimitsUnsupported
+	}
+
+	return nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheCreeper/HackBot/plugin"
+)
+
+func TestMatchChannel(t *testing.T) {
+
+	tests := []struct {
+		patterns []string
+		network  string
+		channel  string
+		match    bool
+	}{
+		{nil, "freenode", "#go", true},
+		{nil, "freenode", "", true},
+		{[]string{"#go"}, "freenode", "#go", true},
+		{[]string{"#go"}, "freenode", "#GO", true},
+		{[]string{"#go"}, "freenode", "#rust", false},
+		{[]string{"freenode/#go"}, "freenode", "#go", true},
+		{[]string{"freenode/#go"}, "efnet", "#go", false},
+		{[]string{"#priv-*"}, "freenode", "#priv-ops", true},
+		{[]string{"freenode"}, "freenode", "#any", true},
+		{[]string{"freenode"}, "Freenode", "", true},
+		{[]string{"freenode"}, "efnet", "", false},
+		{[]string{"#go"}, "freenode", "", false},
+	}
+
+	for _, test := range tests {
+
+		if match := matchChannel(test.patterns, test.network, test.channel); match != test.match {
+
+			t.Errorf("matchChannel(%q, %s, %s) = %v, want %v", test.patterns, test.network, test.channel, match, test.match)
+		}
+	}
+}
+
+func TestPublicHost(t *testing.T) {
+
+	tests := []struct {
+		url    string
+		public bool
+	}{
+		{"https://example.com/", true},
+		{"http://93.184.216.34/", true},
+		{"http://[2606:2800:220:1:248:1893:25c8:1946]/", true},
+		{"http://localhost:8080/", false},
+		{"http://LOCALHOST./", false},
+		{"http://admin.localhost/", false},
+		{"http://127.0.0.1/", false},
+		{"http://10.1.2.3/", false},
+		{"http://172.16.0.1/", false},
+		{"http://192.168.1.1/", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://0.0.0.0/", false},
+		{"http://[::1]/", false},
+		{"http://[fe80::1]/", false},
+		{"http://[fd00::1]/", false},
+		{"http://[::ffff:127.0.0.1]/", false},
+	}
+
+	for _, test := range tests {
+
+		u, err := url.Parse(test.url)
+		if err != nil {
+
+			t.Fatal(err)
+		}
+		if err = publicHost(u); (err == nil) != test.public {
+
+			t.Errorf("publicHost(%s) = %v, want public %v", test.url, err, test.public)
+		}
+	}
+}
+
+func TestReadPreamble(t *testing.T) {
+
+	tests := []struct {
+		in    string
+		role  string
+		token string
+		err   error
+	}{
+		{"HACKBOT serve abc\nrest", "serve", "abc", nil},
+		{"HACKBOT call abc \n", "call", "abc", nil},
+		{"HACKBOT serve\n", "", "", plugin.ErrPreamble},
+		{"HELLO serve abc\n", "", "", plugin.ErrPreamble},
+		{"HACKBOT serve abc extra\n", "", "", plugin.ErrPreamble},
+		{strings.Repeat("x", maxPreamble+1) + "\n", "", "", plugin.ErrPreamble},
+	}
+
+	for _, test := range tests {
+
+		client, server := net.Pipe()
+		go func() {
+
+			client.Write([]byte(test.in))
+			client.Close()
+		}()
+
+		role, token, err := readPreamble(server)
+		server.Close()
+		if role != test.role || token != test.token || err != test.err {
+
+			t.Errorf("readPreamble(%.20q) = %q, %q, %v, want %q, %q, %v",
+				test.in, role, token, err, test.role, test.token, test.err)
+		}
+	}
+}
+
+func TestLoopbackAddress(t *testing.T) {
+
+	tests := []struct {
+		address  string
+		loopback bool
+	}{
+		{"127.0.0.1:7000", true},
+		{"[::1]:7000", true},
+		{"localhost:7000", true},
+		{":7000", false},
+		{"0.0.0.0:7000", false},
+		{"192.168.1.10:7000", false},
+		{"bot.example.org:7000", false},
+		{"7000", false},
+	}
+
+	for _, test := range tests {
+
+		if loopback := loopbackAddress(test.address); loopback != test.loopback {
+
+			t.Errorf("loopbackAddress(%q) = %v, want %v", test.address, loopback, test.loopback)
+		}
+	}
+}
+
+func TestRawDenied(t *testing.T) {
+
+	testDB(t)
+
+	manifest := &plugin.Manifest{Capabilities: []string{plugin.CapSend, plugin.CapRaw}}
+	sender := &pluginAPI{plugin: newPlugin(PluginConfig{Name: "sender"}, manifest)}
+	if err := sender.Raw(plugin.RawArgs{Network: "freenode", Line: "PRIVMSG #go :hi"}, new(bool)); !errors.Is(err, plugin.ErrDenied) {
+
+		t.Errorf("Raw() without the raw capability = %v, want %v", err, plugin.ErrDenied)
+	}
+
+	cfg := PluginConfig{Name: "raw", Capabilities: []string{plugin.CapSend, plugin.CapRaw}}
+	raw := &pluginAPI{plugin: newPlugin(cfg, manifest)}
+
+	tests := []struct {
+		line   string
+		denied bool
+	}{
+		{"QUIT :bye", true},
+		{"quit", true},
+		{"  OPER admin secret", true},
+		{":bot QUIT :bye", true},
+		{":bot!bot@host OPER admin secret", true},
+		{"\tQUIT\t:bye", true},
+		{":bot\tNICK\tother", true},
+		{": KILL someone", true},
+		{"PRIVMSG #go :QUIT", false},
+		{":bot PRIVMSG #go :OPER", false},
+		{"MODE #go +o someone", false},
+	}
+
+	for _, test := range tests {
+
+		err := raw.Raw(plugin.RawArgs{Network: "freenode", Line: test.line}, new(bool))
+		if denied := errors.Is(err, ErrDeniedCommand); denied != test.denied {
+
+			t.Errorf("Raw(%q) = %v, denied %v, want %v", test.line, err, denied, test.denied)
+		}
+	}
+}
+
+func TestLaunchStopped(t *testing.T) {
+
+	testDB(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	h := NewPluginHost(l, nil)
+	p := newPlugin(PluginConfig{Name: "sleep", Path: "/bin/sleep", Args: []string{"60"}}, &plugin.Manifest{})
+
+	// Stop ran while the supervisor was restarting the plugin
+	p.stopped = true
+	close(p.stop)
+	if err = h.launch(p); err != nil {
+
+		t.Skip(err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- p.cmd.Wait() }()
+	select {
+
+	case <-exited:
+
+	case <-time.After(5 * time.Second):
+
+		p.cmd.Process.Kill()
+		t.Fatal("process started after Stop was not killed")
+	}
+}
+
+func TestDetachBefore(t *testing.T) {
+
+	testDB(t)
+
+	h := NewPluginHost(nil, nil)
+	p := newPlugin(PluginConfig{Name: "remote", Remote: true}, &plugin.Manifest{})
+
+	// The old connection to call and the new one, accepted after the new
+	// connection to serve numbered 2
+	old, oldPeer := net.Pipe()
+	next, nextPeer := net.Pipe()
+	defer oldPeer.Close()
+	defer next.Close()
+	defer nextPeer.Close()
+	p.conns = []pluginConn{{old, 1}, {next, 3}}
+
+	h.detach(p, 2)
+	if len(p.conns) != 1 || p.conns[0].Conn != next {
+
+		t.Fatalf("detach(p, 2) kept %v, want the connection accepted after", p.conns)
+	}
+	if _, err := old.Write([]byte("x")); err == nil {
+
+		t.Error("old connection was not closed")
+	}
+
+	go nextPeer.Read(make([]byte, 1))
+	if _, err := next.Write([]byte("x")); err != nil {
+
+		t.Errorf("new connection was closed: %v", err)
+	}
+
+	h.detach(p, allConns)
+	if len(p.conns) != 0 {
+
+		t.Errorf("detach(p, allConns) kept %v", p.conns)
+	}
+}
+
+func TestReportDenied(t *testing.T) {
+
+	testDB(t)
+
+	manifest := &plugin.Manifest{Capabilities: []string{plugin.CapSend}}
+	api := &pluginAPI{plugin: newPlugin(PluginConfig{Name: "script"}, manifest)}
+	args := plugin.ReportArgs{Network: "freenode", Kind: plugin.ReportDDG, Error: "made up"}
+	if err := api.Report(args, new(bool)); !errors.Is(err, plugin.ErrDenied) {
+
+		t.Errorf("Report() without http-fetch = %v, want %v", err, plugin.ErrDenied)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sorcix/irc"
+)
+
+// Errors
+var (
+	ErrNoPlugins = errors.New("No plugins or scripts are configured")
+)
+
+type PluginStatus struct {
+	Name         string     `json:"name"`
+	Kind         string     `json:"kind"`
+	Running      bool       `json:"running"`
+	Version      string     `json:"version,omitempty"`
+	Started      *time.Time `json:"started,omitempty"`
+	Restarts     int        `json:"restarts"`
+	Events       []string   `json:"events"`
+	Commands     []string   `json:"commands"`
+	Capabilities []string   `json:"capabilities"`
+	Enabled      []string   `json:"enabled_channels,omitempty"`
+	Disabled     []string   `json:"disabled_channels,omitempty"`
+}
+
+func pluginStatus(p *Plugin) (s PluginStatus) {
+
+	s = PluginStatus{
+
+		Name:         p.Config.Name,
+		Kind:         p.Kind(),
+		Running:      true,
+		Version:      p.Version(),
+		Restarts:     p.Restarts(),
+		Events:       p.Events(),
+		Commands:     p.Commands(),
+		Capabilities: p.Capabilities(),
+	}
+	if t := p.Started(); !t.IsZero() {
+
+		s.Started = &t
+	}
+	s.Enabled, s.Disabled = p.Channels()
+	if s.Events == nil {
+
+		s.Events = []string{}
+	}
+	if s.Commands == nil {
+
+		s.Commands = []string{}
+	}
+	if s.Capabilities == nil {
+
+		s.Capabilities = []string{}
+	}
+
+	return
+}
+
+// Status returns the running plugins along with the configured plugins and
+// scripts that are not running, by name.
+func (h *PluginHost) Status() (status []PluginStatus) {
+
+	seen := make(map[string]bool)
+	for _, p := range h.All() {
+
+		seen[p.Config.Name] = true
+		status = append(status, pluginStatus(p))
+	}
+
+	stopped := func(name string, cfg PluginConfig) {
+
+		if seen[name] {
+
+			return
+		}
+		seen[name] = true
+		status = append(status, PluginStatus{
+
+			Name:         name,
+			Kind:         pluginKind(cfg),
+			Events:       []string{},
+			Commands:     []string{},
+			Capabilities: []string{},
+		})
+	}
+	for _, cfg := range h.Configs {
+
+		stopped(cfg.Name, cfg)
+	}
+	if len(h.Scripts.Dir) > 0 {
+
+		files, _ := ioutil.ReadDir(h.Scripts.Dir)
+		for _, f := range files {
+
+			if f.IsDir() || filepath.Ext(f.Name()) != ".lua" {
+
+				continue
+			}
+			stopped(strings.TrimSuffix(f.Name(), ".lua"), PluginConfig{Path: f.Name()})
+		}
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Name < status[j].Name })
+
+	return
+}
+
+/* IRC */
+
+func registerPluginCommands() {
+
+	Commands.Register(&Command{
+
+		Name:  "plugin",
+		Usage: "!plugin list | info <name> | load|unload|reload <name> | enable|disable <name> [#channel]",
+		Run:   runPlugin,
+	})
+}
+
+func formatPluginStatus(s PluginStatus) string {
+
+	if !s.Running {
+
+		return fmt.Sprintf("%s (%s, stopped)", s.Name, s.Kind)
+	}
+
+	version := s.Version
+	if len(version) < 1 {
+
+		version = "no version"
+	}
+	uptime := "starting"
+	if s.Started != nil {
+
+		uptime = "up " + formatDuration(time.Since(*s.Started))
+	}
+
+	return fmt.Sprintf("%s (%s, %s, %s, %d restarts)", s.Name, s.Kind, version, uptime, s.Restarts)
+}
+
+func runPlugin(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	if !h.IsAdmin(m) {
+
+		return h.Reply(m, "Only admins can manage plugins")
+	}
+	if Plugins == nil {
+
+		return h.Reply(m, ErrNoPlugins.Error())
+	}
+
+	fields := strings.Fields(args)
+	if len(fields) < 1 {
+
+		fields = []string{"list"}
+	}
+	action := strings.ToLower(fields[0])
+	if action == "list" {
+
+		var list []string
+		for _, s := range Plugins.Status() {
+
+			list = append(list, formatPluginStatus(s))
+		}
+		if len(list) < 1 {
+
+			return h.Reply(m, "No plugins are loaded")
+		}
+		return h.Reply(m, "Plugins: "+strings.Join(list, ", "))
+	}
+	if len(fields) < 2 {
+
+		return h.Reply(m, "Usage: !plugin list | info <name> | load|unload|reload <name> | enable|disable <name> [#channel]")
+	}
+	name := fields[1]
+
+	switch action {
+
+	case "info":
+
+		p, ok := Plugins.Plugin(name)
+		if !ok {
+
+			return h.Reply(m, fmt.Sprintf("%s is not running", name))
+		}
+		s := pluginStatus(p)
+		text := fmt.Sprintf("%s: events %s; commands %s; capabilities %s",
+			formatPluginStatus(s),
+			orNone(s.Events), orNone(s.Commands), orNone(s.Capabilities))
+		if len(s.Enabled) > 0 {
+
+			text += "; enabled in " + strings.Join(s.Enabled, ", ")
+		}
+		if len(s.Disabled) > 0 {
+
+			text += "; disabled in " + strings.Join(s.Disabled, ", ")
+		}
+		return h.Reply(m, text)
+
+	case "load", "unload", "reload":
+
+		switch action {
+
+		case "load":
+
+			err = Plugins.Load(name)
+
+		case "unload":
+
+			err = Plugins.Stop(name)
+
+		case "reload":
+
+			err = Plugins.Reload(name)
+		}
+		if err != nil {
+
+			return h.Reply(m, fmt.Sprintf("Can't %s %s: %s", action, name, err))
+		}
+		h.Log.Info("plugin admin", "action", action, "plugin", name, "nick", m.Prefix.Name)
+		return h.Reply(m, fmt.Sprintf("Okay, %sed %s", action, name))
+
+	case "enable", "disable":
+
+		channel := ""
+		if len(fields) > 2 {
+
+			channel = fields[2]
+		} else if len(m.Params) > 0 && isChannel(m.Params[0]) {
+
+			channel = m.Params[0]
+		}
+		if !isChannel(channel) {
+
+			return h.Reply(m, "Which channel? !plugin "+action+" <name> <#channel>")
+		}
+
+		p, ok := Plugins.Plugin(name)
+		if !ok {
+
+			return h.Reply(m, fmt.Sprintf("%s is not running", name))
+		}
+		if err = p.SetEnabled(h.Name, channel, action == "enable"); err != nil {
+
+			return
+		}
+		return h.Reply(m, fmt.Sprintf("Okay, %sd %s in %s", action, name, channel))
+	}
+
+	return h.Reply(m, "Usage: !plugin list | info <name> | load|unload|reload <name> | enable|disable <name> [#channel]")
+}
+
+func orNone(list []string) string {
+
+	if len(list) < 1 {
+
+		return "none"
+	}
+
+	return strings.Join(list, ", ")
+}
+
+/* Admin API */
+
+type pluginRequest struct {
+	Network string `json:"network"`
+	Channel string `json:"channel"`
+}
+
+func (a *AdminServer) handlePlugins(w http.ResponseWriter, r *http.Request) {
+
+	if a.Plugins == nil {
+
+		writeJSON(w, http.StatusOK, []PluginStatus{})
+		return
+	}
+
+	status := a.Plugins.Status()
+	if status == nil {
+
+		status = []PluginStatus{}
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// Routes below /api/plugins/<name>/
+func (a *AdminServer) handlePlugin(w http.ResponseWriter, r *http.Request) {
+
+	if a.Plugins == nil {
+
+		writeError(w, http.StatusNotFound, ErrNoPlugins)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/plugins/"), "/", 2)
+	name := parts[0]
+	action := ""
+	if len(parts) > 1 {
+
+		action = parts[1]
+	}
+
+	if len(action) < 1 {
+
+		p, ok := a.Plugins.Plugin(name)
+		if !ok {
+
+			writeError(w, http.StatusNotFound, ErrNoSuchPlugin)
+			return
+		}
+		writeJSON(w, http.StatusOK, pluginStatus(p))
+		return
+	}
+
+	switch action {
+
+	case "load", "unload", "reload", "enable", "disable":
+
+	default:
+
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllow)
+		return
+	}
+	if err := a.authorized(r); err != nil {
+
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	var req pluginRequest
+	var err error
+	switch action {
+
+	case "load":
+
+		err = a.Plugins.Load(name)
+
+	case "unload":
+
+		err = a.Plugins.Stop(name)
+
+	case "reload":
+
+		err = a.Plugins.Reload(name)
+
+	case "enable", "disable":
+
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if len(req.Network) < 1 || len(req.Channel) < 1 {
+
+			writeError(w, http.StatusBadRequest, ErrMissingParam)
+			return
+		}
+		if _, ok := a.Networks.Get(req.Network); !ok {
+
+			writeError(w, http.StatusNotFound, ErrNoSuchNetwork)
+			return
+		}
+
+		p, ok := a.Plugins.Plugin(name)
+		if !ok {
+
+			err = ErrNoSuchPlugin
+			break
+		}
+		err = p.SetEnabled(req.Network, req.Channel, action == "enable")
+	}
+	switch {
+
+	case errors.Is(err, ErrNoSuchPlugin):
+
+		writeError(w, http.StatusNotFound, err)
+		return
+
+	case err != nil:
+
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	slog.Info("admin plugin action", "plugin", name, "action", action, "network", req.Network, "channel", req.Channel)
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
@@ -0,0 +1,748 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/TheCreeper/HackBot/plugin"
+	"github.com/sorcix/irc"
+)
+
+// Limits of Host.Fetch
+const (
+	fetchTimeout = 10 * time.Second
+	maxFetchSize = 1 << 20
+)
+
+// The Host service, one per plugin connection
+type pluginAPI struct {
+	host   *PluginHost
+	plugin *Plugin
+}
+
+func (a *pluginAPI) observe(method string, start time.Time) {
+
+	pluginRPCDuration.WithLabelValues(a.plugin.Config.Name, method).Observe(time.Since(start).Seconds())
+}
+
+func (a *pluginAPI) handshaked() error {
+
+	a.plugin.mu.Lock()
+	defer a.plugin.mu.Unlock()
+
+	if a.plugin.events == nil {
+
+		return plugin.ErrHandshake
+	}
+
+	return nil
+}
+
+// Fail unless the plugin was granted the capability
+func (a *pluginAPI) require(capability string) error {
+
+	if !a.plugin.caps[capability] {
+
+		return fmt.Errorf("%w: %s", plugin.ErrDenied, capability)
+	}
+
+	return nil
+}
+
+// Network by name, which the plugin has to be enabled on, along with the
+// channel when target is one
+func (a *pluginAPI) target(network, target string) (*Network, error) {
+
+	channel := ""
+	if isChannel(target) {
+
+		channel = target
+	}
+	if !a.plugin.enabled(network, channel) {
+
+		return nil, ErrDisabled
+	}
+
+	return a.network(network)
+}
+
+func (a *pluginAPI) network(name string) (*Network, error) {
+
+	if err := a.handshaked(); err != nil {
+
+		return nil, err
+	}
+	if !a.plugin.enabled(name, "") {
+
+		return nil, ErrDisabled
+	}
+
+	n, ok := a.host.Networks.Get(name)
+	if !ok {
+
+		return nil, ErrNoSuchNetwork
+	}
+
+	return n, nil
+}
+
+func (a *pluginAPI) Handshake(args plugin.HandshakeArgs, reply *plugin.HandshakeReply) error {
+
+	defer a.observe("Host.Handshake", time.Now())
+
+	if args.Version != plugin.ProtocolVersion {
+
+		return fmt.Errorf("%w %d, expected %d", plugin.ErrVersion, args.Version, plugin.ProtocolVersion)
+	}
+
+	p := a.plugin
+	for _, e := range args.Events {
+
+		if !p.Manifest.HasEvent(e) {
+
+			return fmt.Errorf("%w: event %s", ErrUndeclared, e)
+		}
+	}
+	if args.Name != p.Manifest.Name {
+
+		p.log.Warn("plugin name differs from its manifest", "name", args.Name, "manifest", p.Manifest.Name)
+	}
+
+	p.mu.Lock()
+	if p.Config.Remote {
+
+		p.started = time.Now()
+	}
+	p.version = args.PluginVersion
+	p.events = make(map[string]bool)
+	for _, e := range args.Events {
+
+		p.events[e] = true
+	}
+	p.mu.Unlock()
+	p.log.Info("plugin handshake", "name", args.Name, "version", args.PluginVersion, "events", args.Events)
+
+	reply.Version = plugin.ProtocolVersion
+	for _, n := range a.host.Networks.All() {
+
+		reply.Networks = append(reply.Networks, n.Name)
+	}
+
+	return nil
+}
+
+func (a *pluginAPI) Send(args plugin.SendArgs, ack *bool) (err error) {
+
+	defer a.observe("Host.Send", time.Now())
+
+	if err = a.require(plugin.CapSend); err != nil {
+
+		return
+	}
+	n, err := a.target(args.Network, args.Target)
+	if err != nil {
+
+		return
+	}
+	if len(args.Target) < 1 || strings.ContainsAny(args.Target, " ,\x00\r\n") {
+
+		return ErrInvalidTarget
+	}
+	if strings.ContainsAny(args.Text, "\x00\r\n") {
+
+		return ErrInvalidText
+	}
+
+	text := args.Text
+	if args.Action {
+
+		text = "\x01ACTION " + text + "\x01"
+	}
+	if args.Notice {
+
+		err = n.ClientConn.Notice(args.Target, text)
+	} else {
+
+		err = n.ClientConn.PrivMsg(args.Target, text)
+	}
+	*ack = err == nil
+
+	return
+}
+
+func (a *pluginAPI) Join(args plugin.JoinArgs, ack *bool) (err error) {
+
+	defer a.observe("Host.Join", time.Now())
+
+	if err = a.require(plugin.CapJoin); err != nil {
+
+		return
+	}
+	n, err := a.target(args.Network, args.Channel)
+	if err != nil {
+
+		return
+	}
+	if !isChannel(args.Channel) || strings.ContainsAny(args.Channel, " ,\x00\r\n") {
+
+		return ErrNoSuchChannel
+	}
+
+	err = n.ClientConn.Join(args.Channel)
+	*ack = err == nil
+
+	return
+}
+
+func (a *pluginAPI) Part(args plugin.JoinArgs, ack *bool) (err error) {
+
+	defer a.observe("Host.Part", time.Now())
+
+	if err = a.require(plugin.CapJoin); err != nil {
+
+		return
+	}
+	n, err := a.target(args.Network, args.Channel)
+	if err != nil {
+
+		return
+	}
+	if !isChannel(args.Channel) || strings.ContainsAny(args.Channel, " ,\x00\r\n") {
+
+		return ErrNoSuchChannel
+	}
+
+	err = n.ClientConn.Part(args.Channel)
+	*ack = err == nil
+
+	return
+}
+
+// State returns the channels the bot is in on a network, or the topic and users
+// of one of them. Only the channels the plugin is enabled in are seen.
+func (a *pluginAPI) State(args plugin.StateArgs, reply *plugin.StateReply) (err error) {
+
+	defer a.observe("Host.State", time.Now())
+
+	if err = a.require(plugin.CapState); err != nil {
+
+		return
+	}
+
+	if len(args.Channel) < 1 {
+
+		n, err := a.network(args.Network)
+		if err != nil {
+
+			return err
+		}
+		reply.Nick = n.ClientConn.Nick
+		for _, c := range n.State.Channels() {
+
+			if a.plugin.enabled(n.Name, c) {
+
+				reply.Channels = append(reply.Channels, c)
+			}
+		}
+		return nil
+	}
+
+	n, err := a.target(args.Network, args.Channel)
+	if err != nil {
+
+		return
+	}
+	c, ok := n.State.Channel(args.Channel)
+	if !ok {
+
+		return ErrNoSuchChannel
+	}
+	reply.Nick = n.ClientConn.Nick
+	reply.Channels = []string{c.Name}
+	reply.Topic = c.Topic
+	reply.Users = c.Users
+
+	return
+}
+
+// RegisterCommand adds a bot command, run by sending the plugin a command event.
+func (a *pluginAPI) RegisterCommand(args plugin.CommandArgs, ack *bool) (err error) {
+
+	defer a.observe("Host.RegisterCommand", time.Now())
+
+	if err = a.handshaked(); err != nil {
+
+		return
+	}
+
+	name := strings.ToLower(args.Name)
+	if len(name) < 1 || strings.ContainsAny(name, " \r\n") {
+
+		return fmt.Errorf("Invalid command name %q", args.Name)
+	}
+	if !a.plugin.Manifest.HasCommand(name) {
+
+		return fmt.Errorf("%w: command %s", ErrUndeclared, name)
+	}
+	if _, ok := Commands.Get(name); ok {
+
+		return ErrCommandExists
+	}
+
+	p := a.plugin
+	Commands.Register(&Command{
+
+		Name:   name,
+		Usage:  args.Usage,
+		Plugin: p.Config.Name,
+		Run: func(h *HandlerFuncs, m *irc.Message, cmdArgs string) error {
+
+			e, ok := pluginEvent(h.Name, m, h.ClientConn.State.Account(m.Prefix.Name))
+			if !ok || !p.enabled(e.Network, e.Channel) {
+
+				return nil
+			}
+			e.Type = plugin.EventCommand
+			e.Command = name
+			e.Args = cmdArgs
+			p.enqueue(e)
+
+			return nil
+		},
+	})
+
+	p.mu.Lock()
+	p.commands = append(p.commands, name)
+	p.mu.Unlock()
+	*ack = true
+
+	return
+}
+
+// IRC commands plugins can't send even with the raw capability, as they
+// would take over or drop the connection
+var deniedRaw = map[string]bool{
+
+	"QUIT":         true,
+	"OPER":         true,
+	"PASS":         true,
+	"USER":         true,
+	"NICK":         true,
+	"CAP":          true,
+	"AUTHENTICATE": true,
+	"KILL":         true,
+	"SQUIT":        true,
+	"DIE":          true,
+	"RESTART":      true,
+}
+
+// The command of a raw line, upper cased, after the prefix the server would
+// skip
+func rawCommand(line string) string {
+
+	fields := strings.Fields(line)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], ":") {
+
+		fields = fields[1:]
+	}
+	if len(fields) < 1 {
+
+		return ""
+	}
+
+	return strings.ToUpper(fields[0])
+}
+
+// Raw sends a line to the server.
+func (a *pluginAPI) Raw(args plugin.RawArgs, ack *bool) (err error) {
+
+	defer a.observe("Host.Raw", time.Now())
+
+	if err = a.require(plugin.CapRaw); err != nil {
+
+		return
+	}
+	if strings.ContainsAny(args.Line, "\r\n") {
+
+		return ErrInvalidText
+	}
+	if command := rawCommand(args.Line); deniedRaw[command] {
+
+		return fmt.Errorf("%w: %s", ErrDeniedCommand, command)
+	}
+	n, err := a.network(args.Network)
+	if err != nil {
+
+		return
+	}
+
+	err = n.ClientConn.SendRaw(args.Line + "\r\n")
+	*ack = err == nil
+
+	return
+}
+
+// Whether plugins may connect to the address: not loopback, private, link
+// local, multicast or unspecified
+func publicIP(ip net.IP) bool {
+
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// Dialer control refusing connections to addresses that are not public, which
+// sees the resolved address of direct connections
+func publicOnly(network, address string, c syscall.RawConn) error {
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+
+		return fmt.Errorf("%w: %s", ErrLocalAddress, host)
+	}
+
+	return nil
+}
+
+// Check the host of a URL before connecting. Names are resolved by the proxy
+// of a network, so only addresses and localhost can be refused here.
+func publicHost(u *url.URL) error {
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+
+		return fmt.Errorf("%w: %s", ErrLocalAddress, host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !publicIP(ip) {
+
+		return fmt.Errorf("%w: %s", ErrLocalAddress, host)
+	}
+
+	return nil
+}
+
+// Transport of fetches without a network
+var fetchTransport = &http.Transport{
+
+	DialContext: (&net.Dialer{Timeout: fetchTimeout, Control: publicOnly}).DialContext,
+}
+
+// Fetch gets a URL for the plugin, reading at most maxFetchSize of the body,
+// through the proxy of the network when one is given. Local and private
+// addresses are refused, also when redirected to.
+func (a *pluginAPI) Fetch(args plugin.FetchArgs, reply *plugin.FetchReply) (err error) {
+
+	defer a.observe("Host.Fetch", time.Now())
+
+	if err = a.handshaked(); err != nil {
+
+		return
+	}
+	if err = a.require(plugin.CapHTTP); err != nil {
+
+		return
+	}
+
+	u, err := url.Parse(args.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+
+		return fmt.Errorf("Invalid URL %q", args.URL)
+	}
+	if err = publicHost(u); err != nil {
+
+		return
+	}
+
+	client := &http.Client{
+
+		Timeout:   fetchTimeout,
+		Transport: fetchTransport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+
+			if len(via) >= 10 {
+
+				return errors.New("Stopped after 10 redirects")
+			}
+			return publicHost(req.URL)
+		},
+	}
+	if len(args.Network) > 0 {
+
+		n, err := a.network(args.Network)
+		if err != nil {
+
+			return err
+		}
+		if n.Dial != nil {
+
+			client.Transport = n.FetchTransport()
+		}
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+
+		return
+	}
+	if len(args.UserAgent) > 0 {
+
+		req.Header.Set("User-Agent", args.UserAgent)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+
+		return
+	}
+	defer resp.Body.Close()
+
+	reply.Status = resp.StatusCode
+	reply.ContentType = resp.Header.Get("Content-Type")
+	reply.Body, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxFetchSize))
+
+	return
+}
+
+// Report counts a lookup of the plugin in the metrics and adds its error to
+// those of the network.
+func (a *pluginAPI) Report(args plugin.ReportArgs, ack *bool) (err error) {
+
+	defer a.observe("Host.Report", time.Now())
+
+	// Only plugins that fetch have lookups to report
+	if err = a.require(plugin.CapHTTP); err != nil {
+
+		return
+	}
+	n, err := a.network(args.Network)
+	if err != nil {
+
+		return
+	}
+
+	var failed error
+	if len(args.Error) > 0 {
+
+		failed = errors.New(args.Error)
+	}
+	switch args.Kind {
+
+	case plugin.ReportDDG:
+
+		observeDDG(n.Name, args.Duration, failed)
+
+	case plugin.ReportCrawl:
+
+		if args.Status == 0 {
+
+			crawlerRequests.WithLabelValues("error", "").Inc()
+		} else {
+
+			countCrawl(args.Status, args.MIME)
+		}
+
+	case "":
+
+	default:
+
+		return fmt.Errorf("Unknown report kind %q", args.Kind)
+	}
+	if failed != nil {
+
+		a.plugin.log.Warn("plugin lookup failed", "network", n.Name, "kind", args.Kind, "error", args.Error)
+		n.Error(fmt.Errorf("%s: %w", a.plugin.Config.Name, failed))
+	}
+	*ack = true
+
+	return
+}
+
+// Storage needs the database capability
+func (a *pluginAPI) storage() (*pluginStorage, error) {
+
+	if err := a.handshaked(); err != nil {
+
+		return nil, err
+	}
+	if err := a.require(plugin.CapDatabase); err != nil {
+
+		return nil, err
+	}
+
+	return a.plugin.storage, nil
+}
+
+func (a *pluginAPI) KVGet(args plugin.KVArgs, reply *plugin.KVReply) (err error) {
+
+	defer a.observe("Host.KVGet", time.Now())
+
+	s, err := a.storage()
+	if err != nil {
+
+		return
+	}
+	reply.Value, reply.Found, err = s.Get(args.Key)
+
+	return
+}
+
+func (a *pluginAPI) KVSet(args plugin.KVArgs, ack *bool) (err error) {
+
+	defer a.observe("Host.KVSet", time.Now())
+
+	s, err := a.storage()
+	if err != nil {
+
+		return
+	}
+	err = s.Set(args.Key, args.Value)
+	*ack = err == nil
+
+	return
+}
+
+// KVDelete deletes a key of any type.
+func (a *pluginAPI) KVDelete(args plugin.KVArgs, ack *bool) (err error) {
+
+	defer a.observe("Host.KVDelete", time.Now())
+
+	s, err := a.storage()
+	if err != nil {
+
+		return
+	}
+	err = s.Delete(args.Key)
+	*ack = err == nil
+
+	return
+}
+
+func (a *pluginAPI) KVKeys(args plugin.KeysArgs, reply *plugin.KeysReply) (err error) {
+
+	defer a.observe("Host.KVKeys", time.Now())
+
+	s, err := a.storage()
+	if err != nil {
+
+		return
+	}
+	*reply, err = s.Keys(args.Prefix)
+
+	return
+}
+
+func (a *pluginAPI) ListPush(args plugin.ListArgs, reply *plugin.ListReply) (err error) {
+
+	defer a.observe("Host.ListPush", time.Now())
+
+	s, err := a.storage()
+	if err != nil {
+
+		return
+	}
+	reply.Length, err = s.Push(args.Key, args.Values, args.Front)
+
+	return
+}
+
+func (a *pluginAPI) ListPop(args plugin.ListArgs, reply *plugin.ListReply) (err error) {
+
+	defer a.observe("Host.ListPop", time.Now())
+
+	s, err := a.storage()
+	if err != nil {
+
+		return
+	}
+	value, found, err := s.Pop(args.Key, args.Front)
+	if found {
+
+		reply.Values = []string{value}
+		reply.Found = true
+	}
+
+	return
+}
+
+func (a *pluginAPI) ListRange(args plugin.ListArgs, reply *plugin.ListReply) (err error) {
+
+	defer a.observe("Host.ListRange", time.Now())
+
+	s, err := a.storage()
+	if err != nil {
+
+		return
+	}
+	reply.Values, reply.Length, err = s.Range(args.Key, args.Start, args.Stop)
+
+	return
+}
+
+func (a *pluginAPI) ZAdd(args plugin.ZArgs, reply *plugin.ZReply) (err error) {
+
+	defer a.observe("Host.ZAdd", time.Now())
+
+	s, err := a.storage()
+	if err != nil {
+
+		return
+	}
+	reply.Score, err = s.ZAdd(args.Key, args.Member, args.Score, args.Incr)
+	reply.Found = err == nil
+
+	return
+}
+
+func (a *pluginAPI) ZRemove(args plugin.ZArgs, ack *bool) (err error) {
+
+	defer a.observe("Host.ZRemove", time.Now())
+
+	s, err := a.storage()
+	if err != nil {
+
+		return
+	}
+	err = s.ZRemove(args.Key, args.Member)
+	*ack = err == nil
+
+	return
+}
+
+func (a *pluginAPI) ZScore(args plugin.ZArgs, reply *plugin.ZReply) (err error) {
+
+	defer a.observe("Host.ZScore", time.Now())
+
+	s, err := a.storage()
+	if err != nil {
+
+		return
+	}
+	reply.Score, reply.Found, err = s.ZScore(args.Key, args.Member)
+
+	return
+}
+
+func (a *pluginAPI) ZRange(args plugin.ZArgs, reply *plugin.ZReply) (err error) {
+
+	defer a.observe("Host.ZRange", time.Now())
+
+	s, err := a.storage()
+	if err != nil {
+
+		return
+	}
+	reply.Members, err = s.ZRange(args.Key, args.Start, args.Stop, args.Reverse)
+
+	return
+}
@@ -0,0 +1,74 @@
+/*
+   Answers !ddg queries with the DuckDuckGo instant answer API, fetching
+   through the bot so the proxy of the network is used. Queries are reported
+   to the bot for its metrics and failures to the errors of the network.
+*/
+
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/TheCreeper/HackBot/plugin"
+	"github.com/TheCreeper/HackBot/searchquery/ddg"
+)
+
+func main() {
+
+	p := plugin.New("ddg", "1.0")
+
+	p.OnCommand("ddg", "!ddg <query>", func(c *plugin.Client, e *plugin.Event) {
+
+		query := strings.TrimSpace(e.Args)
+		if len(query) < 1 {
+
+			c.Reply(e, "No Query Specified")
+			return
+		}
+
+		// Queries outlive the event call
+		go func() {
+
+			q := &ddg.Client{
+
+				NoHTML: true,
+				Fetch: func(url string) (status int, body []byte, err error) {
+
+					r, err := c.Request(plugin.FetchArgs{URL: url, Network: e.Network})
+					if err != nil {
+
+						return
+					}
+
+					return r.Status, r.Body, nil
+				},
+			}
+			start := time.Now()
+			_, text, err := q.FeelingLucky(query)
+			report := plugin.ReportArgs{
+
+				Network:  e.Network,
+				Kind:     plugin.ReportDDG,
+				Duration: time.Since(start),
+			}
+			if err != nil {
+
+				log.Printf("ddg.FeelingLucky(): %s", err)
+				report.Error = err.Error()
+			}
+			if err = c.Report(report); err != nil {
+
+				log.Printf("plugin.Report(): %s", err)
+			}
+			if len(text) < 1 {
+
+				text = "No Results"
+			}
+			c.Reply(e, text)
+		}()
+	})
+
+	log.Fatal(p.Run())
+}
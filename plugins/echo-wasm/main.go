@@ -0,0 +1,162 @@
+//go:build wasip1
+
+/*
+   Example WebAssembly plugin, repeats text back like the echo plugin with
+   the greeting taken from its configuration.
+
+   Build it with make plugins, which builds the directories ending in -wasm
+   as WebAssembly modules, and add the module to the Plugins section of the
+   configuration:
+
+	{"Name": "echo-wasm", "Path": "bin/plugins/echo-wasm.wasm", "Config": {"Greeting": "Hi"}}
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"unsafe"
+
+	"github.com/TheCreeper/HackBot/plugin"
+)
+
+//go:wasmimport hackbot call
+func hostCall(method, methodLen, args, argsLen uint32) uint64
+
+// Memory handed to the bot, kept until the current call returns
+var buffers [][]byte
+
+//go:wasmexport alloc
+func alloc(size uint32) uint32 {
+
+	b := make([]byte, size+1)
+	buffers = append(buffers, b)
+
+	return uint32(uintptr(unsafe.Pointer(&b[0])))
+}
+
+func bytesAt(ptr, size uint32) []byte {
+
+	return unsafe.Slice((*byte)(unsafe.Pointer(uintptr(ptr))), size)
+}
+
+func pointer(b []byte) uint32 {
+
+	if len(b) < 1 {
+
+		return 0
+	}
+
+	return uint32(uintptr(unsafe.Pointer(&b[0])))
+}
+
+// Call a method of the bot, decoding its result into reply when not nil
+func call(method string, args, reply interface{}) error {
+
+	m := []byte(method)
+	a, err := json.Marshal(args)
+	if err != nil {
+
+		return err
+	}
+
+	r := hostCall(pointer(m), uint32(len(m)), pointer(a), uint32(len(a)))
+	var res plugin.WasmReply
+	if err = json.Unmarshal(bytesAt(uint32(r>>32), uint32(r)), &res); err != nil {
+
+		return err
+	}
+	if len(res.Error) > 0 {
+
+		return errors.New(res.Error)
+	}
+	if reply != nil && len(res.Result) > 0 {
+
+		return json.Unmarshal(res.Result, reply)
+	}
+
+	return nil
+}
+
+var config struct {
+	Greeting string
+}
+
+//go:wasmexport init
+func initPlugin(ptr, size uint32) uint32 {
+
+	defer func() { buffers = nil }()
+
+	if err := json.Unmarshal(bytesAt(ptr, size), &config); err != nil {
+
+		return 1
+	}
+	if len(config.Greeting) < 1 {
+
+		config.Greeting = "Welcome to"
+	}
+
+	if call("RegisterCommand", plugin.CommandArgs{Name: "wecho", Usage: "!wecho <text>"}, nil) != nil {
+
+		return 1
+	}
+	if call(plugin.WasmSubscribe, plugin.SubscribeArgs{Event: plugin.EventJoin}, nil) != nil {
+
+		return 1
+	}
+
+	return 0
+}
+
+func reply(e *plugin.Event, text string) error {
+
+	args := plugin.SendArgs{Network: e.Network, Target: e.Nick, Text: text}
+	if !e.Private() {
+
+		args.Target = e.Channel
+		args.Text = e.Nick + ": " + text
+	}
+
+	return call("Send", args, nil)
+}
+
+//go:wasmexport event
+func event(ptr, size uint32) uint32 {
+
+	defer func() { buffers = nil }()
+
+	var e plugin.Event
+	if err := json.Unmarshal(bytesAt(ptr, size), &e); err != nil {
+
+		return 1
+	}
+
+	var err error
+	switch e.Type {
+
+	case plugin.EventCommand:
+
+		if len(strings.TrimSpace(e.Args)) < 1 {
+
+			err = reply(&e, "Usage: !wecho <text>")
+		} else {
+
+			err = reply(&e, e.Args)
+		}
+
+	case plugin.EventJoin:
+
+		err = call("Send", plugin.SendArgs{Network: e.Network, Target: e.Nick, Text: config.Greeting + " " + e.Channel, Notice: true}, nil)
+	}
+	if err != nil {
+
+		call(plugin.WasmLog, plugin.LogArgs{Text: err.Error()}, nil)
+		return 1
+	}
+
+	return 0
+}
+
+func main() {}
@@ -0,0 +1,38 @@
+/*
+   Example plugin, repeats text back and greets people joining channels.
+
+   Build it with make plugins, which puts the manifest next to the binary,
+   and add it to the Plugins section of the configuration.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/TheCreeper/HackBot/plugin"
+)
+
+func main() {
+
+	p := plugin.New("echo", "1.0")
+
+	p.OnCommand("echo", "!echo <text>", func(c *plugin.Client, e *plugin.Event) {
+
+		if len(strings.TrimSpace(e.Args)) < 1 {
+
+			c.Reply(e, "Usage: !echo <text>")
+			return
+		}
+		c.Reply(e, e.Args)
+	})
+
+	p.OnJoin(func(c *plugin.Client, e *plugin.Event) {
+
+		c.Notice(e.Network, e.Nick, fmt.Sprintf("Welcome to %s", e.Channel))
+	})
+
+	log.Fatal(p.Run())
+}
@@ -0,0 +1,112 @@
+/*
+   Answers messages matching the triggers of response packs, the portal
+   pack shipped with the bot by default. Configured with:
+
+       "Config": {"Packs": ["portal"], "Files": ["my-pack.json"]}
+*/
+
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/TheCreeper/HackBot/plugin"
+	"github.com/TheCreeper/HackBot/responses"
+)
+
+type Config struct {
+	Packs []string // Packs shipped with the bot, ["portal"] when not set
+	Files []string // Pack files
+}
+
+// Load the configured trigger packs
+func newTriggers(cfg Config) (e *responses.Engine, err error) {
+
+	e = responses.NewEngine()
+
+	packs := cfg.Packs
+	if packs == nil {
+
+		packs = []string{"portal"}
+	}
+	for _, name := range packs {
+
+		p, err := responses.LoadPack(name)
+		if err != nil {
+
+			return nil, err
+		}
+		e.Add(p)
+	}
+
+	for _, file := range cfg.Files {
+
+		p, err := responses.LoadFile(file)
+		if err != nil {
+
+			return nil, err
+		}
+		e.Add(p)
+	}
+
+	return
+}
+
+func main() {
+
+	var cfg Config
+	if err := plugin.LoadConfig(&cfg); err != nil {
+
+		log.Fatal(err)
+	}
+	triggers, err := newTriggers(cfg)
+	if err != nil {
+
+		log.Fatal(err)
+	}
+
+	p := plugin.New("triggers", "1.0")
+
+	p.OnMessage(func(c *plugin.Client, e *plugin.Event) {
+
+		val, ok := triggers.Respond(responses.Context{
+
+			Network: e.Network,
+			Channel: e.ReplyTarget(),
+			Nick:    e.Nick,
+			Text:    e.Text,
+
+			// Only responses with $botnick ask the bot for its nick
+			BotNick: func() string {
+
+				s, err := c.State(e.Network, "")
+				if err != nil {
+
+					log.Printf("state: %s", err)
+					return ""
+				}
+				return s.Nick
+			},
+		})
+		if !ok {
+
+			return
+		}
+
+		var err error
+		if strings.HasPrefix(val, "/me ") {
+
+			err = c.Action(e.Network, e.ReplyTarget(), strings.TrimPrefix(val, "/me "))
+		} else {
+
+			err = c.Send(e.Network, e.ReplyTarget(), val)
+		}
+		if err != nil {
+
+			log.Printf("send: %s", err)
+		}
+	})
+
+	log.Fatal(p.Run())
+}
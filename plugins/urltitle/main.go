@@ -0,0 +1,92 @@
+/*
+   Says the title of pages linked in channels. Messages starting with
+   dontcrawl are skipped. Pages are reported to the bot for its metrics and
+   failures to the errors of the network.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/TheCreeper/HackBot/plugin"
+	"github.com/TheCreeper/HackBot/searchquery/crawler"
+)
+
+func main() {
+
+	p := plugin.New("urltitle", "1.0")
+
+	p.OnMessage(func(c *plugin.Client, e *plugin.Event) {
+
+		if len(e.Channel) < 1 || !crawler.IsURL(e.Text) || strings.HasPrefix(e.Text, "dontcrawl") {
+
+			return
+		}
+
+		// Pages load after the event call returns
+		go func() {
+
+			responded := false
+			cr := &crawler.Client{
+
+				OnResponse: func(status int, mime string) {
+
+					responded = true
+					report(c, plugin.ReportArgs{
+
+						Network: e.Network,
+						Kind:    plugin.ReportCrawl,
+						Status:  status,
+						MIME:    mime,
+					})
+				},
+				Fetch: func(url string) (status int, body []byte, err error) {
+
+					r, err := c.Request(plugin.FetchArgs{
+
+						URL:       url,
+						Network:   e.Network,
+						UserAgent: crawler.UserAgent,
+					})
+					if err != nil {
+
+						return
+					}
+
+					return r.Status, r.Body, nil
+				},
+			}
+			r, err := cr.Crawl(crawler.ExtractUrl(e.Text))
+			if err != nil {
+
+				log.Printf("crawler.Crawl(): %s", err)
+
+				// Responses were counted already
+				failed := plugin.ReportArgs{Network: e.Network, Error: err.Error()}
+				if !responded {
+
+					failed.Kind = plugin.ReportCrawl
+				}
+				report(c, failed)
+				return
+			}
+			if len(r.Title) > 1 {
+
+				c.Send(e.Network, e.Channel, fmt.Sprintf("^ %s", r.Title))
+			}
+		}()
+	})
+
+	log.Fatal(p.Run())
+}
+
+func report(c *plugin.Client, args plugin.ReportArgs) {
+
+	if err := c.Report(args); err != nil {
+
+		log.Printf("plugin.Report(): %s", err)
+	}
+}
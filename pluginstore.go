@@ -0,0 +1,656 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/TheCreeper/HackBot/plugin"
+	"github.com/TheCreeper/HackBot/store"
+)
+
+// Errors
+var (
+	ErrQuota        = errors.New("Plugin storage quota exceeded")
+	ErrInvalidKey   = errors.New("Invalid storage key")
+	ErrStorageUsage = errors.New("Usage: hackbot plugins <export|import> <plugin> [file]")
+)
+
+// Quotas of plugin storage
+const (
+	defaultStorageKB   = 1024
+	defaultStorageKeys = 10000
+	maxStorageKey      = 250
+)
+
+// Values, lists and sorted sets have keys of their own, stored with a prefix
+const (
+	kvValue     = "v/"
+	kvList      = "l/"
+	kvSortedSet = "z/"
+)
+
+// The storage of a plugin, the namespace "plugin:<name>" of the kv table.
+// Lists and sorted sets are kept as JSON in a single value.
+type pluginStorage struct {
+	namespace string
+	maxSize   int64
+	maxKeys   int
+
+	mu sync.Mutex // Serialises changes
+}
+
+func newPluginStorage(cfg PluginConfig) *pluginStorage {
+
+	s := &pluginStorage{
+
+		namespace: "plugin:" + cfg.Name,
+		maxSize:   defaultStorageKB * 1024,
+		maxKeys:   defaultStorageKeys,
+	}
+	if cfg.StorageKB > 0 {
+
+		s.maxSize = int64(cfg.StorageKB) * 1024
+	}
+	if cfg.StorageKeys > 0 {
+
+		s.maxKeys = cfg.StorageKeys
+	}
+
+	return s
+}
+
+func checkKey(key string) error {
+
+	if len(key) < 1 || len(key) > maxStorageKey {
+
+		return ErrInvalidKey
+	}
+
+	return nil
+}
+
+func (s *pluginStorage) get(key string) (value []byte, ok bool, err error) {
+
+	value, err = DB.Get(s.namespace, key)
+	if err == store.ErrNotFound {
+
+		return nil, false, nil
+	}
+
+	return value, err == nil, err
+}
+
+// Write a key unless the namespace would go over its quota
+func (s *pluginStorage) put(key string, value []byte) (err error) {
+
+	keys, size, err := DB.NamespaceSize(s.namespace)
+	if err != nil {
+
+		return
+	}
+	old, ok, err := s.get(key)
+	if err != nil {
+
+		return
+	}
+	if ok {
+
+		keys--
+		size -= int64(len(key) + len(old))
+	}
+	if keys+1 > s.maxKeys || size+int64(len(key)+len(value)) > s.maxSize {
+
+		return ErrQuota
+	}
+
+	// Empty values arrive as nil over RPC
+	if value == nil {
+
+		value = []byte{}
+	}
+
+	return DB.Set(s.namespace, key, value)
+}
+
+func (s *pluginStorage) Get(key string) (value []byte, ok bool, err error) {
+
+	if err = checkKey(key); err != nil {
+
+		return
+	}
+
+	return s.get(kvValue + key)
+}
+
+func (s *pluginStorage) Set(key string, value []byte) error {
+
+	if err := checkKey(key); err != nil {
+
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(kvValue+key, value)
+}
+
+// Delete a key, whether a value, a list or a sorted set
+func (s *pluginStorage) Delete(key string) (err error) {
+
+	if err = checkKey(key); err != nil {
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, prefix := range []string{kvValue, kvList, kvSortedSet} {
+
+		if err = DB.Delete(s.namespace, prefix+key); err != nil {
+
+			return
+		}
+	}
+
+	return
+}
+
+// Keys starting with prefix, by type
+func (s *pluginStorage) Keys(prefix string) (reply plugin.KeysReply, err error) {
+
+	keys, err := DB.Keys(s.namespace)
+	if err != nil {
+
+		return
+	}
+
+	for _, k := range keys {
+
+		if len(k) < 2 || !strings.HasPrefix(k[2:], prefix) {
+
+			continue
+		}
+		switch k[:2] {
+
+		case kvValue:
+
+			reply.Values = append(reply.Values, k[2:])
+
+		case kvList:
+
+			reply.Lists = append(reply.Lists, k[2:])
+
+		case kvSortedSet:
+
+			reply.SortedSets = append(reply.SortedSets, k[2:])
+		}
+	}
+
+	return
+}
+
+func (s *pluginStorage) list(key string) (list []string, err error) {
+
+	b, ok, err := s.get(kvList + key)
+	if err != nil || !ok {
+
+		return
+	}
+	err = json.Unmarshal(b, &list)
+
+	return
+}
+
+func (s *pluginStorage) putList(key string, list []string) error {
+
+	if len(list) < 1 {
+
+		return DB.Delete(s.namespace, kvList+key)
+	}
+
+	b, err := json.Marshal(list)
+	if err != nil {
+
+		return err
+	}
+
+	return s.put(kvList+key, b)
+}
+
+// Push values onto the end of a list, or the front, returning its length
+func (s *pluginStorage) Push(key string, values []string, front bool) (length int, err error) {
+
+	if err = checkKey(key); err != nil {
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, err := s.list(key)
+	if err != nil {
+
+		return
+	}
+	if front {
+
+		list = append(append([]string(nil), values...), list...)
+	} else {
+
+		list = append(list, values...)
+	}
+
+	return len(list), s.putList(key, list)
+}
+
+// Pop a value from the end of a list, or the front
+func (s *pluginStorage) Pop(key string, front bool) (value string, ok bool, err error) {
+
+	if err = checkKey(key); err != nil {
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, err := s.list(key)
+	if err != nil || len(list) < 1 {
+
+		return
+	}
+	if front {
+
+		value, list = list[0], list[1:]
+	} else {
+
+		value, list = list[len(list)-1], list[:len(list)-1]
+	}
+
+	return value, true, s.putList(key, list)
+}
+
+// Indexes from start to stop, inclusive, negative ones counting from the end
+func rangeIndexes(start, stop, length int) (int, int) {
+
+	if start < 0 {
+
+		start += length
+	}
+	if stop < 0 {
+
+		stop += length
+	}
+	if start < 0 {
+
+		start = 0
+	}
+	if stop >= length {
+
+		stop = length - 1
+	}
+	if start > stop {
+
+		return 0, 0
+	}
+
+	return start, stop + 1
+}
+
+// Range returns the values of a list from start to stop, inclusive, and its
+// length.
+func (s *pluginStorage) Range(key string, start, stop int) (values []string, length int, err error) {
+
+	if err = checkKey(key); err != nil {
+
+		return
+	}
+
+	list, err := s.list(key)
+	if err != nil {
+
+		return
+	}
+	i, j := rangeIndexes(start, stop, len(list))
+
+	return list[i:j], len(list), nil
+}
+
+func (s *pluginStorage) sortedSet(key string) (set map[string]float64, err error) {
+
+	set = make(map[string]float64)
+	b, ok, err := s.get(kvSortedSet + key)
+	if err != nil || !ok {
+
+		return
+	}
+	err = json.Unmarshal(b, &set)
+
+	return
+}
+
+func (s *pluginStorage) putSortedSet(key string, set map[string]float64) error {
+
+	if len(set) < 1 {
+
+		return DB.Delete(s.namespace, kvSortedSet+key)
+	}
+
+	b, err := json.Marshal(set)
+	if err != nil {
+
+		return err
+	}
+
+	return s.put(kvSortedSet+key, b)
+}
+
+// ZAdd sets the score of a member, or adds to it with incr, returning the
+// new score.
+func (s *pluginStorage) ZAdd(key, member string, score float64, incr bool) (float64, error) {
+
+	if err := checkKey(key); err != nil {
+
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set, err := s.sortedSet(key)
+	if err != nil {
+
+		return 0, err
+	}
+	if incr {
+
+		score += set[member]
+	}
+	set[member] = score
+
+	return score, s.putSortedSet(key, set)
+}
+
+func (s *pluginStorage) ZRemove(key, member string) error {
+
+	if err := checkKey(key); err != nil {
+
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set, err := s.sortedSet(key)
+	if err != nil {
+
+		return err
+	}
+	if _, ok := set[member]; !ok {
+
+		return nil
+	}
+	delete(set, member)
+
+	return s.putSortedSet(key, set)
+}
+
+func (s *pluginStorage) ZScore(key, member string) (score float64, ok bool, err error) {
+
+	if err = checkKey(key); err != nil {
+
+		return
+	}
+
+	set, err := s.sortedSet(key)
+	if err != nil {
+
+		return
+	}
+	score, ok = set[member]
+
+	return
+}
+
+// ZRange returns the members by rank from start to stop, inclusive, lowest
+// scores first or highest with reverse.
+func (s *pluginStorage) ZRange(key string, start, stop int, reverse bool) (members []plugin.ZMember, err error) {
+
+	if err = checkKey(key); err != nil {
+
+		return
+	}
+
+	set, err := s.sortedSet(key)
+	if err != nil {
+
+		return
+	}
+
+	for m, score := range set {
+
+		members = append(members, plugin.ZMember{Member: m, Score: score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+
+		a, b := members[i], members[j]
+		if reverse {
+
+			a, b = b, a
+		}
+		if a.Score != b.Score {
+
+			return a.Score < b.Score
+		}
+		return a.Member < b.Member
+	})
+	i, j := rangeIndexes(start, stop, len(members))
+
+	return members[i:j], nil
+}
+
+// The export format of plugin storage
+type pluginData struct {
+	Values     map[string][]byte             `json:"values"`
+	Lists      map[string][]string           `json:"lists"`
+	SortedSets map[string]map[string]float64 `json:"sorted_sets"`
+}
+
+func (s *pluginStorage) Export() (data pluginData, err error) {
+
+	entries, err := DB.Entries(s.namespace)
+	if err != nil {
+
+		return
+	}
+
+	data = pluginData{
+
+		Values:     make(map[string][]byte),
+		Lists:      make(map[string][]string),
+		SortedSets: make(map[string]map[string]float64),
+	}
+	for _, e := range entries {
+
+		if len(e.Key) < 2 {
+
+			continue
+		}
+		key := e.Key[2:]
+		switch e.Key[:2] {
+
+		case kvValue:
+
+			data.Values[key] = e.Value
+
+		case kvList:
+
+			var list []string
+			if err = json.Unmarshal(e.Value, &list); err != nil {
+
+				return
+			}
+			data.Lists[key] = list
+
+		case kvSortedSet:
+
+			set := make(map[string]float64)
+			if err = json.Unmarshal(e.Value, &set); err != nil {
+
+				return
+			}
+			data.SortedSets[key] = set
+		}
+	}
+
+	return
+}
+
+// Import replaces everything stored for the plugin, within its quota.
+func (s *pluginStorage) Import(data pluginData) (err error) {
+
+	var entries []store.Entry
+	add := func(key string, value []byte) error {
+
+		if err := checkKey(key[2:]); err != nil {
+
+			return fmt.Errorf("%w: %q", err, key[2:])
+		}
+		if value == nil {
+
+			value = []byte{}
+		}
+		entries = append(entries, store.Entry{Key: key, Value: value})
+		return nil
+	}
+
+	for k, v := range data.Values {
+
+		if err = add(kvValue+k, v); err != nil {
+
+			return
+		}
+	}
+	for k, list := range data.Lists {
+
+		b, err := json.Marshal(list)
+		if err != nil {
+
+			return err
+		}
+		if err = add(kvList+k, b); err != nil {
+
+			return err
+		}
+	}
+	for k, set := range data.SortedSets {
+
+		b, err := json.Marshal(set)
+		if err != nil {
+
+			return err
+		}
+		if err = add(kvSortedSet+k, b); err != nil {
+
+			return err
+		}
+	}
+
+	var size int64
+	for _, e := range entries {
+
+		size += int64(len(e.Key) + len(e.Value))
+	}
+	if len(entries) > s.maxKeys || size > s.maxSize {
+
+		return ErrQuota
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return DB.ReplaceEntries(s.namespace, entries)
+}
+
+// The plugins subcommand exports the storage of a plugin as JSON, or imports
+// it replacing what is stored:
+//
+//	hackbot -config config.json plugins export weather weather.json
+//	hackbot -config config.json plugins import weather weather.json
+func runPluginStorage(cfg *ClientConfig, w io.Writer, r io.Reader, args []string) (err error) {
+
+	if len(args) < 2 || len(args) > 3 {
+
+		return ErrStorageUsage
+	}
+
+	pc := PluginConfig{Name: args[1]}
+	for _, p := range cfg.Plugins {
+
+		if p.Name == args[1] {
+
+			pc = p
+		}
+	}
+	s := newPluginStorage(pc)
+
+	switch args[0] {
+
+	case "export":
+
+		data, err := s.Export()
+		if err != nil {
+
+			return err
+		}
+		if len(args) == 3 {
+
+			f, err := os.Create(args[2])
+			if err != nil {
+
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(data)
+
+	case "import":
+
+		if len(args) == 3 {
+
+			f, err := os.Open(args[2])
+			if err != nil {
+
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+
+			return err
+		}
+		var data pluginData
+		if err = json.Unmarshal(b, &data); err != nil {
+
+			return err
+		}
+		return s.Import(data)
+	}
+
+	return ErrStorageUsage
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/TheCreeper/HackBot/plugin"
+	"github.com/TheCreeper/HackBot/store"
+)
+
+// Use a new SQLite database as the bot database for the test
+func testDB(t *testing.T) {
+
+	s, err := store.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if err = s.Migrate(); err != nil {
+
+		t.Fatal(err)
+	}
+
+	old := DB
+	DB = s
+	t.Cleanup(func() {
+
+		DB = old
+		s.Close()
+	})
+}
+
+func TestRangeIndexes(t *testing.T) {
+
+	tests := []struct {
+		start, stop, length int
+		from, to            int
+	}{
+		{0, -1, 5, 0, 5},
+		{0, 0, 5, 0, 1},
+		{1, 3, 5, 1, 4},
+		{-2, -1, 5, 3, 5},
+		{0, 100, 5, 0, 5},
+		{-100, 1, 5, 0, 2},
+		{3, 1, 5, 0, 0},
+		{5, 10, 5, 0, 0},
+		{0, -1, 0, 0, 0},
+		{-1, -1, 1, 0, 1},
+	}
+
+	for _, test := range tests {
+
+		from, to := rangeIndexes(test.start, test.stop, test.length)
+		if from != test.from || to != test.to {
+
+			t.Errorf("rangeIndexes(%d, %d, %d) = %d, %d, want %d, %d",
+				test.start, test.stop, test.length, from, to, test.from, test.to)
+		}
+	}
+}
+
+func TestStorageQuota(t *testing.T) {
+
+	testDB(t)
+
+	// Keys are stored with a two byte prefix, "v/a" and a value of 7 bytes
+	// take 10
+	s := newPluginStorage(PluginConfig{Name: "quota", StorageKeys: 2})
+	s.maxSize = 20
+
+	steps := []struct {
+		key   string
+		value string
+		err   error
+	}{
+		{"a", "1234567", nil},
+		{"a", "7654321", nil},       // Overwriting frees the old value
+		{"b", "12345678", ErrQuota}, // 21 bytes
+		{"b", "1234567", nil},
+		{"a", "12345678", ErrQuota},
+		{"c", "", ErrQuota}, // Third key
+		{"", "x", ErrInvalidKey},
+	}
+
+	for _, step := range steps {
+
+		if err := s.Set(step.key, []byte(step.value)); !errors.Is(err, step.err) {
+
+			t.Errorf("Set(%q, %q) = %v, want %v", step.key, step.value, err, step.err)
+		}
+	}
+
+	value, ok, err := s.Get("a")
+	if err != nil || !ok || string(value) != "7654321" {
+
+		t.Errorf("Get(a) = %q, %v, %v, want the value before the refused write", value, ok, err)
+	}
+
+	if err = s.Delete("b"); err != nil {
+
+		t.Fatal(err)
+	}
+	if err = s.Set("c", nil); err != nil {
+
+		t.Errorf("Set(c) after deleting b = %v", err)
+	}
+}
+
+func TestStorageLists(t *testing.T) {
+
+	testDB(t)
+
+	s := newPluginStorage(PluginConfig{Name: "lists"})
+	if n, err := s.Push("l", []string{"b", "c"}, false); err != nil || n != 2 {
+
+		t.Fatalf("Push(b c) = %d, %v", n, err)
+	}
+	if n, err := s.Push("l", []string{"a"}, true); err != nil || n != 3 {
+
+		t.Fatalf("Push(a, front) = %d, %v", n, err)
+	}
+
+	values, length, err := s.Range("l", 0, -1)
+	if err != nil || length != 3 || !reflect.DeepEqual(values, []string{"a", "b", "c"}) {
+
+		t.Errorf("Range(0, -1) = %q, %d, %v", values, length, err)
+	}
+
+	pops := []struct {
+		front bool
+		value string
+		ok    bool
+	}{
+		{true, "a", true},
+		{false, "c", true},
+		{false, "b", true},
+		{false, "", false},
+	}
+	for _, pop := range pops {
+
+		value, ok, err := s.Pop("l", pop.front)
+		if err != nil || value != pop.value || ok != pop.ok {
+
+			t.Errorf("Pop(front %v) = %q, %v, %v, want %q, %v", pop.front, value, ok, err, pop.value, pop.ok)
+		}
+	}
+
+	// Empty lists are removed
+	keys, err := s.Keys("")
+	if err != nil || len(keys.Lists) != 0 {
+
+		t.Errorf("Keys() after popping everything = %v, %v", keys, err)
+	}
+}
+
+func TestStorageSortedSets(t *testing.T) {
+
+	testDB(t)
+
+	s := newPluginStorage(PluginConfig{Name: "sets"})
+	adds := []struct {
+		member string
+		score  float64
+		incr   bool
+		want   float64
+	}{
+		{"bob", 3, false, 3},
+		{"alice", 5, false, 5},
+		{"carol", 1, false, 1},
+		{"bob", 4, true, 7},
+	}
+	for _, add := range adds {
+
+		if score, err := s.ZAdd("z", add.member, add.score, add.incr); err != nil || score != add.want {
+
+			t.Errorf("ZAdd(%s, %v, incr %v) = %v, %v, want %v", add.member, add.score, add.incr, score, err, add.want)
+		}
+	}
+
+	members, err := s.ZRange("z", 0, 1, true)
+	want := []plugin.ZMember{{Member: "bob", Score: 7}, {Member: "alice", Score: 5}}
+	if err != nil || !reflect.DeepEqual(members, want) {
+
+		t.Errorf("ZRange(0, 1, reverse) = %v, %v, want %v", members, err, want)
+	}
+
+	if err = s.ZRemove("z", "bob"); err != nil {
+
+		t.Fatal(err)
+	}
+	if _, ok, err := s.ZScore("z", "bob"); err != nil || ok {
+
+		t.Errorf("ZScore(bob) after ZRemove = %v, %v", ok, err)
+	}
+	members, err = s.ZRange("z", 0, -1, false)
+	want = []plugin.ZMember{{Member: "carol", Score: 1}, {Member: "alice", Score: 5}}
+	if err != nil || !reflect.DeepEqual(members, want) {
+
+		t.Errorf("ZRange(0, -1) = %v, %v, want %v", members, err, want)
+	}
+}
+
+func TestStorageExportImport(t *testing.T) {
+
+	testDB(t)
+
+	s := newPluginStorage(PluginConfig{Name: "export"})
+	if err := s.Set("greeting", []byte("hi")); err != nil {
+
+		t.Fatal(err)
+	}
+	if _, err := s.Push("queue", []string{"a", "b"}, false); err != nil {
+
+		t.Fatal(err)
+	}
+	if _, err := s.ZAdd("scores", "bob", 2, false); err != nil {
+
+		t.Fatal(err)
+	}
+
+	data, err := s.Export()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	want := pluginData{
+
+		Values:     map[string][]byte{"greeting": []byte("hi")},
+		Lists:      map[string][]string{"queue": {"a", "b"}},
+		SortedSets: map[string]map[string]float64{"scores": {"bob": 2}},
+	}
+	if !reflect.DeepEqual(data, want) {
+
+		t.Fatalf("Export() = %+v, want %+v", data, want)
+	}
+
+	// Importing into another plugin gives the same data
+	other := newPluginStorage(PluginConfig{Name: "other"})
+	if err = other.Set("stale", []byte("x")); err != nil {
+
+		t.Fatal(err)
+	}
+	if err = other.Import(data); err != nil {
+
+		t.Fatal(err)
+	}
+	if got, err := other.Export(); err != nil || !reflect.DeepEqual(got, want) {
+
+		t.Errorf("Export() after Import() = %+v, %v, want %+v", got, err, want)
+	}
+
+	// Imports over the quota or with bad keys change nothing
+	small := newPluginStorage(PluginConfig{Name: "other", StorageKeys: 2})
+	if err = small.Import(data); !errors.Is(err, ErrQuota) {
+
+		t.Errorf("Import() of 3 keys with a quota of 2 = %v, want %v", err, ErrQuota)
+	}
+	bad := pluginData{Values: map[string][]byte{"": []byte("x")}}
+	if err = other.Import(bad); !errors.Is(err, ErrInvalidKey) {
+
+		t.Errorf("Import() of an empty key = %v, want %v", err, ErrInvalidKey)
+	}
+	if got, err := other.Export(); err != nil || !reflect.DeepEqual(got, want) {
+
+		t.Errorf("Export() after refused imports = %+v, %v, want %+v", got, err, want)
+	}
+}
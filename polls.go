@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheCreeper/HackBot/store"
+	"github.com/sorcix/irc"
+)
+
+// Limits of polls
+const (
+	defaultPollDuration = 10 * time.Minute
+	maxPollDuration     = 7 * 24 * time.Hour
+	maxPollOptions      = 9
+)
+
+func registerPollCommands() {
+
+	Commands.Register(&Command{
+
+		Name:  "poll",
+		Usage: "!poll [duration] \"question\" <option> <option> ... | !poll | !poll end",
+		Run:   runPoll,
+	})
+	Commands.Register(&Command{
+
+		Name:  "vote",
+		Usage: "!vote <n>",
+		Run:   runVote,
+	})
+}
+
+// Polls run in the channel they are started in
+func pollChannel(h *HandlerFuncs, m *irc.Message) (channel string, ok bool) {
+
+	if len(m.Params) < 1 || !isChannel(m.Params[0]) {
+
+		h.Reply(m, "Polls run in channels, ask in the channel itself")
+		return
+	}
+
+	return m.Params[0], true
+}
+
+func formatPoll(p *store.Poll) string {
+
+	var options []string
+	for i, o := range p.Options {
+
+		options = append(options, fmt.Sprintf("%d) %s", i+1, o))
+	}
+
+	return fmt.Sprintf("Poll #%d: %s %s", p.ID, p.Question, strings.Join(options, " "))
+}
+
+// Results of a poll, options with the most votes first
+func formatResults(p *store.Poll, counts []int) string {
+
+	total := 0
+	best := 0
+	for _, n := range counts {
+
+		total += n
+		if n > best {
+
+			best = n
+		}
+	}
+	if total == 0 {
+
+		return fmt.Sprintf("Poll #%d closed: %s, nobody voted", p.ID, p.Question)
+	}
+
+	var results, winners []string
+	for i, o := range p.Options {
+
+		results = append(results, fmt.Sprintf("%s: %d", o, counts[i]))
+		if counts[i] == best {
+
+			winners = append(winners, o)
+		}
+	}
+
+	outcome := "winner " + winners[0]
+	if len(winners) > 1 {
+
+		outcome = "tie between " + strings.Join(winners, ", ")
+	}
+
+	return fmt.Sprintf("Poll #%d closed: %s %s (%d votes, %s)", p.ID, p.Question, strings.Join(results, ", "), total, outcome)
+}
+
+// The open poll of the channel, nil when there is none
+func openPoll(network, channel string) (p *store.Poll, err error) {
+
+	p, err = DB.OpenPoll(network, channel)
+	if err == store.ErrNotFound {
+
+		return nil, nil
+	}
+
+	return
+}
+
+// Polls are closed one at a time, so a poll ended by hand as it runs out is
+// announced once
+var closingPolls sync.Mutex
+
+// Announce the results of a poll and close it. The poll stays open when the
+// results can't be sent, for the scheduler to try again.
+func closePoll(send func(target, text string) error, p *store.Poll) (err error) {
+
+	closingPolls.Lock()
+	defer closingPolls.Unlock()
+
+	open, err := openPoll(p.Network, p.Channel)
+	if err != nil || open == nil || open.ID != p.ID {
+
+		return
+	}
+	counts, err := DB.PollResults(p)
+	if err != nil {
+
+		return
+	}
+	if err = send(p.Channel, formatResults(p, counts)); err != nil {
+
+		return
+	}
+
+	_, err = DB.ClosePoll(p.ID)
+	return
+}
+
+func runPoll(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	usage := "Usage: !poll [duration] \"question\" <option> <option> ..."
+
+	channel, ok := pollChannel(h, m)
+	if !ok {
+
+		return
+	}
+
+	current, err := openPoll(h.Name, channel)
+	if err != nil {
+
+		return
+	}
+
+	argv := splitArgs(args)
+	switch {
+
+	case len(argv) == 0:
+
+		if current == nil {
+
+			return h.Reply(m, "No poll is running, "+usage)
+		}
+		return h.ClientConn.PrivMsg(channel, fmt.Sprintf("%s (closes in %s)",
+			formatPoll(current), formatDuration(time.Until(current.Closes))))
+
+	case len(argv) == 1 && argv[0] == "end":
+
+		if current == nil {
+
+			return h.Reply(m, "No poll is running")
+		}
+		if !strings.EqualFold(current.Creator, m.Prefix.Name) && !h.IsAdmin(m) {
+
+			return h.Reply(m, "Only the creator of the poll or an admin can end it")
+		}
+		return closePoll(h.ClientConn.PrivMsg, current)
+	}
+
+	if current != nil {
+
+		return h.Reply(m, fmt.Sprintf("Poll #%d is still running, wait for it to close", current.ID))
+	}
+
+	duration := defaultPollDuration
+	if d, err := parseDuration(argv[0]); err == nil {
+
+		if d <= 0 || d > maxPollDuration {
+
+			return h.Reply(m, fmt.Sprintf("Polls run for up to %s", formatDuration(maxPollDuration)))
+		}
+		duration = d
+		argv = argv[1:]
+	}
+	if len(argv) < 3 {
+
+		return h.Reply(m, usage)
+	}
+	if len(argv)-1 > maxPollOptions {
+
+		return h.Reply(m, fmt.Sprintf("Polls have up to %d options", maxPollOptions))
+	}
+
+	now := time.Now()
+	p := &store.Poll{
+
+		Network:  h.Name,
+		Channel:  channel,
+		Question: argv[0],
+		Options:  argv[1:],
+		Creator:  m.Prefix.Name,
+		Created:  now,
+		Closes:   now.Add(duration),
+	}
+	if err = DB.AddPoll(p); err != nil {
+
+		return
+	}
+
+	return h.ClientConn.PrivMsg(channel, fmt.Sprintf("%s (vote with !vote <n>, closes in %s)", formatPoll(p), formatDuration(duration)))
+}
+
+// One vote per services account, or per user@host when not logged in
+func runVote(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	channel, ok := pollChannel(h, m)
+	if !ok {
+
+		return
+	}
+
+	p, err := openPoll(h.Name, channel)
+	if err != nil {
+
+		return
+	}
+	if p == nil || time.Now().After(p.Closes) {
+
+		return h.Reply(m, "No poll is running")
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(args))
+	if err != nil || n < 1 || n > len(p.Options) {
+
+		return h.Reply(m, fmt.Sprintf("Usage: !vote <1-%d>", len(p.Options)))
+	}
+
+	voter := "$a:" + h.ClientConn.State.Account(m.Prefix.Name)
+	if voter == "$a:" {
+
+		voter = m.Prefix.User + "@" + m.Prefix.Host
+	}
+	if err = DB.Vote(p.ID, voter, n-1); err != nil {
+
+		return
+	}
+
+	return h.ClientConn.Notice(m.Prefix.Name, fmt.Sprintf("Your vote for %s in poll #%d is counted", p.Options[n-1], p.ID))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TheCreeper/HackBot/store"
+)
+
+func TestClosePoll(t *testing.T) {
+
+	testDB(t)
+
+	p := &store.Poll{
+
+		Network:  "freenode",
+		Channel:  "#go",
+		Question: "Tabs?",
+		Options:  []string{"yes", "no"},
+		Creator:  "bob",
+		Created:  time.Now(),
+		Closes:   time.Now(),
+	}
+	if err := DB.AddPoll(p); err != nil {
+
+		t.Fatal(err)
+	}
+
+	// The poll stays open while the results can't be sent
+	failed := errors.New("not connected")
+	if err := closePoll(func(target, text string) error { return failed }, p); err != failed {
+
+		t.Fatalf("closePoll() = %v, want %v", err, failed)
+	}
+	if open, err := openPoll(p.Network, p.Channel); err != nil || open == nil {
+
+		t.Fatalf("openPoll() after a failed send = %v, %v, want the poll", open, err)
+	}
+
+	// Results are announced once
+	sent := 0
+	send := func(target, text string) error {
+
+		sent++
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+
+		if err := closePoll(send, p); err != nil {
+
+			t.Fatal(err)
+		}
+	}
+	if sent != 1 {
+
+		t.Errorf("results sent %d times, want once", sent)
+	}
+	if open, err := openPoll(p.Network, p.Channel); err != nil || open != nil {
+
+		t.Errorf("openPoll() after closing = %v, %v, want none", open, err)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/TheCreeper/HackBot/store"
+	"github.com/sorcix/irc"
+)
+
+// Errors
+var (
+	ErrQuotesUsage = errors.New("Usage: hackbot quotes export <network> <channel> [file]")
+)
+
+// Number of matches returned by !searchquote
+const quoteResults = 3
+
+// !grab looks lines up in the history, when it is indexed
+func registerQuoteCommands(history bool) {
+
+	Commands.Register(&Command{
+
+		Name:  "addquote",
+		Usage: "!addquote <text>",
+		Run:   runAddQuote,
+	})
+	if history {
+
+		Commands.Register(&Command{
+
+			Name:  "grab",
+			Usage: "!grab <nick>",
+			Run:   runGrab,
+		})
+	}
+	Commands.Register(&Command{
+
+		Name:  "quote",
+		Usage: "!quote [id|nick]",
+		Run:   runQuote,
+	})
+	Commands.Register(&Command{
+
+		Name:  "searchquote",
+		Usage: "!searchquote <words>",
+		Run:   runSearchQuote,
+	})
+	Commands.Register(&Command{
+
+		Name:  "delquote",
+		Usage: "!delquote <id>",
+		Run:   runDelQuote,
+	})
+}
+
+func formatQuote(q store.Quote) string {
+
+	if len(q.Nick) > 0 {
+
+		return fmt.Sprintf("#%d <%s> %s", q.ID, q.Nick, q.Text)
+	}
+
+	return fmt.Sprintf("#%d %s", q.ID, q.Text)
+}
+
+// Quotes belong to the channel they are added in
+func quoteChannel(h *HandlerFuncs, m *irc.Message) (channel string, ok bool) {
+
+	if len(m.Params) < 1 || !isChannel(m.Params[0]) {
+
+		h.Reply(m, "Quotes are kept per channel, ask in the channel itself")
+		return
+	}
+
+	return m.Params[0], true
+}
+
+// Parse a quote id, with or without the leading #
+func parseQuoteID(s string) (id int64, ok bool) {
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(s, "#"), 10, 64)
+	return id, err == nil && id > 0
+}
+
+func addQuote(h *HandlerFuncs, m *irc.Message, q *store.Quote) (err error) {
+
+	if err = DB.AddQuote(q); err != nil {
+
+		return
+	}
+
+	return h.Reply(m, fmt.Sprintf("Added quote #%d", q.ID))
+}
+
+func runAddQuote(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	channel, ok := quoteChannel(h, m)
+	if !ok {
+
+		return
+	}
+	if len(args) < 1 {
+
+		return h.Reply(m, "Usage: !addquote <text>")
+	}
+
+	return addQuote(h, m, &store.Quote{
+
+		Network: h.Name,
+		Channel: channel,
+		Text:    args,
+		AddedBy: m.Prefix.Name,
+	})
+}
+
+// Quote the last line the nick said in the channel
+func runGrab(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	channel, ok := quoteChannel(h, m)
+	if !ok {
+
+		return
+	}
+
+	nick := strings.TrimSpace(args)
+	if len(nick) < 1 || strings.Contains(nick, " ") {
+
+		return h.Reply(m, "Usage: !grab <nick>")
+	}
+	if strings.EqualFold(nick, m.Prefix.Name) {
+
+		return h.Reply(m, "You can't grab yourself")
+	}
+
+	msgs, err := DB.SearchMessages(h.Name, channel, store.MessageQuery{Nick: nick, Limit: 1})
+	if err != nil {
+
+		return
+	}
+	if len(msgs) < 1 {
+
+		return h.Reply(m, fmt.Sprintf("Nothing from %s in %s", nick, channel))
+	}
+
+	return addQuote(h, m, &store.Quote{
+
+		Network: h.Name,
+		Channel: channel,
+		Nick:    msgs[0].Nick,
+		Text:    msgs[0].Text,
+		AddedBy: m.Prefix.Name,
+	})
+}
+
+// A random quote, a quote by id or a random quote of a nick
+func runQuote(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	channel, ok := quoteChannel(h, m)
+	if !ok {
+
+		return
+	}
+
+	var q *store.Quote
+	arg := strings.TrimSpace(args)
+	if id, ok := parseQuoteID(arg); ok {
+
+		q, err = DB.GetQuote(h.Name, channel, id)
+	} else {
+
+		q, err = DB.RandomQuote(h.Name, channel, arg)
+	}
+	if err == store.ErrNotFound {
+
+		return h.Reply(m, "No such quote")
+	}
+	if err != nil {
+
+		return
+	}
+
+	return h.ClientConn.PrivMsg(channel, formatQuote(*q))
+}
+
+func runSearchQuote(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	channel, ok := quoteChannel(h, m)
+	if !ok {
+
+		return
+	}
+	if len(strings.TrimSpace(args)) < 1 {
+
+		return h.Reply(m, "Usage: !searchquote <words>")
+	}
+
+	quotes, err := DB.SearchQuotes(h.Name, channel, args, quoteResults)
+	if err != nil {
+
+		return
+	}
+	if len(quotes) < 1 {
+
+		return h.Reply(m, "No matches")
+	}
+
+	for _, q := range quotes {
+
+		if err = h.ClientConn.PrivMsg(channel, formatQuote(q)); err != nil {
+
+			return
+		}
+	}
+
+	return
+}
+
+func runDelQuote(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	channel, ok := quoteChannel(h, m)
+	if !ok {
+
+		return
+	}
+	if !h.IsAdmin(m) {
+
+		return h.Reply(m, "Only admins can delete quotes")
+	}
+
+	id, ok := parseQuoteID(strings.TrimSpace(args))
+	if !ok {
+
+		return h.Reply(m, "Usage: !delquote <id>")
+	}
+
+	err = DB.DeleteQuote(h.Name, channel, id)
+	if err == store.ErrNotFound {
+
+		return h.Reply(m, "No such quote")
+	}
+	if err != nil {
+
+		return
+	}
+
+	return h.Reply(m, fmt.Sprintf("Deleted quote #%d", id))
+}
+
+// The quotes subcommand, exports the quotes of a channel as text to a file
+// or w
+func runQuotes(db store.Store, w io.Writer, args []string) (err error) {
+
+	if len(args) < 3 || len(args) > 4 || args[0] != "export" {
+
+		return ErrQuotesUsage
+	}
+
+	quotes, err := db.Quotes(args[1], args[2])
+	if err != nil {
+
+		return
+	}
+
+	if len(args) == 4 {
+
+		f, err := os.Create(args[3])
+		if err != nil {
+
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	for _, q := range quotes {
+
+		_, err = fmt.Fprintf(w, "%s (added by %s on %s)\n", formatQuote(q), q.AddedBy, q.Time.Format("2006-01-02"))
+		if err != nil {
+
+			return
+		}
+	}
+
+	return
+}
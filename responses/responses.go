@@ -1,37 +1,369 @@
 /*
-   Lyrics:
-       - jonathancoulton.com/wiki/Still_Alive/Lyrics
+   Trigger engine. Triggers are loaded from JSON packs, either shipped with
+   the bot (packs/*.json) or read from files:
+
+       {
+           "name": "example",
+           "triggers": [
+               {
+                   "match": "regex",
+                   "pattern": "^hello (\\w+)$",
+                   "ignore_case": true,
+                   "responses": ["hi $nick, not $1", "/me waves at $nick"],
+                   "channels": ["#chat", "freenode/#other"],
+                   "cooldown": "30s"
+               }
+           ]
+       }
+
+   match is exact (the default), glob (* and ? wildcards, each * captured)
+   or regex. Responses may use $nick, $channel, $network, $botnick and the
+   captured groups $1..$9 or ${name}. A response starting with /me is sent
+   as an action. One response is picked at random.
 */
 
 package responses
 
-var Portal = map[string]string{
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed packs/*.json
+var packFiles embed.FS
+
+// Errors
+var (
+	ErrMatchType   = errors.New("Unknown trigger match type")
+	ErrNoResponses = errors.New("Trigger has no responses")
+	ErrNoPack      = errors.New("No such trigger pack")
+)
+
+// Match types
+const (
+	Exact = "exact"
+	Glob  = "glob"
+	Regex = "regex"
+)
+
+type Pack struct {
+	Name     string     `json:"name"`
+	Source   string     `json:"source"` // Attribution of the content
+	Triggers []*Trigger `json:"triggers"`
+}
+
+type Trigger struct {
+	Match      string   `json:"match"`
+	Pattern    string   `json:"pattern"`
+	IgnoreCase bool     `json:"ignore_case"`
+	Responses  []string `json:"responses"`
+	Channels   []string `json:"channels"` // Channels or network/channel globs, all when empty
+	Cooldown   string   `json:"cooldown"` // Minimum time between responses per channel
+
+	re       *regexp.Regexp
+	cooldown time.Duration
+}
+
+// Context of a message checked against the triggers
+type Context struct {
+	Network string
+	Channel string
+	Nick    string
+	Text    string
+
+	// The nick of the bot, asked for once a response uses $botnick
+	BotNick func() string
+}
+
+type Engine struct {
+	mu       sync.Mutex
+	triggers []*Trigger
+	last     map[string]time.Time // Last response by trigger and channel
+}
+
+func NewEngine() *Engine {
+
+	return &Engine{last: make(map[string]time.Time)}
+}
+
+// Packs returns the names of the packs shipped with the bot.
+func Packs() (names []string) {
+
+	entries, _ := packFiles.ReadDir("packs")
+	for _, e := range entries {
+
+		names = append(names, strings.TrimSuffix(e.Name(), ".json"))
+	}
+
+	return
+}
+
+// LoadPack reads a pack shipped with the bot.
+func LoadPack(name string) (p *Pack, err error) {
+
+	b, err := packFiles.ReadFile(path.Join("packs", name+".json"))
+	if err != nil {
+
+		return nil, fmt.Errorf("%w: %s", ErrNoPack, name)
+	}
+
+	return parsePack(b)
+}
+
+// LoadFile reads a pack from a file.
+func LoadFile(file string) (p *Pack, err error) {
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+
+		return
+	}
+
+	return parsePack(b)
+}
+
+func parsePack(b []byte) (p *Pack, err error) {
+
+	p = new(Pack)
+	if err = json.Unmarshal(b, p); err != nil {
+
+		return nil, err
+	}
+	for _, t := range p.Triggers {
+
+		if err = t.compile(); err != nil {
+
+			return nil, fmt.Errorf("pack %s, trigger %q: %w", p.Name, t.Pattern, err)
+		}
+	}
+
+	return
+}
+
+// Convert a glob into a regular expression capturing each *
+func globRegexp(glob string) string {
+
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+
+		switch r {
+
+		case '*':
+
+			b.WriteString("(.*)")
+
+		case '?':
+
+			b.WriteString(".")
+
+		default:
+
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+
+	return b.String()
+}
+
+func (t *Trigger) compile() (err error) {
+
+	if len(t.Responses) < 1 {
+
+		return ErrNoResponses
+	}
+
+	var expr string
+	switch t.Match {
+
+	case "", Exact:
 
-	"This was a triumph!": "I'm making a note here",
-	"Huge success!":       "It's hard to overstate my satisfaction",
+		expr = "^" + regexp.QuoteMeta(t.Pattern) + "$"
 
-	"Aperture Science.":             "We do what we must because we can.",
-	"For the good of all of us":     "Except the ones who are dead.",
-	"Except the ones who are dead.": "But there's no sense crying over every mistake.",
+	case Glob:
 
-	"You just keep on trying till you run out of cake.": "And the science gets done and you make a neat gun.",
-	"For the people who are still alive.":               "I'm not even angry.",
-	"I'm being so sincere right now.":                   "Even though you broke my heart and killed me.",
-	"And tore me to pieces.":                            "And threw every piece into a fire.",
-	"As they burned it hurt because":                    "I was so happy for you!",
+		expr = globRegexp(t.Pattern)
 
-	"Now these points of data make a beautiful line.": "And we're out of beta, we're releasing on time.",
-	"So I'm GLaD I got burned.":                       "Think of all the things we learned",
-	"For the people who are still alive":              "Go ahead and leave me.",
+	case Regex:
 
-	"I think I prefer to stay inside.": "Maybe you'll find someone else to help you.",
-	"Maybe Black Mesa...":              "THAT WAS A JOKE. Haha. FAT CHANCE.",
-	"Anyway, this cake is great.":      "It's so delicious and moist.",
+		expr = t.Pattern
+
+	default:
+
+		return ErrMatchType
+	}
+	if t.IgnoreCase {
+
+		expr = "(?i)" + expr
+	}
+	if t.re, err = regexp.Compile(expr); err != nil {
+
+		return
+	}
+
+	if len(t.Cooldown) > 0 {
+
+		if t.cooldown, err = time.ParseDuration(t.Cooldown); err != nil {
+
+			return
+		}
+	}
+
+	return
+}
+
+// Add the triggers of a pack to the engine.
+func (e *Engine) Add(p *Pack) {
+
+	e.mu.Lock()
+	e.triggers = append(e.triggers, p.Triggers...)
+	e.mu.Unlock()
+}
+
+func (t *Trigger) allowed(network, channel string) bool {
+
+	if len(t.Channels) < 1 {
+
+		return true
+	}
+
+	channel = strings.ToLower(channel)
+	full := strings.ToLower(network) + "/" + channel
+	for _, pattern := range t.Channels {
+
+		pattern = strings.ToLower(pattern)
+		if ok, _ := path.Match(pattern, channel); ok {
+
+			return true
+		}
+		if ok, _ := path.Match(pattern, full); ok {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Respond returns the response of the first trigger matching the message.
+// Triggers cooling down in the channel are skipped.
+func (e *Engine) Respond(c Context) (response string, ok bool) {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	for i, t := range e.triggers {
+
+		if !t.allowed(c.Network, c.Channel) {
+
+			continue
+		}
+
+		match := t.re.FindStringSubmatch(c.Text)
+		if match == nil {
+
+			continue
+		}
+
+		key := fmt.Sprintf("%d/%s/%s", i, c.Network, strings.ToLower(c.Channel))
+		if t.cooldown > 0 && now.Sub(e.last[key]) < t.cooldown {
+
+			continue
+		}
+		e.last[key] = now
+
+		response = t.Responses[rand.Intn(len(t.Responses))]
+		vars := map[string]string{
+
+			"nick":    c.Nick,
+			"channel": c.Channel,
+			"network": c.Network,
+		}
+		if c.BotNick != nil && strings.Contains(response, "botnick") {
+
+			vars["botnick"] = c.BotNick()
+		}
+		for n, name := range t.re.SubexpNames() {
+
+			vars[fmt.Sprint(n)] = match[n]
+			if len(name) > 0 {
+
+				vars[name] = match[n]
+			}
+		}
+
+		return expand(response, vars), true
+	}
+
+	return
+}
+
+// Replace $name and ${name} with their values, $$ is a literal $
+func expand(tmpl string, vars map[string]string) string {
+
+	var b strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+
+		if tmpl[i] != '$' || i+1 >= len(tmpl) {
+
+			b.WriteByte(tmpl[i])
+			continue
+		}
+
+		var name string
+		switch {
+
+		case tmpl[i+1] == '$':
+
+			b.WriteByte('$')
+			i++
+			continue
+
+		case tmpl[i+1] == '{':
+
+			end := strings.IndexByte(tmpl[i:], '}')
+			if end < 0 {
+
+				b.WriteByte(tmpl[i])
+				continue
+			}
+			name = tmpl[i+2 : i+end]
+			i += end
+
+		default:
+
+			j := i + 1
+			for j < len(tmpl) && isNameByte(tmpl[j]) {
+
+				j++
+			}
+			if j == i+1 {
+
+				b.WriteByte(tmpl[i])
+				continue
+			}
+			name = tmpl[i+1 : j]
+			i = j - 1
+		}
+
+		b.WriteString(vars[name])
+	}
+
+	return b.String()
+}
 
-	"Look at me still talking when there's science to do.": "When I look out there it makes me GLaD I'm not you.",
-	"I've experiments to run there is research to be done": "On the people who are still alive",
+func isNameByte(c byte) bool {
 
-	"And believe me I am still alive.":            "I'm doing science and I'm still alive.",
-	"I feel FANTASTIC and I'm still alive.":       "While you're dying I'll be still alive.",
-	"And when you're dead I will be still alive.": "Still alive",
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
 }
@@ -0,0 +1,138 @@
+package responses
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+
+	vars := map[string]string{
+
+		"nick": "bob",
+		"1":    "one",
+		"name": "value",
+	}
+
+	tests := []struct {
+		tmpl string
+		out  string
+	}{
+		{"hello", "hello"},
+		{"hi $nick", "hi bob"},
+		{"hi $nick!", "hi bob!"},
+		{"$1 and ${1}s", "one and ones"},
+		{"${name}", "value"},
+		{"$missing.", "."},
+		{"costs $$5", "costs $5"},
+		{"ends with $", "ends with $"},
+		{"$ alone", "$ alone"},
+		{"${unclosed", "${unclosed"},
+	}
+
+	for _, test := range tests {
+
+		if out := expand(test.tmpl, vars); out != test.out {
+
+			t.Errorf("expand(%q) = %q, want %q", test.tmpl, out, test.out)
+		}
+	}
+}
+
+const testPack = `{
+	"name": "test",
+	"triggers": [
+		{"pattern": "ping", "responses": ["pong $nick"]},
+		{"match": "glob", "pattern": "* is *", "responses": ["why is $1 $2?"]},
+		{"match": "regex", "pattern": "^hello (?P<who>\\w+)$", "ignore_case": true, "responses": ["hi ${who}, I am $botnick"]},
+		{"pattern": "secret", "channels": ["#ops", "other/#*"], "responses": ["in $channel on $network"]}
+	]
+}`
+
+func TestRespond(t *testing.T) {
+
+	p, err := parsePack([]byte(testPack))
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	e := NewEngine()
+	e.Add(p)
+
+	tests := []struct {
+		network  string
+		channel  string
+		text     string
+		response string
+		ok       bool
+	}{
+		{"net", "#chat", "ping", "pong bob", true},
+		{"net", "#chat", "Ping", "", false},
+		{"net", "#chat", "ping!", "", false},
+		{"net", "#chat", "the sky is blue", "why is the sky blue?", true},
+		{"net", "#chat", "HELLO world", "hi world, I am hackbot", true},
+		{"net", "#chat", "hello two words", "", false},
+		{"net", "#ops", "secret", "in #ops on net", true},
+		{"other", "#any", "secret", "in #any on other", true},
+		{"net", "#any", "secret", "", false},
+	}
+
+	for _, test := range tests {
+
+		// The nick of the bot is only asked for by responses using it
+		asked := false
+		response, ok := e.Respond(Context{
+
+			Network: test.network,
+			Channel: test.channel,
+			Nick:    "bob",
+			Text:    test.text,
+			BotNick: func() string {
+
+				asked = true
+				return "hackbot"
+			},
+		})
+		if response != test.response || ok != test.ok {
+
+			t.Errorf("Respond(%s %s %q) = %q, %v, want %q, %v",
+				test.network, test.channel, test.text, response, ok, test.response, test.ok)
+		}
+		if want := strings.Contains(test.response, "hackbot"); asked != want {
+
+			t.Errorf("Respond(%s %s %q) asked for the bot nick %v, want %v",
+				test.network, test.channel, test.text, asked, want)
+		}
+	}
+}
+
+func TestParsePackErrors(t *testing.T) {
+
+	tests := []struct {
+		pack string
+		err  error
+	}{
+		{`{"triggers": [{"pattern": "x"}]}`, ErrNoResponses},
+		{`{"triggers": [{"match": "fuzzy", "pattern": "x", "responses": ["y"]}]}`, ErrMatchType},
+	}
+
+	for _, test := range tests {
+
+		if _, err := parsePack([]byte(test.pack)); !errors.Is(err, test.err) {
+
+			t.Errorf("parsePack(%s) = %v, want %v", test.pack, err, test.err)
+		}
+	}
+}
+
+func TestPacks(t *testing.T) {
+
+	for _, name := range Packs() {
+
+		if _, err := LoadPack(name); err != nil {
+
+			t.Errorf("LoadPack(%q): %v", name, err)
+		}
+	}
+}
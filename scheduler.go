@@ -0,0 +1,381 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/TheCreeper/HackBot/cron"
+	"github.com/TheCreeper/HackBot/store"
+	"github.com/sorcix/irc"
+)
+
+// Errors
+var (
+	ErrTime = errors.New("Invalid time")
+)
+
+// How often due reminders are looked for
+const schedulerInterval = 5 * time.Second
+
+// How often old messages are removed from the channel history
+const pruneInterval = time.Hour
+
+// Pending reminders per sender when Reminders.MaxPerSender is not set
+const defaultRemindersPerSender = 10
+
+// A configured announcement
+type Announcement struct {
+	Network  string
+	Channel  string
+	Schedule *cron.Schedule
+	Message  string
+}
+
+// The Scheduler delivers reminders from the database once due, closes polls,
+// drops expired memos and sends the announcements when their schedule
+// matches. Reminders and polls of networks that are not connected stay in the
+// database until they are. Messages older than HistoryRetention are pruned from the channel
+// history unless it is zero.
+type Scheduler struct {
+	DB               store.Store
+	Networks         *NetworkList
+	Announcements    []Announcement
+	HistoryRetention time.Duration
+
+	lastMinute time.Time
+	lastPrune  time.Time
+}
+
+func newAnnouncements(cfg *ClientConfig) (announcements []Announcement, err error) {
+
+	for _, a := range cfg.Announcements {
+
+		s, err := cron.Parse(a.Schedule)
+		if err != nil {
+
+			return nil, fmt.Errorf("announcement %q: %w", a.Schedule, err)
+		}
+		announcements = append(announcements, Announcement{
+
+			Network:  a.Network,
+			Channel:  a.Channel,
+			Schedule: s,
+			Message:  a.Message,
+		})
+	}
+
+	return
+}
+
+func (s *Scheduler) Run() {
+
+	t := time.NewTicker(schedulerInterval)
+	defer t.Stop()
+
+	for now := range t.C {
+
+		s.deliverReminders(now)
+		s.closePolls(now)
+
+		minute := now.Truncate(time.Minute)
+		if minute.After(s.lastMinute) {
+
+			s.lastMinute = minute
+			s.announce(now)
+			s.expireMemos(now)
+		}
+		if s.HistoryRetention > 0 && now.Sub(s.lastPrune) >= pruneInterval {
+
+			s.lastPrune = now
+			s.pruneHistory(now)
+		}
+	}
+}
+
+// Connected network by name
+func (s *Scheduler) network(name string) (n *Network, ok bool) {
+
+	n, ok = s.Networks.Get(name)
+	if !ok || n.ConnectedSince().IsZero() {
+
+		return nil, false
+	}
+
+	return n, true
+}
+
+func (s *Scheduler) deliverReminders(now time.Time) {
+
+	reminders, err := s.DB.DueReminders(now)
+	if err != nil {
+
+		slog.Error("store.DueReminders()", "error", err)
+		return
+	}
+
+	for _, r := range reminders {
+
+		n, ok := s.network(r.Network)
+		if !ok {
+
+			continue
+		}
+
+		text := r.Text
+		if !strings.EqualFold(r.Sender, r.Target) {
+
+			text = fmt.Sprintf("reminder from %s: %s", r.Sender, r.Text)
+		}
+
+		switch {
+
+		case isChannel(r.Target):
+
+			err = n.ClientConn.PrivMsg(r.Target, text)
+
+		case len(r.Channel) > 0:
+
+			err = n.ClientConn.PrivMsg(r.Channel, fmt.Sprintf("%s: %s", r.Target, text))
+
+		default:
+
+			err = n.ClientConn.PrivMsg(r.Target, text)
+		}
+		if err != nil {
+
+			slog.Error("reminder delivery", "network", r.Network, "nick", r.Target, "error", err)
+			continue
+		}
+
+		if err = s.DB.DeleteReminder(r.ID); err != nil {
+
+			slog.Error("store.DeleteReminder()", "network", r.Network, "id", r.ID, "error", err)
+		}
+	}
+}
+
+func (s *Scheduler) closePolls(now time.Time) {
+
+	polls, err := s.DB.DuePolls(now)
+	if err != nil {
+
+		slog.Error("store.DuePolls()", "error", err)
+		return
+	}
+
+	for i := range polls {
+
+		p := &polls[i]
+		n, ok := s.network(p.Network)
+		if !ok {
+
+			continue
+		}
+		if err = closePoll(n.ClientConn.PrivMsg, p); err != nil {
+
+			slog.Error("poll results", "network", p.Network, "channel", p.Channel, "id", p.ID, "error", err)
+		}
+	}
+}
+
+func (s *Scheduler) announce(now time.Time) {
+
+	for _, a := range s.Announcements {
+
+		if !a.Schedule.Match(now) {
+
+			continue
+		}
+
+		n, ok := s.network(a.Network)
+		if !ok {
+
+			continue
+		}
+		if err := n.ClientConn.PrivMsg(a.Channel, a.Message); err != nil {
+
+			slog.Error("announcement", "network", a.Network, "channel", a.Channel, "error", err)
+		}
+	}
+}
+
+func (s *Scheduler) expireMemos(now time.Time) {
+
+	if err := s.DB.ExpireMemos(now); err != nil {
+
+		slog.Error("store.ExpireMemos()", "error", err)
+		return
+	}
+	pendingMemos.Reset("")
+}
+
+func (s *Scheduler) pruneHistory(now time.Time) {
+
+	if err := s.DB.PruneMessages(now.Add(-s.HistoryRetention)); err != nil {
+
+		slog.Error("store.PruneMessages()", "error", err)
+	}
+}
+
+// Pending reminders per sender
+func reminderLimit(cfg *ClientConfig) int {
+
+	if cfg.Reminders.MaxPerSender < 1 {
+
+		return defaultRemindersPerSender
+	}
+
+	return cfg.Reminders.MaxPerSender
+}
+
+func registerReminderCommands(limit int) {
+
+	Commands.Register(&Command{
+
+		Name:  "remind",
+		Usage: "!remind <me|#channel> <in <duration>|at [YYYY-MM-DD] HH:MM> <text>",
+		Run: func(h *HandlerFuncs, m *irc.Message, args string) error {
+
+			return runRemind(h, m, args, limit)
+		},
+	})
+	Commands.Register(&Command{
+
+		Name:  "at",
+		Usage: "!at [YYYY-MM-DD] HH:MM <text>",
+		Run: func(h *HandlerFuncs, m *irc.Message, args string) error {
+
+			return runRemind(h, m, "me at "+args, limit)
+		},
+	})
+}
+
+// Parse "in <duration>" or "at [date] HH:MM" from the front of argv, returning
+// the due time and the remaining arguments.
+func parseWhen(argv []string, now time.Time) (due time.Time, rest []string, err error) {
+
+	if len(argv) < 2 {
+
+		return due, nil, ErrTime
+	}
+
+	switch argv[0] {
+
+	case "in":
+
+		d, err := parseDuration(argv[1])
+		if err != nil || d <= 0 {
+
+			return due, nil, ErrDuration
+		}
+		return now.Add(d), argv[2:], nil
+
+	case "at":
+
+		date := now.Format("2006-01-02")
+		clock := argv[1]
+		rest = argv[2:]
+		explicitDate := false
+		if len(argv) > 2 && strings.Count(argv[1], "-") == 2 {
+
+			date, clock, rest = argv[1], argv[2], argv[3:]
+			explicitDate = true
+		}
+
+		due, err = time.ParseInLocation("2006-01-02 15:04", date+" "+clock, now.Location())
+		if err != nil {
+
+			return due, nil, ErrTime
+		}
+
+		// A time already passed today means tomorrow
+		if !due.After(now) {
+
+			if explicitDate {
+
+				return due, nil, ErrTime
+			}
+			due = due.AddDate(0, 0, 1)
+		}
+		return due, rest, nil
+	}
+
+	return due, nil, ErrTime
+}
+
+// Reminders are for the sender, or the channel the command was said in
+func runRemind(h *HandlerFuncs, m *irc.Message, args string, limit int) (err error) {
+
+	usage := "Usage: !remind <me|#channel> <in 2h30m|at [YYYY-MM-DD] 14:00> <text>"
+
+	argv := strings.Fields(args)
+	if len(argv) < 4 {
+
+		return h.Reply(m, usage)
+	}
+
+	channel := ""
+	if len(m.Params) > 0 && isChannel(m.Params[0]) {
+
+		channel = m.Params[0]
+	}
+
+	target := argv[0]
+	switch {
+
+	case target == "me" || strings.EqualFold(target, m.Prefix.Name):
+
+		target = m.Prefix.Name
+
+	case len(channel) > 0 && strings.EqualFold(target, channel):
+
+		target = channel
+
+	default:
+
+		return h.Reply(m, "Reminders are for you or this channel, "+usage)
+	}
+
+	now := time.Now()
+	due, rest, err := parseWhen(argv[1:], now)
+	if err != nil || len(rest) < 1 {
+
+		return h.Reply(m, usage)
+	}
+
+	pending, err := DB.CountReminders(h.Name, m.Prefix.Name)
+	if err != nil {
+
+		return
+	}
+	if pending >= limit {
+
+		return h.Reply(m, fmt.Sprintf("You already have %d reminders waiting", pending))
+	}
+
+	r := &store.Reminder{
+
+		Network: h.Name,
+		Channel: channel,
+		Sender:  m.Prefix.Name,
+		Target:  target,
+		Text:    strings.Join(rest, " "),
+		Created: now,
+		Due:     due,
+	}
+	if err = DB.AddReminder(r); err != nil {
+
+		return
+	}
+
+	who := target
+	if strings.EqualFold(target, m.Prefix.Name) {
+
+		who = "you"
+	}
+
+	return h.Reply(m, fmt.Sprintf("I'll remind %s at %s", who, due.Format("2006-01-02 15:04 MST")))
+}
@@ -0,0 +1,864 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheCreeper/HackBot/plugin"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Errors
+var (
+	ErrScriptClosed = errors.New("Script is not loaded")
+	ErrScriptMemory = errors.New("Script exceeded its memory limit")
+	ErrScriptLoaded = errors.New("Only allowed while the script loads")
+)
+
+// Limits of scripts
+const (
+	defaultScriptTimeout = 5 * time.Second
+	defaultScriptMemory  = 4096 // KB, estimated after each handler
+	scriptCallStack      = 200
+	scriptRegistryMax    = 256 * 1024 // Slots of the Lua stack
+	scriptPollInterval   = 2 * time.Second
+)
+
+// A luaScript is an embedded plugin written in Lua. Scripts register their
+// handlers with the bot table while they load:
+//
+//	bot.version = "1.0"
+//	bot.command("hello", "!hello", function(e)
+//		bot.reply(e, "Hello " .. e.nick)
+//	end)
+//	bot.on("join", function(e) ... end)
+type luaScript struct {
+	api     *pluginAPI
+	file    string
+	timeout time.Duration
+	memory  int // Bytes
+
+	mu       sync.Mutex
+	L        *lua.LState
+	loading  bool
+	version  string
+	handlers map[string][]*lua.LFunction
+	commands map[string]*lua.LFunction
+	usages   map[string]string
+}
+
+func newLuaScript(api *pluginAPI, file string, cfg ScriptsConfig) *luaScript {
+
+	s := &luaScript{
+
+		api:      api,
+		file:     file,
+		timeout:  defaultScriptTimeout,
+		memory:   defaultScriptMemory * 1024,
+		handlers: make(map[string][]*lua.LFunction),
+		commands: make(map[string]*lua.LFunction),
+		usages:   make(map[string]string),
+	}
+	if cfg.TimeoutSeconds > 0 {
+
+		s.timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	if cfg.MemoryKB > 0 {
+
+		s.memory = cfg.MemoryKB * 1024
+	}
+
+	s.L = lua.NewState(lua.Options{
+
+		SkipOpenLibs:    true,
+		CallStackSize:   scriptCallStack,
+		RegistryMaxSize: scriptRegistryMax,
+	})
+	s.openLibs()
+
+	return s
+}
+
+// Only the libraries without access to files and processes are opened
+func (s *luaScript) openLibs() {
+
+	L := s.L
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+		{lua.CoroutineLibName, lua.OpenCoroutine},
+	} {
+
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	L.SetGlobal("dofile", lua.LNil)
+	L.SetGlobal("loadfile", lua.LNil)
+	L.SetGlobal("print", L.NewFunction(s.print))
+
+	// string.rep could make a string far beyond the memory limit at once
+	if str, ok := L.GetGlobal("string").(*lua.LTable); ok {
+
+		L.SetField(str, "rep", L.NewFunction(s.rep))
+	}
+
+	bot := L.NewTable()
+	L.SetFuncs(bot, map[string]lua.LGFunction{
+
+		"on":       s.on,
+		"command":  s.command,
+		"send":     s.send,
+		"notice":   s.notice,
+		"action":   s.action,
+		"reply":    s.reply,
+		"join":     s.join,
+		"part":     s.part,
+		"raw":      s.raw,
+		"fetch":    s.fetch,
+		"state":    s.state,
+		"networks": s.networks,
+		"get":      s.get,
+		"set":      s.set,
+		"delete":   s.del,
+		"keys":     s.keys,
+		"push":     s.push,
+		"pop":      s.pop,
+		"range":    s.lrange,
+		"zadd":     s.zadd,
+		"zincr":    s.zincr,
+		"zrem":     s.zrem,
+		"zscore":   s.zscore,
+		"zrange":   s.zrange,
+	})
+	L.SetGlobal("bot", bot)
+}
+
+// Run the script so it registers its handlers
+func (s *luaScript) load() (err error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	s.L.SetContext(ctx)
+	defer s.L.RemoveContext()
+
+	s.loading = true
+	defer func() { s.loading = false }()
+
+	if err = s.L.DoFile(s.file); err != nil {
+
+		return
+	}
+	if bot, ok := s.L.GetGlobal("bot").(*lua.LTable); ok {
+
+		s.version = lua.LVAsString(s.L.GetField(bot, "version"))
+	}
+
+	return s.checkMemory()
+}
+
+// Manifest of what the script registered, granted the configured capabilities
+func (s *luaScript) manifest(name string, capabilities []string) *plugin.Manifest {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := &plugin.Manifest{
+
+		Name:         name,
+		Version:      s.version,
+		Capabilities: capabilities,
+	}
+	for e := range s.handlers {
+
+		m.Events = append(m.Events, e)
+	}
+	for c := range s.commands {
+
+		m.Commands = append(m.Commands, c)
+	}
+	sort.Strings(m.Events)
+	sort.Strings(m.Commands)
+
+	return m
+}
+
+func (s *luaScript) Event(e plugin.Event) (err error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.L == nil {
+
+		return ErrScriptClosed
+	}
+
+	handlers := s.handlers[e.Type]
+	if e.Type == plugin.EventCommand {
+
+		handlers = nil
+		if fn, ok := s.commands[e.Command]; ok {
+
+			handlers = []*lua.LFunction{fn}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	s.L.SetContext(ctx)
+	defer s.L.RemoveContext()
+
+	for _, fn := range handlers {
+
+		err = s.L.CallByParam(lua.P{Fn: fn, NRet: 0, Protect: true}, eventTable(s.L, &e))
+		if err != nil {
+
+			return
+		}
+	}
+
+	if err = s.checkMemory(); err != nil {
+
+		// Unloaded until the file changes
+		go s.api.host.Stop(s.api.plugin.Config.Name)
+	}
+
+	return
+}
+
+func (s *luaScript) Close() {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.L != nil {
+
+		s.L.Close()
+		s.L = nil
+	}
+}
+
+// Estimate the data the script keeps, after it loaded and after each handler.
+// This is no hard limit: the Lua state is not metered while a handler runs,
+// only the timeout and the checks on string.rep stop one building up memory.
+func (s *luaScript) checkMemory() error {
+
+	seen := make(map[lua.LValue]bool)
+	size := luaSize(s.L.G.Global, seen)
+	for _, fns := range s.handlers {
+
+		for _, fn := range fns {
+
+			size += luaSize(fn, seen)
+		}
+	}
+	for _, fn := range s.commands {
+
+		size += luaSize(fn, seen)
+	}
+	if size > s.memory {
+
+		return fmt.Errorf("%w: about %dKB", ErrScriptMemory, size/1024)
+	}
+
+	return nil
+}
+
+// Rough size of a value and everything reachable from it
+func luaSize(v lua.LValue, seen map[lua.LValue]bool) (size int) {
+
+	switch v := v.(type) {
+
+	case lua.LString:
+
+		return 16 + len(v)
+
+	case *lua.LTable:
+
+		if seen[v] {
+
+			return 0
+		}
+		seen[v] = true
+		size = 64
+		v.ForEach(func(key, value lua.LValue) {
+
+			size += luaSize(key, seen) + luaSize(value, seen)
+		})
+
+	case *lua.LFunction:
+
+		if seen[v] {
+
+			return 0
+		}
+		seen[v] = true
+		size = 64
+		for _, uv := range v.Upvalues {
+
+			size += luaSize(uv.Value(), seen)
+		}
+
+	default:
+
+		size = 16
+	}
+
+	return
+}
+
+func eventTable(L *lua.LState, e *plugin.Event) *lua.LTable {
+
+	t := L.NewTable()
+	for k, v := range map[string]string{
+
+		"type":    e.Type,
+		"network": e.Network,
+		"channel": e.Channel,
+		"nick":    e.Nick,
+		"user":    e.User,
+		"host":    e.Host,
+		"account": e.Account,
+		"text":    e.Text,
+		"target":  e.Target,
+		"command": e.Command,
+		"args":    e.Args,
+	} {
+
+		t.RawSetString(k, lua.LString(v))
+	}
+	t.RawSetString("time", lua.LNumber(e.Time.Unix()))
+
+	return t
+}
+
+// Results of Host calls, true or nil and the error
+func result(L *lua.LState, err error) int {
+
+	if err != nil {
+
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+
+	return 1
+}
+
+func (s *luaScript) print(L *lua.LState) int {
+
+	var args []string
+	for i := 1; i <= L.GetTop(); i++ {
+
+		args = append(args, L.Get(i).String())
+	}
+	s.api.plugin.log.Info("plugin output", "stream", "print", "line", strings.Join(args, "\t"))
+
+	return 0
+}
+
+func (s *luaScript) rep(L *lua.LState) int {
+
+	str := L.CheckString(1)
+	n := L.CheckInt(2)
+	if n < 1 {
+
+		L.Push(lua.LString(""))
+		return 1
+	}
+	if len(str) > s.memory/n {
+
+		L.RaiseError("string.rep: result larger than the memory limit")
+		return 0
+	}
+	L.Push(lua.LString(strings.Repeat(str, n)))
+
+	return 1
+}
+
+func (s *luaScript) on(L *lua.LState) int {
+
+	event := L.CheckString(1)
+	fn := L.CheckFunction(2)
+	if !s.loading {
+
+		L.RaiseError("bot.on: %s", ErrScriptLoaded)
+		return 0
+	}
+	s.handlers[event] = append(s.handlers[event], fn)
+
+	return 0
+}
+
+func (s *luaScript) command(L *lua.LState) int {
+
+	name := strings.ToLower(L.CheckString(1))
+	usage := L.CheckString(2)
+	fn := L.CheckFunction(3)
+	if !s.loading {
+
+		L.RaiseError("bot.command: %s", ErrScriptLoaded)
+		return 0
+	}
+	s.commands[name] = fn
+	s.usages[name] = usage
+
+	return 0
+}
+
+func (s *luaScript) sendArgs(L *lua.LState, notice, action bool) int {
+
+	var ack bool
+	return result(L, s.api.Send(plugin.SendArgs{
+
+		Network: L.CheckString(1),
+		Target:  L.CheckString(2),
+		Text:    L.CheckString(3),
+		Notice:  notice,
+		Action:  action,
+	}, &ack))
+}
+
+func (s *luaScript) send(L *lua.LState) int {
+
+	return s.sendArgs(L, false, false)
+}
+
+func (s *luaScript) notice(L *lua.LState) int {
+
+	return s.sendArgs(L, true, false)
+}
+
+func (s *luaScript) action(L *lua.LState) int {
+
+	return s.sendArgs(L, false, true)
+}
+
+// bot.reply(e, text) answers an event as Client.Reply does
+func (s *luaScript) reply(L *lua.LState) int {
+
+	t := L.CheckTable(1)
+	text := L.CheckString(2)
+	e := plugin.Event{
+
+		Type:    lua.LVAsString(t.RawGetString("type")),
+		Network: lua.LVAsString(t.RawGetString("network")),
+		Channel: lua.LVAsString(t.RawGetString("channel")),
+		Nick:    lua.LVAsString(t.RawGetString("nick")),
+	}
+
+	args := plugin.SendArgs{Network: e.Network, Target: e.Nick, Text: text}
+	if !e.Private() {
+
+		args.Target = e.Channel
+		args.Text = fmt.Sprintf("%s: %s", e.Nick, text)
+	}
+
+	var ack bool
+	return result(L, s.api.Send(args, &ack))
+}
+
+func (s *luaScript) join(L *lua.LState) int {
+
+	var ack bool
+	return result(L, s.api.Join(plugin.JoinArgs{Network: L.CheckString(1), Channel: L.CheckString(2)}, &ack))
+}
+
+func (s *luaScript) part(L *lua.LState) int {
+
+	var ack bool
+	return result(L, s.api.Part(plugin.JoinArgs{Network: L.CheckString(1), Channel: L.CheckString(2)}, &ack))
+}
+
+func (s *luaScript) raw(L *lua.LState) int {
+
+	var ack bool
+	return result(L, s.api.Raw(plugin.RawArgs{Network: L.CheckString(1), Line: L.CheckString(2)}, &ack))
+}
+
+// bot.fetch(url) returns the status, body and content type
+func (s *luaScript) fetch(L *lua.LState) int {
+
+	var reply plugin.FetchReply
+	if err := s.api.Fetch(plugin.FetchArgs{URL: L.CheckString(1)}, &reply); err != nil {
+
+		return result(L, err)
+	}
+	L.Push(lua.LNumber(reply.Status))
+	L.Push(lua.LString(reply.Body))
+	L.Push(lua.LString(reply.ContentType))
+
+	return 3
+}
+
+// bot.state(network[, channel]) returns a table of the channels, or of the
+// topic and users of the channel
+func (s *luaScript) state(L *lua.LState) int {
+
+	var reply plugin.StateReply
+	err := s.api.State(plugin.StateArgs{Network: L.CheckString(1), Channel: L.OptString(2, "")}, &reply)
+	if err != nil {
+
+		return result(L, err)
+	}
+
+	channels := L.NewTable()
+	for _, c := range reply.Channels {
+
+		channels.Append(lua.LString(c))
+	}
+	users := L.NewTable()
+	for nick, mode := range reply.Users {
+
+		users.RawSetString(nick, lua.LString(mode))
+	}
+
+	t := L.NewTable()
+	t.RawSetString("nick", lua.LString(reply.Nick))
+	t.RawSetString("channels", channels)
+	t.RawSetString("topic", lua.LString(reply.Topic))
+	t.RawSetString("users", users)
+	L.Push(t)
+
+	return 1
+}
+
+func (s *luaScript) networks(L *lua.LState) int {
+
+	t := L.NewTable()
+	for _, n := range s.api.host.Networks.All() {
+
+		t.Append(lua.LString(n.Name))
+	}
+	L.Push(t)
+
+	return 1
+}
+
+/*
+   Storage, with the database capability
+*/
+
+func stringsTable(L *lua.LState, values []string) *lua.LTable {
+
+	t := L.NewTable()
+	for _, v := range values {
+
+		t.Append(lua.LString(v))
+	}
+
+	return t
+}
+
+// bot.get(key) returns the value, or nil when there is none
+func (s *luaScript) get(L *lua.LState) int {
+
+	var reply plugin.KVReply
+	if err := s.api.KVGet(plugin.KVArgs{Key: L.CheckString(1)}, &reply); err != nil {
+
+		return result(L, err)
+	}
+	if !reply.Found {
+
+		L.Push(lua.LNil)
+		return 1
+	}
+	L.Push(lua.LString(reply.Value))
+
+	return 1
+}
+
+func (s *luaScript) set(L *lua.LState) int {
+
+	var ack bool
+	return result(L, s.api.KVSet(plugin.KVArgs{Key: L.CheckString(1), Value: []byte(L.CheckString(2))}, &ack))
+}
+
+func (s *luaScript) del(L *lua.LState) int {
+
+	var ack bool
+	return result(L, s.api.KVDelete(plugin.KVArgs{Key: L.CheckString(1)}, &ack))
+}
+
+// bot.keys([prefix]) returns a table of the values, lists and sorted_sets
+func (s *luaScript) keys(L *lua.LState) int {
+
+	var reply plugin.KeysReply
+	if err := s.api.KVKeys(plugin.KeysArgs{Prefix: L.OptString(1, "")}, &reply); err != nil {
+
+		return result(L, err)
+	}
+
+	t := L.NewTable()
+	t.RawSetString("values", stringsTable(L, reply.Values))
+	t.RawSetString("lists", stringsTable(L, reply.Lists))
+	t.RawSetString("sorted_sets", stringsTable(L, reply.SortedSets))
+	L.Push(t)
+
+	return 1
+}
+
+// bot.push(key, value[, front]) returns the length of the list
+func (s *luaScript) push(L *lua.LState) int {
+
+	var reply plugin.ListReply
+	args := plugin.ListArgs{Key: L.CheckString(1), Values: []string{L.CheckString(2)}, Front: L.OptBool(3, false)}
+	if err := s.api.ListPush(args, &reply); err != nil {
+
+		return result(L, err)
+	}
+	L.Push(lua.LNumber(reply.Length))
+
+	return 1
+}
+
+// bot.pop(key[, front]) returns the value, or nil when the list is empty
+func (s *luaScript) pop(L *lua.LState) int {
+
+	var reply plugin.ListReply
+	if err := s.api.ListPop(plugin.ListArgs{Key: L.CheckString(1), Front: L.OptBool(2, false)}, &reply); err != nil {
+
+		return result(L, err)
+	}
+	if !reply.Found {
+
+		L.Push(lua.LNil)
+		return 1
+	}
+	L.Push(lua.LString(reply.Values[0]))
+
+	return 1
+}
+
+// bot.range(key, start, stop) returns a table of the values from start to
+// stop, counted from 0 as in the plugin API
+func (s *luaScript) lrange(L *lua.LState) int {
+
+	var reply plugin.ListReply
+	args := plugin.ListArgs{Key: L.CheckString(1), Start: L.OptInt(2, 0), Stop: L.OptInt(3, -1)}
+	if err := s.api.ListRange(args, &reply); err != nil {
+
+		return result(L, err)
+	}
+	L.Push(stringsTable(L, reply.Values))
+
+	return 1
+}
+
+func (s *luaScript) zset(L *lua.LState, incr bool) int {
+
+	var reply plugin.ZReply
+	args := plugin.ZArgs{Key: L.CheckString(1), Member: L.CheckString(2), Score: float64(L.CheckNumber(3)), Incr: incr}
+	if err := s.api.ZAdd(args, &reply); err != nil {
+
+		return result(L, err)
+	}
+	L.Push(lua.LNumber(reply.Score))
+
+	return 1
+}
+
+// bot.zadd(key, member, score) and bot.zincr(key, member, delta) return the
+// score of the member
+func (s *luaScript) zadd(L *lua.LState) int {
+
+	return s.zset(L, false)
+}
+
+func (s *luaScript) zincr(L *lua.LState) int {
+
+	return s.zset(L, true)
+}
+
+func (s *luaScript) zrem(L *lua.LState) int {
+
+	var ack bool
+	return result(L, s.api.ZRemove(plugin.ZArgs{Key: L.CheckString(1), Member: L.CheckString(2)}, &ack))
+}
+
+// bot.zscore(key, member) returns the score, or nil when not in the set
+func (s *luaScript) zscore(L *lua.LState) int {
+
+	var reply plugin.ZReply
+	if err := s.api.ZScore(plugin.ZArgs{Key: L.CheckString(1), Member: L.CheckString(2)}, &reply); err != nil {
+
+		return result(L, err)
+	}
+	if !reply.Found {
+
+		L.Push(lua.LNil)
+		return 1
+	}
+	L.Push(lua.LNumber(reply.Score))
+
+	return 1
+}
+
+// bot.zrange(key, start, stop[, reverse]) returns a table of members with
+// their member and score
+func (s *luaScript) zrange(L *lua.LState) int {
+
+	var reply plugin.ZReply
+	args := plugin.ZArgs{Key: L.CheckString(1), Start: L.OptInt(2, 0), Stop: L.OptInt(3, -1), Reverse: L.OptBool(4, false)}
+	if err := s.api.ZRange(args, &reply); err != nil {
+
+		return result(L, err)
+	}
+
+	t := L.NewTable()
+	for _, m := range reply.Members {
+
+		member := L.NewTable()
+		member.RawSetString("member", lua.LString(m.Member))
+		member.RawSetString("score", lua.LNumber(m.Score))
+		t.Append(member)
+	}
+	L.Push(t)
+
+	return 1
+}
+
+// LoadScript loads a script as an embedded plugin named after the file, or
+// reloads it when it is loaded already.
+func (h *PluginHost) LoadScript(file string, cfg ScriptsConfig) (err error) {
+
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	capabilities := cfg.Capabilities
+	if capabilities == nil {
+
+		capabilities = []string{plugin.CapSend}
+	}
+
+	// WebAssembly modules are embedded too
+	p, ok := h.Plugin(name)
+	if ok && p.Kind() != "script" {
+
+		return ErrPluginExists
+	}
+	reload := ok
+	if !ok {
+
+		p = newPlugin(PluginConfig{
+
+			Name:         name,
+			Path:         file,
+			Capabilities: capabilities,
+			Networks:     cfg.Networks,
+			Channels:     cfg.Channels,
+		}, &plugin.Manifest{Name: name, Capabilities: capabilities})
+		p.embedded = true
+	}
+
+	api := &pluginAPI{host: h, plugin: p}
+	s := newLuaScript(api, file, cfg)
+	if err = s.load(); err != nil {
+
+		s.Close()
+		return
+	}
+
+	// The old script keeps running when the new one can't be attached
+	var old pluginRuntime
+	manifest := p.Manifest
+	if reload {
+
+		old = h.detach(p, allConns)
+	}
+	p.Manifest = s.manifest(name, capabilities)
+
+	if err = h.attach(p, s, s.version, p.Manifest.Events, s.usages, reload); err != nil {
+
+		s.Close()
+		if prev, ok := old.(*luaScript); ok {
+
+			p.Manifest = manifest
+			if rerr := h.attach(p, prev, prev.version, manifest.Events, prev.usages, true); rerr != nil {
+
+				prev.Close()
+				p.log.Error("script rollback", "file", file, "error", rerr)
+			}
+		} else if old != nil {
+
+			old.Close()
+		}
+		return
+	}
+	if old != nil {
+
+		old.Close()
+	}
+	p.log.Info("script loaded", "file", file, "reload", reload)
+
+	return
+}
+
+// WatchScripts loads the scripts in the directory, reloading those that
+// change and stopping those that are removed.
+func (h *PluginHost) WatchScripts(cfg ScriptsConfig) {
+
+	loaded := make(map[string]time.Time) // Modification time by file
+	for {
+
+		files, err := ioutil.ReadDir(cfg.Dir)
+		if err != nil {
+
+			slog.Error("scripts", "dir", cfg.Dir, "error", err)
+		}
+
+		present := make(map[string]bool)
+		for _, f := range files {
+
+			if f.IsDir() || filepath.Ext(f.Name()) != ".lua" {
+
+				continue
+			}
+			file := filepath.Join(cfg.Dir, f.Name())
+			present[file] = true
+
+			if t, ok := loaded[file]; ok && t.Equal(f.ModTime()) {
+
+				continue
+			}
+			// Failed loads are retried when the file changes again
+			loaded[file] = f.ModTime()
+			if err := h.LoadScript(file, cfg); err != nil {
+
+				slog.Error("script", "file", file, "error", err)
+			}
+		}
+
+		for file := range loaded {
+
+			if present[file] {
+
+				continue
+			}
+			delete(loaded, file)
+			name := strings.TrimSuffix(filepath.Base(file), ".lua")
+			if p, ok := h.Plugin(name); ok && p.Kind() == "script" {
+
+				h.Stop(name)
+				p.log.Info("script removed", "file", file)
+			}
+		}
+
+		time.Sleep(scriptPollInterval)
+	}
+}
@@ -71,6 +71,13 @@ type Client struct {
 	// UserName and PassWord for authentication with the WebServer
 	UserName string
 	PassWord string
+
+	// Called with the status code and detected MIME type of every response
+	OnResponse func(status int, mime string)
+
+	// Gets the page instead of the http client when set, such as through a
+	// bot plugin host
+	Fetch func(url string) (status int, body []byte, err error)
 }
 
 func (c *Client) dial(network, addr string) (net.Conn, error) {
@@ -94,7 +101,7 @@ type CrawlResult struct {
 	Size int // Size of webpage
 }
 
-func (c *Client) Crawl(urlF string) (r *CrawlResult, err error) {
+func (c *Client) get(urlF string) (status int, body []byte, err error) {
 
 	httpClient := &http.Client{
 
@@ -119,13 +126,29 @@ func (c *Client) Crawl(urlF string) (r *CrawlResult, err error) {
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err = ioutil.ReadAll(resp.Body)
+
+	return resp.StatusCode, body, err
+}
+
+func (c *Client) Crawl(urlF string) (r *CrawlResult, err error) {
+
+	fetch := c.Fetch
+	if fetch == nil {
+
+		fetch = c.get
+	}
+	status, body, err := fetch(urlF)
 	if err != nil {
 
 		return
 	}
 
 	mime := http.DetectContentType(body)
+	if c.OnResponse != nil {
+
+		c.OnResponse(status, mime)
+	}
 	_, ok := AllowedMimeTypes[mime]
 	if !(ok) {
 
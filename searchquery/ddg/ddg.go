@@ -29,6 +29,10 @@ type Client struct {
 	Dial   func(network, addr string) (net.Conn, error) // Dialer to use
 	UrlApi string                                       // Url to duckduckgo api
 
+	// Gets the url instead of the http client when set, such as through a
+	// bot plugin host
+	Fetch func(url string) (status int, body []byte, err error)
+
 	UserAgent string // Useragent used in requests
 
 	Pretty             bool // Return pritty json
@@ -116,38 +120,50 @@ func (c *Client) Query(searchquery string) (r Result, err error) {
 		boi(c.NoHTML),
 		boi(c.SkipDisambiguation))
 
-	httpClient := &http.Client{Transport: &http.Transport{Dial: c.dial}}
-	req, err := http.NewRequest("GET", urlF, nil)
-	if err != nil {
+	// Start the query
+	fetch := c.Fetch
+	if fetch == nil {
 
-		return
+		fetch = c.get
 	}
-	if len(c.UserAgent) > 1 {
+	_, body, err := fetch(urlF)
+	if err != nil {
 
-		req.Header.Add("User-Agent", c.UserAgent)
+		return
 	}
 
-	// Start the query
-	resp, err := httpClient.Do(req)
+	err = json.Unmarshal(body, &r)
 	if err != nil {
 
 		return
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	return
+}
+
+func (c *Client) get(urlF string) (status int, body []byte, err error) {
+
+	httpClient := &http.Client{Transport: &http.Transport{Dial: c.dial}}
+	req, err := http.NewRequest("GET", urlF, nil)
 	if err != nil {
 
 		return
 	}
+	if len(c.UserAgent) > 1 {
 
-	err = json.Unmarshal(body, &r)
+		req.Header.Add("User-Agent", c.UserAgent)
+	}
+
+	resp, err := httpClient.Do(req)
 	if err != nil {
 
 		return
 	}
+	defer resp.Body.Close()
 
-	return
+	body, err = ioutil.ReadAll(resp.Body)
+
+	return resp.StatusCode, body, err
 }
 
 func (c *Client) FeelingLucky(searchuqery string) (typ string, text string, err error) {
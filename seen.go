@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/TheCreeper/HackBot/ircutil"
+	"github.com/TheCreeper/HackBot/store"
+	"github.com/sorcix/irc"
+)
+
+// Seen actions
+const (
+	SeenMessage  = "message"
+	SeenAction   = "action"
+	SeenJoin     = "join"
+	SeenPart     = "part"
+	SeenKick     = "kick"
+	SeenQuit     = "quit"
+	SeenNickTo   = "nick-to"   // Text is the new nick
+	SeenNickFrom = "nick-from" // Text is the old nick
+)
+
+// Number of nick changes followed by !seen
+const seenMaxHops = 3
+
+// Wildcard matches listed by !seen
+const seenMatches = 5
+
+// Record the last activity of every nick. Activity in private channels is
+// recorded without the channel or text.
+func recordSeen(db store.Store, network string, cc *ircutil.ClientConn) func(*irc.Message) {
+
+	return func(m *irc.Message) {
+
+		if m.Prefix == nil || len(m.Prefix.Name) < 1 {
+
+			return
+		}
+
+		first := m.Trailing
+		if len(m.Params) > 0 {
+
+			first = m.Params[0]
+		}
+
+		var records []store.Seen
+		add := func(nick, channel, action, text string) {
+
+			if len(channel) > 0 && isPrivate(network, channel) {
+
+				channel, text = "", ""
+			}
+			records = append(records, store.Seen{
+
+				Network: network,
+				Nick:    nick,
+				Channel: channel,
+				Action:  action,
+				Text:    text,
+				Time:    time.Now(),
+			})
+		}
+
+		nick := m.Prefix.Name
+		switch m.Command {
+
+		case irc.PRIVMSG:
+
+			if !isChannel(first) {
+
+				return
+			}
+			if strings.HasPrefix(m.Trailing, "\x01ACTION ") {
+
+				add(nick, first, SeenAction, strings.Trim(strings.TrimPrefix(m.Trailing, "\x01ACTION "), "\x01"))
+				break
+			}
+			add(nick, first, SeenMessage, m.Trailing)
+
+		case irc.JOIN:
+
+			add(nick, first, SeenJoin, "")
+
+		case irc.PART:
+
+			add(nick, first, SeenPart, m.Trailing)
+
+		case irc.KICK:
+
+			if len(m.Params) > 1 {
+
+				add(m.Params[1], m.Params[0], SeenKick, m.Trailing)
+			}
+
+		case irc.QUIT:
+
+			add(nick, "", SeenQuit, m.Trailing)
+
+		case irc.NICK:
+
+			add(nick, "", SeenNickTo, first)
+			add(first, "", SeenNickFrom, nick)
+
+		default:
+
+			return
+		}
+
+		for i := range records {
+
+			if err := db.SetSeen(&records[i]); err != nil {
+
+				slog.Error("store.SetSeen()", "network", network, "nick", records[i].Nick, "error", err)
+			}
+		}
+	}
+}
+
+func registerSeenCommands() {
+
+	Commands.Register(&Command{
+
+		Name:  "seen",
+		Usage: "!seen <nick|pattern*>",
+		Run:   runSeen,
+	})
+}
+
+// Describe what a nick was doing
+func describeSeen(s *store.Seen) string {
+
+	ago := formatDuration(time.Since(s.Time))
+	where := ""
+	if len(s.Channel) > 0 {
+
+		where = " in " + s.Channel
+	}
+
+	switch s.Action {
+
+	case SeenMessage:
+
+		if len(s.Text) < 1 {
+
+			return fmt.Sprintf("%s was last seen %s ago talking in a private channel", s.Nick, ago)
+		}
+		return fmt.Sprintf("%s was last seen %s ago%s saying: %s", s.Nick, ago, where, s.Text)
+
+	case SeenAction:
+
+		if len(s.Text) < 1 {
+
+			return fmt.Sprintf("%s was last seen %s ago talking in a private channel", s.Nick, ago)
+		}
+		return fmt.Sprintf("%s was last seen %s ago%s: * %s %s", s.Nick, ago, where, s.Nick, s.Text)
+
+	case SeenJoin:
+
+		return fmt.Sprintf("%s was last seen %s ago joining%s", s.Nick, ago, where)
+
+	case SeenPart:
+
+		return fmt.Sprintf("%s was last seen %s ago leaving%s%s", s.Nick, ago, where, reason(s.Text))
+
+	case SeenKick:
+
+		return fmt.Sprintf("%s was last seen %s ago being kicked from%s%s", s.Nick, ago, where, reason(s.Text))
+
+	case SeenQuit:
+
+		return fmt.Sprintf("%s was last seen %s ago quitting%s", s.Nick, ago, reason(s.Text))
+
+	case SeenNickTo:
+
+		return fmt.Sprintf("%s was last seen %s ago changing nick to %s", s.Nick, ago, s.Text)
+
+	case SeenNickFrom:
+
+		return fmt.Sprintf("%s was last seen %s ago changing nick from %s", s.Nick, ago, s.Text)
+	}
+
+	return fmt.Sprintf("%s was last seen %s ago", s.Nick, ago)
+}
+
+func reason(text string) string {
+
+	if len(text) < 1 {
+
+		return ""
+	}
+
+	return " (" + text + ")"
+}
+
+func runSeen(h *HandlerFuncs, m *irc.Message, args string) (err error) {
+
+	nick := strings.TrimSpace(args)
+	if len(nick) < 1 || strings.Contains(nick, " ") {
+
+		return h.Reply(m, "Usage: !seen <nick|pattern*>")
+	}
+
+	switch {
+
+	case strings.EqualFold(nick, m.Prefix.Name):
+
+		return h.Reply(m, "That's you")
+
+	case strings.EqualFold(nick, h.ClientConn.Nick):
+
+		return h.Reply(m, "I'm right here")
+	}
+
+	if strings.ContainsAny(nick, "*?") {
+
+		return seenPattern(h, m, nick)
+	}
+
+	// Someone in the channel right now
+	for _, c := range h.ClientConn.State.UserChannels(nick) {
+
+		if strings.EqualFold(c, replyTarget(m)) {
+
+			return h.Reply(m, fmt.Sprintf("%s is right here", nick))
+		}
+	}
+
+	// Follow nick changes
+	var parts []string
+	for i := 0; i < seenMaxHops; i++ {
+
+		s, err := DB.GetSeen(h.Name, nick)
+		if err == store.ErrNotFound {
+
+			break
+		}
+		if err != nil {
+
+			return err
+		}
+		parts = append(parts, describeSeen(s))
+		if s.Action != SeenNickTo {
+
+			break
+		}
+		nick = s.Text
+	}
+	if len(parts) < 1 {
+
+		return h.Reply(m, fmt.Sprintf("I haven't seen %s", nick))
+	}
+
+	return h.Reply(m, strings.Join(parts, "; "))
+}
+
+func seenPattern(h *HandlerFuncs, m *irc.Message, pattern string) (err error) {
+
+	seen, err := DB.FindSeen(h.Name, pattern, seenMatches)
+	if err != nil {
+
+		return
+	}
+	if len(seen) < 1 {
+
+		return h.Reply(m, fmt.Sprintf("No one matching %s", pattern))
+	}
+
+	text := describeSeen(&seen[0])
+	if len(seen) > 1 {
+
+		var others []string
+		for _, s := range seen[1:] {
+
+			others = append(others, fmt.Sprintf("%s (%s ago)", s.Nick, formatDuration(time.Since(s.Time))))
+		}
+		text += "; also matching: " + strings.Join(others, ", ")
+	}
+
+	return h.Reply(m, text)
+}
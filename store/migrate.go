@@ -0,0 +1,344 @@
+/*
+   Schema migrations are embedded from migrations/<dialect>/ and named
+   NNNN_name.up.sql and NNNN_name.down.sql. Applied versions are recorded
+   in the schema_version table.
+*/
+
+package store
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Errors
+var (
+	ErrMigrationName    = errors.New("Invalid migration file name")
+	ErrMigrationVersion = errors.New("Unknown schema version")
+	ErrNoDownMigration  = errors.New("Migration can not be rolled back")
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied time.Time // Zero when not applied
+}
+
+// A Migrator manages the schema of a store
+type Migrator interface {
+
+	// Apply all pending migrations
+	Migrate() error
+
+	// Migrate up or down to the version, 0 removes everything
+	MigrateTo(version int) error
+
+	// Current schema version
+	Version() (int, error)
+
+	MigrationStatus() ([]MigrationStatus, error)
+}
+
+// Read the migrations in dir, ordered by version
+func loadMigrations(dir string) (migrations []Migration, err error) {
+
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+
+		return
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+
+		name := e.Name()
+		var base string
+		var up bool
+		switch {
+
+		case strings.HasSuffix(name, ".up.sql"):
+
+			base, up = strings.TrimSuffix(name, ".up.sql"), true
+
+		case strings.HasSuffix(name, ".down.sql"):
+
+			base = strings.TrimSuffix(name, ".down.sql")
+
+		default:
+
+			continue
+		}
+
+		num, label, ok := strings.Cut(base, "_")
+		if !ok {
+
+			return nil, fmt.Errorf("%w: %s", ErrMigrationName, name)
+		}
+		version, err := strconv.Atoi(num)
+		if err != nil || version < 1 {
+
+			return nil, fmt.Errorf("%w: %s", ErrMigrationName, name)
+		}
+
+		b, err := fs.ReadFile(migrationFiles, path.Join(dir, name))
+		if err != nil {
+
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+		if up {
+
+			m.Up = string(b)
+		} else {
+
+			m.Down = string(b)
+		}
+	}
+
+	for _, m := range byVersion {
+
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return
+}
+
+// Split a migration into statements, each ending with a semicolon at the end
+// of a line. Comment lines are dropped.
+func splitStatements(script string) (stmts []string) {
+
+	var cur []string
+	for _, line := range strings.Split(script, "\n") {
+
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) < 1 || strings.HasPrefix(trimmed, "--") {
+
+			continue
+		}
+		cur = append(cur, line)
+		if strings.HasSuffix(trimmed, ";") {
+
+			stmts = append(stmts, strings.TrimSuffix(strings.TrimSpace(strings.Join(cur, "\n")), ";"))
+			cur = nil
+		}
+	}
+	if len(cur) > 0 {
+
+		stmts = append(stmts, strings.TrimSpace(strings.Join(cur, "\n")))
+	}
+
+	return
+}
+
+func (s *SQLStore) createVersionTable() (err error) {
+
+	_, err = s.db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied BIGINT NOT NULL)")
+	return
+}
+
+// Applied versions and when they were applied
+func (s *SQLStore) appliedVersions() (applied map[int]time.Time, err error) {
+
+	if err = s.createVersionTable(); err != nil {
+
+		return
+	}
+
+	rows, err := s.db.Query("SELECT version, applied FROM schema_version")
+	if err != nil {
+
+		return
+	}
+	defer rows.Close()
+
+	applied = make(map[int]time.Time)
+	for rows.Next() {
+
+		var version int
+		var t int64
+		if err = rows.Scan(&version, &t); err != nil {
+
+			return
+		}
+		applied[version] = time.Unix(t, 0)
+	}
+
+	return applied, rows.Err()
+}
+
+func (s *SQLStore) Version() (version int, err error) {
+
+	applied, err := s.appliedVersions()
+	if err != nil {
+
+		return
+	}
+	for v := range applied {
+
+		if v > version {
+
+			version = v
+		}
+	}
+
+	return
+}
+
+func (s *SQLStore) MigrationStatus() (status []MigrationStatus, err error) {
+
+	migrations, err := loadMigrations(s.dialect.migrations)
+	if err != nil {
+
+		return
+	}
+	applied, err := s.appliedVersions()
+	if err != nil {
+
+		return
+	}
+
+	for _, m := range migrations {
+
+		status = append(status, MigrationStatus{Migration: m, Applied: applied[m.Version]})
+	}
+
+	return
+}
+
+func (s *SQLStore) Migrate() error {
+
+	migrations, err := loadMigrations(s.dialect.migrations)
+	if err != nil {
+
+		return err
+	}
+	if len(migrations) < 1 {
+
+		return nil
+	}
+
+	return s.MigrateTo(migrations[len(migrations)-1].Version)
+}
+
+func (s *SQLStore) MigrateTo(version int) (err error) {
+
+	migrations, err := loadMigrations(s.dialect.migrations)
+	if err != nil {
+
+		return
+	}
+	applied, err := s.appliedVersions()
+	if err != nil {
+
+		return
+	}
+
+	known := version == 0
+	for _, m := range migrations {
+
+		if m.Version == version {
+
+			known = true
+		}
+	}
+	if !known {
+
+		return fmt.Errorf("%w: %d", ErrMigrationVersion, version)
+	}
+
+	// Up in ascending order
+	for _, m := range migrations {
+
+		if _, ok := applied[m.Version]; ok || m.Version > version {
+
+			continue
+		}
+		if err = s.apply(m, m.Up, true); err != nil {
+
+			return
+		}
+	}
+
+	// Down in descending order
+	for i := len(migrations) - 1; i >= 0; i-- {
+
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok || m.Version <= version {
+
+			continue
+		}
+		if len(strings.TrimSpace(m.Down)) < 1 {
+
+			return fmt.Errorf("%w: %04d_%s", ErrNoDownMigration, m.Version, m.Name)
+		}
+		if err = s.apply(m, m.Down, false); err != nil {
+
+			return
+		}
+	}
+
+	return
+}
+
+// Run one migration script and record it. MySQL commits implicitly on schema
+// changes so the transaction only protects SQLite.
+func (s *SQLStore) apply(m Migration, script string, up bool) (err error) {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+
+		return
+	}
+	defer func() {
+
+		if err != nil {
+
+			tx.Rollback()
+		}
+	}()
+
+	for _, stmt := range splitStatements(script) {
+
+		if _, err = tx.Exec(stmt); err != nil {
+
+			return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	if up {
+
+		_, err = tx.Exec("INSERT INTO schema_version (version, name, applied) VALUES (?, ?, ?)", m.Version, m.Name, time.Now().Unix())
+	} else {
+
+		_, err = tx.Exec("DELETE FROM schema_version WHERE version=?", m.Version)
+	}
+	if err != nil {
+
+		return
+	}
+
+	return tx.Commit()
+}
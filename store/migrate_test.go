@@ -0,0 +1,96 @@
+package store
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+
+	tests := []struct {
+		script string
+		stmts  []string
+	}{
+		{"", nil},
+		{"-- only a comment\n", nil},
+		{"CREATE TABLE a (x INT);", []string{"CREATE TABLE a (x INT)"}},
+		{"CREATE TABLE a (\n\tx INT\n);\n\n-- next\nDROP TABLE b;\n", []string{"CREATE TABLE a (\n\tx INT\n)", "DROP TABLE b"}},
+		{"INSERT INTO a VALUES (1)", []string{"INSERT INTO a VALUES (1)"}},
+	}
+
+	for _, test := range tests {
+
+		if stmts := splitStatements(test.script); !reflect.DeepEqual(stmts, test.stmts) {
+
+			t.Errorf("splitStatements(%q) = %q, want %q", test.script, stmts, test.stmts)
+		}
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+
+	for _, dir := range []string{"migrations/sqlite", "migrations/mysql"} {
+
+		migrations, err := loadMigrations(dir)
+		if err != nil {
+
+			t.Fatalf("loadMigrations(%q): %v", dir, err)
+		}
+		if len(migrations) < 1 {
+
+			t.Fatalf("loadMigrations(%q): no migrations", dir)
+		}
+		for i, m := range migrations {
+
+			if m.Version != i+1 {
+
+				t.Errorf("%s: migration %d has version %d", dir, i+1, m.Version)
+			}
+			if len(m.Up) < 1 || len(m.Down) < 1 {
+
+				t.Errorf("%s: migration %d %s is missing its up or down script", dir, m.Version, m.Name)
+			}
+		}
+	}
+}
+
+func TestMigrateSQLite(t *testing.T) {
+
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	migrations, err := loadMigrations(s.dialect.migrations)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	latest := migrations[len(migrations)-1].Version
+
+	steps := []int{latest, 0, 1, latest, latest}
+	for _, version := range steps {
+
+		if err = s.MigrateTo(version); err != nil {
+
+			t.Fatalf("MigrateTo(%d): %v", version, err)
+		}
+		got, err := s.Version()
+		if err != nil {
+
+			t.Fatal(err)
+		}
+		if got != version {
+
+			t.Fatalf("Version() after MigrateTo(%d) = %d", version, got)
+		}
+	}
+
+	if err = s.MigrateTo(latest + 1); err == nil {
+
+		t.Errorf("MigrateTo(%d) of an unknown version succeeded", latest+1)
+	}
+}
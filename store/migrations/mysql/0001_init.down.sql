@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS `messages`;
+DROP TABLE IF EXISTS `kv`;
+DROP TABLE IF EXISTS `seen`;
+DROP TABLE IF EXISTS `channels`;
+DROP TABLE IF EXISTS `users`;
@@ -0,0 +1,46 @@
+-- Statements end with a semicolon at the end of a line
+
+CREATE TABLE IF NOT EXISTS `users` (
+  `network` varchar(255) NOT NULL,
+  `nick` varchar(255) NOT NULL,
+  `host` varchar(255) NOT NULL DEFAULT '',
+  `admin` tinyint(1) NOT NULL DEFAULT 0,
+  `created` bigint NOT NULL,
+  PRIMARY KEY (`network`, `nick`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `channels` (
+  `network` varchar(255) NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `chankey` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`network`, `name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `seen` (
+  `network` varchar(255) NOT NULL,
+  `nick` varchar(255) NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `channel` varchar(255) NOT NULL DEFAULT '',
+  `action` varchar(32) NOT NULL,
+  `text` text NOT NULL,
+  `time` bigint NOT NULL,
+  PRIMARY KEY (`network`, `nick`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `kv` (
+  `namespace` varchar(255) NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `value` mediumblob NOT NULL,
+  PRIMARY KEY (`namespace`, `name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `messages` (
+  `id` bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `network` varchar(255) NOT NULL,
+  `channel` varchar(255) NOT NULL,
+  `nick` varchar(255) NOT NULL,
+  `text` text NOT NULL,
+  `time` bigint NOT NULL,
+  KEY `channel_time` (`network`, `channel`, `time`),
+  FULLTEXT KEY `text` (`text`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
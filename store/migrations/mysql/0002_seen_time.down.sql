@@ -0,0 +1 @@
+DROP INDEX `seen_time` ON `seen`;
@@ -0,0 +1 @@
+CREATE INDEX `seen_time` ON `seen` (`network`, `time`);
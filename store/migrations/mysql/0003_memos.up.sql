@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `memos` (
+  `id` bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `network` varchar(255) NOT NULL,
+  `sender` varchar(255) NOT NULL,
+  `recipient` varchar(255) NOT NULL,
+  `account` varchar(255) NOT NULL DEFAULT '',
+  `channel` varchar(255) NOT NULL DEFAULT '',
+  `text` text NOT NULL,
+  `created` bigint NOT NULL,
+  `expires` bigint NOT NULL,
+  KEY `memos_recipient` (`network`, `recipient`),
+  KEY `memos_account` (`network`, `account`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
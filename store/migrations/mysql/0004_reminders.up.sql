@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `reminders` (
+  `id` bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `network` varchar(255) NOT NULL,
+  `channel` varchar(255) NOT NULL DEFAULT '',
+  `sender` varchar(255) NOT NULL,
+  `target` varchar(255) NOT NULL,
+  `text` text NOT NULL,
+  `created` bigint NOT NULL,
+  `due` bigint NOT NULL,
+  KEY `reminders_due` (`due`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `factoid_history`;
+DROP TABLE IF EXISTS `factoids`;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS `factoids` (
+  `network` varchar(255) NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `text` text NOT NULL,
+  `redirect` varchar(255) NOT NULL DEFAULT '',
+  `locked` tinyint(1) NOT NULL DEFAULT 0,
+  `nick` varchar(255) NOT NULL,
+  `updated` bigint NOT NULL,
+  PRIMARY KEY (`network`, `name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `factoid_history` (
+  `id` bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `network` varchar(255) NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `action` varchar(32) NOT NULL,
+  `text` text NOT NULL,
+  `redirect` varchar(255) NOT NULL DEFAULT '',
+  `nick` varchar(255) NOT NULL,
+  `time` bigint NOT NULL,
+  KEY `factoid_history_name` (`network`, `name`, `time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `quotes` (
+  `id` bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `network` varchar(255) NOT NULL,
+  `channel` varchar(255) NOT NULL,
+  `nick` varchar(255) NOT NULL DEFAULT '',
+  `text` text NOT NULL,
+  `added_by` varchar(255) NOT NULL,
+  `time` bigint NOT NULL,
+  KEY `quotes_channel` (`network`, `channel`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
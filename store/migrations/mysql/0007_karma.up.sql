@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `karma` (
+  `network` varchar(255) NOT NULL,
+  `thing` varchar(255) NOT NULL,
+  `score` int NOT NULL DEFAULT 0,
+  `updated` bigint NOT NULL,
+  PRIMARY KEY (`network`, `thing`),
+  KEY `karma_score` (`network`, `score`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS `polls` (
+  `id` bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `network` varchar(255) NOT NULL,
+  `channel` varchar(255) NOT NULL,
+  `question` text NOT NULL,
+  `options` text NOT NULL,
+  `creator` varchar(255) NOT NULL,
+  `created` bigint NOT NULL,
+  `closes` bigint NOT NULL,
+  `closed` tinyint(1) NOT NULL DEFAULT 0,
+  KEY `polls_open` (`closed`, `closes`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `poll_votes` (
+  `poll_id` bigint NOT NULL,
+  `voter` varchar(255) NOT NULL,
+  `choice` int NOT NULL,
+  `time` bigint NOT NULL,
+  PRIMARY KEY (`poll_id`, `voter`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS messages_ad;
+DROP TRIGGER IF EXISTS messages_ai;
+DROP TABLE IF EXISTS messages_fts;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS kv;
+DROP TABLE IF EXISTS seen;
+DROP TABLE IF EXISTS channels;
+DROP TABLE IF EXISTS users;
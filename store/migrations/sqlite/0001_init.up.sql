@@ -0,0 +1,52 @@
+-- Statements end with a semicolon at the end of a line
+
+CREATE TABLE IF NOT EXISTS users (
+  network TEXT NOT NULL,
+  nick    TEXT NOT NULL,
+  host    TEXT NOT NULL DEFAULT '',
+  admin   INTEGER NOT NULL DEFAULT 0,
+  created INTEGER NOT NULL,
+  PRIMARY KEY (network, nick)
+);
+
+CREATE TABLE IF NOT EXISTS channels (
+  network TEXT NOT NULL,
+  name    TEXT NOT NULL,
+  chankey TEXT NOT NULL DEFAULT '',
+  PRIMARY KEY (network, name)
+);
+
+CREATE TABLE IF NOT EXISTS seen (
+  network TEXT NOT NULL,
+  nick    TEXT NOT NULL,
+  name    TEXT NOT NULL,
+  channel TEXT NOT NULL DEFAULT '',
+  action  TEXT NOT NULL,
+  text    TEXT NOT NULL DEFAULT '',
+  time    INTEGER NOT NULL,
+  PRIMARY KEY (network, nick)
+);
+
+CREATE TABLE IF NOT EXISTS kv (
+  namespace TEXT NOT NULL,
+  name      TEXT NOT NULL,
+  value     BLOB NOT NULL,
+  PRIMARY KEY (namespace, name)
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+  id      INTEGER PRIMARY KEY AUTOINCREMENT,
+  network TEXT NOT NULL,
+  channel TEXT NOT NULL,
+  nick    TEXT NOT NULL,
+  text    TEXT NOT NULL,
+  time    INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS messages_channel_time ON messages (network, channel, time);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(text, content='messages', content_rowid='id');
+
+CREATE TRIGGER IF NOT EXISTS messages_ai AFTER INSERT ON messages BEGIN INSERT INTO messages_fts (rowid, text) VALUES (new.id, new.text); END;
+
+CREATE TRIGGER IF NOT EXISTS messages_ad AFTER DELETE ON messages BEGIN INSERT INTO messages_fts (messages_fts, rowid, text) VALUES ('delete', old.id, old.text); END;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS seen_time;
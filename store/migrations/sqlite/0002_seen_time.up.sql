@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS seen_time ON seen (network, time);
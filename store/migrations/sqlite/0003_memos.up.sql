@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS memos (
+  id        INTEGER PRIMARY KEY AUTOINCREMENT,
+  network   TEXT NOT NULL,
+  sender    TEXT NOT NULL,
+  recipient TEXT NOT NULL,
+  account   TEXT NOT NULL DEFAULT '',
+  channel   TEXT NOT NULL DEFAULT '',
+  text      TEXT NOT NULL,
+  created   INTEGER NOT NULL,
+  expires   INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS memos_recipient ON memos (network, recipient);
+
+CREATE INDEX IF NOT EXISTS memos_account ON memos (network, account);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS reminders (
+  id      INTEGER PRIMARY KEY AUTOINCREMENT,
+  network TEXT NOT NULL,
+  channel TEXT NOT NULL DEFAULT '',
+  sender  TEXT NOT NULL,
+  target  TEXT NOT NULL,
+  text    TEXT NOT NULL,
+  created INTEGER NOT NULL,
+  due     INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS reminders_due ON reminders (due);
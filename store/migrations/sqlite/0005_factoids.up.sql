@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS factoids (
+  network  TEXT NOT NULL,
+  name     TEXT NOT NULL,
+  text     TEXT NOT NULL DEFAULT '',
+  redirect TEXT NOT NULL DEFAULT '',
+  locked   INTEGER NOT NULL DEFAULT 0,
+  nick     TEXT NOT NULL,
+  updated  INTEGER NOT NULL,
+  PRIMARY KEY (network, name)
+);
+
+CREATE TABLE IF NOT EXISTS factoid_history (
+  id       INTEGER PRIMARY KEY AUTOINCREMENT,
+  network  TEXT NOT NULL,
+  name     TEXT NOT NULL,
+  action   TEXT NOT NULL,
+  text     TEXT NOT NULL DEFAULT '',
+  redirect TEXT NOT NULL DEFAULT '',
+  nick     TEXT NOT NULL,
+  time     INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS factoid_history_name ON factoid_history (network, name, time);
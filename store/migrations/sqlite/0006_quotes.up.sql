@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS quotes (
+  id       INTEGER PRIMARY KEY AUTOINCREMENT,
+  network  TEXT NOT NULL,
+  channel  TEXT NOT NULL,
+  nick     TEXT NOT NULL DEFAULT '',
+  text     TEXT NOT NULL,
+  added_by TEXT NOT NULL,
+  time     INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS quotes_channel ON quotes (network, channel);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS karma (
+  network TEXT NOT NULL,
+  thing   TEXT NOT NULL,
+  score   INTEGER NOT NULL DEFAULT 0,
+  updated INTEGER NOT NULL,
+  PRIMARY KEY (network, thing)
+);
+
+CREATE INDEX IF NOT EXISTS karma_score ON karma (network, score);
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS polls (
+  id       INTEGER PRIMARY KEY AUTOINCREMENT,
+  network  TEXT NOT NULL,
+  channel  TEXT NOT NULL,
+  question TEXT NOT NULL,
+  options  TEXT NOT NULL,
+  creator  TEXT NOT NULL,
+  created  INTEGER NOT NULL,
+  closes   INTEGER NOT NULL,
+  closed   INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS polls_open ON polls (closed, closes);
+
+CREATE TABLE IF NOT EXISTS poll_votes (
+  poll_id INTEGER NOT NULL,
+  voter   TEXT NOT NULL,
+  choice  INTEGER NOT NULL,
+  time    INTEGER NOT NULL,
+  PRIMARY KEY (poll_id, voter)
+);
@@ -0,0 +1,36 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+var mysqlDialect = &dialect{
+
+	name:       "mysql",
+	migrations: "migrations/mysql",
+
+	// Every word has to appear
+	words: func(words string) (string, interface{}) {
+
+		var terms []string
+		for _, w := range strings.Fields(words) {
+
+			terms = append(terms, "+"+strings.Trim(w, `+-<>()~*"@`))
+		}
+		return "MATCH (text) AGAINST (? IN BOOLEAN MODE)", strings.Join(terms, " ")
+	},
+}
+
+// DSN for the MySQL driver
+func MySQLDSN(database, host, user, password string) string {
+
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s", user, password, host, database)
+}
+
+func OpenMySQL(dsn string) (*SQLStore, error) {
+
+	return openSQL("mysql", dsn, mysqlDialect)
+}
@@ -0,0 +1,1033 @@
+package store
+
+import (
+	"database/sql"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Rows scanned at most when filtering history by regular expression
+const regexpScanLimit = 5000
+
+// Differences between the SQL backends
+type dialect struct {
+	name       string
+	migrations string // Directory of the embedded migrations
+
+	// Condition and argument restricting messages to ones containing words
+	words func(words string) (cond string, arg interface{})
+}
+
+// SQLStore implements Store on database/sql
+type SQLStore struct {
+	db      *sql.DB
+	dialect *dialect
+}
+
+func openSQL(driver, dsn string, d *dialect) (s *SQLStore, err error) {
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+
+		return
+	}
+	if err = db.Ping(); err != nil {
+
+		db.Close()
+		return
+	}
+
+	return &SQLStore{db: db, dialect: d}, nil
+}
+
+func (s *SQLStore) Close() error {
+
+	return s.db.Close()
+}
+
+// DB returns the underlying database.
+func (s *SQLStore) DB() *sql.DB {
+
+	return s.db
+}
+
+func boolInt(b bool) int {
+
+	if b {
+
+		return 1
+	}
+
+	return 0
+}
+
+/*
+   Users
+*/
+
+func (s *SQLStore) GetUser(network, nick string) (u *User, err error) {
+
+	u = &User{Network: network}
+	var admin int
+	var created int64
+	err = s.db.QueryRow("SELECT nick, host, admin, created FROM users WHERE network=? AND nick=?",
+		network, strings.ToLower(nick)).Scan(&u.Nick, &u.Host, &admin, &created)
+	if err == sql.ErrNoRows {
+
+		return nil, ErrNotFound
+	}
+	if err != nil {
+
+		return nil, err
+	}
+	u.Admin = admin != 0
+	u.Created = time.Unix(created, 0)
+
+	return
+}
+
+func (s *SQLStore) SaveUser(u *User) (err error) {
+
+	if u.Created.IsZero() {
+
+		u.Created = time.Now()
+	}
+
+	_, err = s.db.Exec("REPLACE INTO users (network, nick, host, admin, created) VALUES (?, ?, ?, ?, ?)",
+		u.Network, strings.ToLower(u.Nick), u.Host, boolInt(u.Admin), u.Created.Unix())
+	return
+}
+
+func (s *SQLStore) DeleteUser(network, nick string) (err error) {
+
+	_, err = s.db.Exec("DELETE FROM users WHERE network=? AND nick=?", network, strings.ToLower(nick))
+	return
+}
+
+/*
+   Channels
+*/
+
+func (s *SQLStore) Channels(network string) (channels []Channel, err error) {
+
+	rows, err := s.db.Query("SELECT name, chankey FROM channels WHERE network=? ORDER BY name", network)
+	if err != nil {
+
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		c := Channel{Network: network}
+		if err = rows.Scan(&c.Name, &c.Key); err != nil {
+
+			return
+		}
+		channels = append(channels, c)
+	}
+
+	return channels, rows.Err()
+}
+
+func (s *SQLStore) AddChannel(c *Channel) (err error) {
+
+	_, err = s.db.Exec("REPLACE INTO channels (network, name, chankey) VALUES (?, ?, ?)",
+		c.Network, strings.ToLower(c.Name), c.Key)
+	return
+}
+
+func (s *SQLStore) RemoveChannel(network, name string) (err error) {
+
+	_, err = s.db.Exec("DELETE FROM channels WHERE network=? AND name=?", network, strings.ToLower(name))
+	return
+}
+
+/*
+   Seen data
+*/
+
+func (s *SQLStore) GetSeen(network, nick string) (seen *Seen, err error) {
+
+	seen = &Seen{Network: network}
+	var t int64
+	err = s.db.QueryRow("SELECT name, channel, action, text, time FROM seen WHERE network=? AND nick=?",
+		network, strings.ToLower(nick)).Scan(&seen.Nick, &seen.Channel, &seen.Action, &seen.Text, &t)
+	if err == sql.ErrNoRows {
+
+		return nil, ErrNotFound
+	}
+	if err != nil {
+
+		return nil, err
+	}
+	seen.Time = time.Unix(t, 0)
+
+	return
+}
+
+func (s *SQLStore) SetSeen(seen *Seen) (err error) {
+
+	_, err = s.db.Exec("REPLACE INTO seen (network, nick, name, channel, action, text, time) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		seen.Network, strings.ToLower(seen.Nick), seen.Nick, seen.Channel, seen.Action, seen.Text, seen.Time.Unix())
+	return
+}
+
+// Convert a wildcard pattern into a LIKE pattern escaped with !, as the
+// backends disagree on backslashes in string literals
+func likePattern(pattern string) string {
+
+	r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_", "*", "%", "?", "_")
+	return r.Replace(pattern)
+}
+
+func (s *SQLStore) FindSeen(network, pattern string, limit int) (seen []Seen, err error) {
+
+	rows, err := s.db.Query(`SELECT name, channel, action, text, time FROM seen
+		WHERE network=? AND nick LIKE ? ESCAPE '!' ORDER BY time DESC LIMIT ?`,
+		network, likePattern(strings.ToLower(pattern)), limit)
+	if err != nil {
+
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		sn := Seen{Network: network}
+		var t int64
+		if err = rows.Scan(&sn.Nick, &sn.Channel, &sn.Action, &sn.Text, &t); err != nil {
+
+			return
+		}
+		sn.Time = time.Unix(t, 0)
+		seen = append(seen, sn)
+	}
+
+	return seen, rows.Err()
+}
+
+/*
+   Memos
+*/
+
+func (s *SQLStore) AddMemo(m *Memo) (err error) {
+
+	r, err := s.db.Exec(`INSERT INTO memos (network, sender, recipient, account, channel, text, created, expires)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.Network, m.Sender, strings.ToLower(m.Recipient), m.Account, m.Channel, m.Text, m.Created.Unix(), m.Expires.Unix())
+	if err != nil {
+
+		return
+	}
+	m.ID, err = r.LastInsertId()
+
+	return
+}
+
+func (s *SQLStore) DeleteMemo(network string, id int64) (err error) {
+
+	_, err = s.db.Exec("DELETE FROM memos WHERE network=? AND id=?", network, id)
+	return
+}
+
+func (s *SQLStore) queryMemos(where string, args ...interface{}) (memos []Memo, err error) {
+
+	rows, err := s.db.Query(`SELECT id, network, sender, recipient, account, channel, text, created, expires
+		FROM memos WHERE `+where+" ORDER BY created, id", args...)
+	if err != nil {
+
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var m Memo
+		var created, expires int64
+		if err = rows.Scan(&m.ID, &m.Network, &m.Sender, &m.Recipient, &m.Account, &m.Channel, &m.Text, &created, &expires); err != nil {
+
+			return
+		}
+		m.Created = time.Unix(created, 0)
+		m.Expires = time.Unix(expires, 0)
+		memos = append(memos, m)
+	}
+
+	return memos, rows.Err()
+}
+
+func (s *SQLStore) PendingMemos(network, nick, account string) ([]Memo, error) {
+
+	now := time.Now().Unix()
+	if len(account) > 0 {
+
+		return s.queryMemos("network=? AND (recipient=? OR account=?) AND expires>?",
+			network, strings.ToLower(nick), account, now)
+	}
+
+	return s.queryMemos("network=? AND recipient=? AND expires>?", network, strings.ToLower(nick), now)
+}
+
+func (s *SQLStore) SentMemos(network, sender string) ([]Memo, error) {
+
+	return s.queryMemos("network=? AND LOWER(sender)=? AND expires>?",
+		network, strings.ToLower(sender), time.Now().Unix())
+}
+
+func (s *SQLStore) MemoRecipients(network string) (nicks, accounts []string, err error) {
+
+	rows, err := s.db.Query("SELECT DISTINCT recipient, account FROM memos WHERE network=? AND expires>?",
+		network, time.Now().Unix())
+	if err != nil {
+
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var nick, account string
+		if err = rows.Scan(&nick, &account); err != nil {
+
+			return
+		}
+		nicks = append(nicks, nick)
+		if len(account) > 0 {
+
+			accounts = append(accounts, account)
+		}
+	}
+
+	return nicks, accounts, rows.Err()
+}
+
+func (s *SQLStore) ExpireMemos(t time.Time) (err error) {
+
+	_, err = s.db.Exec("DELETE FROM memos WHERE expires<=?", t.Unix())
+	return
+}
+
+/*
+   Reminders
+*/
+
+func (s *SQLStore) AddReminder(r *Reminder) (err error) {
+
+	res, err := s.db.Exec(`INSERT INTO reminders (network, channel, sender, target, text, created, due)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		r.Network, r.Channel, r.Sender, r.Target, r.Text, r.Created.Unix(), r.Due.Unix())
+	if err != nil {
+
+		return
+	}
+	r.ID, err = res.LastInsertId()
+
+	return
+}
+
+func (s *SQLStore) DeleteReminder(id int64) (err error) {
+
+	_, err = s.db.Exec("DELETE FROM reminders WHERE id=?", id)
+	return
+}
+
+func (s *SQLStore) CountReminders(network, sender string) (n int, err error) {
+
+	err = s.db.QueryRow("SELECT COUNT(*) FROM reminders WHERE network=? AND LOWER(sender)=?",
+		network, strings.ToLower(sender)).Scan(&n)
+	return
+}
+
+func (s *SQLStore) DueReminders(t time.Time) (reminders []Reminder, err error) {
+
+	rows, err := s.db.Query(`SELECT id, network, channel, sender, target, text, created, due
+		FROM reminders WHERE due<=? ORDER BY due, id`, t.Unix())
+	if err != nil {
+
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var r Reminder
+		var created, due int64
+		if err = rows.Scan(&r.ID, &r.Network, &r.Channel, &r.Sender, &r.Target, &r.Text, &created, &due); err != nil {
+
+			return
+		}
+		r.Created = time.Unix(created, 0)
+		r.Due = time.Unix(due, 0)
+		reminders = append(reminders, r)
+	}
+
+	return reminders, rows.Err()
+}
+
+/*
+   Factoids
+*/
+
+func (s *SQLStore) GetFactoid(network, name string) (f *Factoid, err error) {
+
+	f = &Factoid{Network: network}
+	var locked int
+	var updated int64
+	err = s.db.QueryRow("SELECT name, text, redirect, locked, nick, updated FROM factoids WHERE network=? AND name=?",
+		network, strings.ToLower(name)).Scan(&f.Name, &f.Text, &f.Redirect, &locked, &f.Nick, &updated)
+	if err == sql.ErrNoRows {
+
+		return nil, ErrNotFound
+	}
+	if err != nil {
+
+		return nil, err
+	}
+	f.Locked = locked != 0
+	f.Updated = time.Unix(updated, 0)
+
+	return
+}
+
+func (s *SQLStore) SaveFactoid(f *Factoid, action string) (err error) {
+
+	if f.Updated.IsZero() {
+
+		f.Updated = time.Now()
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+
+		return
+	}
+	defer func() {
+
+		if err != nil {
+
+			tx.Rollback()
+		}
+	}()
+
+	_, err = tx.Exec("REPLACE INTO factoids (network, name, text, redirect, locked, nick, updated) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		f.Network, strings.ToLower(f.Name), f.Text, strings.ToLower(f.Redirect), boolInt(f.Locked), f.Nick, f.Updated.Unix())
+	if err != nil {
+
+		return
+	}
+	_, err = tx.Exec("INSERT INTO factoid_history (network, name, action, text, redirect, nick, time) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		f.Network, strings.ToLower(f.Name), action, f.Text, strings.ToLower(f.Redirect), f.Nick, f.Updated.Unix())
+	if err != nil {
+
+		return
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLStore) DeleteFactoid(network, name, nick string) (err error) {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+
+		return
+	}
+	defer func() {
+
+		if err != nil {
+
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM factoids WHERE network=? AND name=?", network, strings.ToLower(name)); err != nil {
+
+		return
+	}
+	_, err = tx.Exec("INSERT INTO factoid_history (network, name, action, nick, time) VALUES (?, ?, ?, ?, ?)",
+		network, strings.ToLower(name), "forget", nick, time.Now().Unix())
+	if err != nil {
+
+		return
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLStore) FactoidHistory(network, name string, limit int) (edits []FactoidEdit, err error) {
+
+	rows, err := s.db.Query(`SELECT action, text, redirect, nick, time FROM factoid_history
+		WHERE network=? AND name=? ORDER BY time DESC, id DESC LIMIT ?`, network, strings.ToLower(name), limit)
+	if err != nil {
+
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var e FactoidEdit
+		var t int64
+		if err = rows.Scan(&e.Action, &e.Text, &e.Redirect, &e.Nick, &t); err != nil {
+
+			return
+		}
+		e.Time = time.Unix(t, 0)
+		edits = append(edits, e)
+	}
+
+	return edits, rows.Err()
+}
+
+/*
+   Quotes
+*/
+
+const quoteColumns = "id, nick, text, added_by, time"
+
+func scanQuote(row interface{ Scan(...interface{}) error }, network, channel string) (q Quote, err error) {
+
+	q = Quote{Network: network, Channel: channel}
+	var t int64
+	if err = row.Scan(&q.ID, &q.Nick, &q.Text, &q.AddedBy, &t); err != nil {
+
+		return
+	}
+	q.Time = time.Unix(t, 0)
+
+	return
+}
+
+func (s *SQLStore) queryQuotes(network, channel, query string, args ...interface{}) (quotes []Quote, err error) {
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		q, err := scanQuote(rows, network, channel)
+		if err != nil {
+
+			return nil, err
+		}
+		quotes = append(quotes, q)
+	}
+
+	return quotes, rows.Err()
+}
+
+func (s *SQLStore) AddQuote(q *Quote) (err error) {
+
+	if q.Time.IsZero() {
+
+		q.Time = time.Now()
+	}
+
+	r, err := s.db.Exec("INSERT INTO quotes (network, channel, nick, text, added_by, time) VALUES (?, ?, ?, ?, ?, ?)",
+		q.Network, strings.ToLower(q.Channel), q.Nick, q.Text, q.AddedBy, q.Time.Unix())
+	if err != nil {
+
+		return
+	}
+	q.ID, err = r.LastInsertId()
+
+	return
+}
+
+func (s *SQLStore) GetQuote(network, channel string, id int64) (q *Quote, err error) {
+
+	row := s.db.QueryRow("SELECT "+quoteColumns+" FROM quotes WHERE network=? AND channel=? AND id=?",
+		network, strings.ToLower(channel), id)
+	quote, err := scanQuote(row, network, channel)
+	if err == sql.ErrNoRows {
+
+		return nil, ErrNotFound
+	}
+	if err != nil {
+
+		return nil, err
+	}
+
+	return &quote, nil
+}
+
+func (s *SQLStore) DeleteQuote(network, channel string, id int64) (err error) {
+
+	r, err := s.db.Exec("DELETE FROM quotes WHERE network=? AND channel=? AND id=?", network, strings.ToLower(channel), id)
+	if err != nil {
+
+		return
+	}
+	if n, err := r.RowsAffected(); err == nil && n < 1 {
+
+		return ErrNotFound
+	}
+
+	return
+}
+
+// RandomQuote picks an offset rather than ordering by random, which is
+// spelled differently by every backend.
+func (s *SQLStore) RandomQuote(network, channel, nick string) (q *Quote, err error) {
+
+	where := "network=? AND channel=?"
+	args := []interface{}{network, strings.ToLower(channel)}
+	if len(nick) > 0 {
+
+		where += " AND LOWER(nick)=?"
+		args = append(args, strings.ToLower(nick))
+	}
+
+	var n int64
+	if err = s.db.QueryRow("SELECT COUNT(*) FROM quotes WHERE "+where, args...).Scan(&n); err != nil {
+
+		return
+	}
+	if n < 1 {
+
+		return nil, ErrNotFound
+	}
+
+	args = append(args, rand.Int63n(n))
+	row := s.db.QueryRow("SELECT "+quoteColumns+" FROM quotes WHERE "+where+" ORDER BY id LIMIT 1 OFFSET ?", args...)
+	quote, err := scanQuote(row, network, channel)
+	if err != nil {
+
+		return nil, err
+	}
+
+	return &quote, nil
+}
+
+func (s *SQLStore) SearchQuotes(network, channel, words string, limit int) (quotes []Quote, err error) {
+
+	where := []string{"network=?", "channel=?"}
+	args := []interface{}{network, strings.ToLower(channel)}
+	for _, w := range strings.Fields(strings.ToLower(words)) {
+
+		where = append(where, "(LOWER(text) LIKE ? ESCAPE '!' OR LOWER(nick) LIKE ? ESCAPE '!')")
+		like := "%" + likePattern(w) + "%"
+		args = append(args, like, like)
+	}
+	args = append(args, limit)
+
+	return s.queryQuotes(network, channel, "SELECT "+quoteColumns+" FROM quotes WHERE "+
+		strings.Join(where, " AND ")+" ORDER BY id DESC LIMIT ?", args...)
+}
+
+func (s *SQLStore) Quotes(network, channel string) ([]Quote, error) {
+
+	return s.queryQuotes(network, channel, "SELECT "+quoteColumns+" FROM quotes WHERE network=? AND channel=? ORDER BY id",
+		network, strings.ToLower(channel))
+}
+
+/*
+   Karma
+*/
+
+func (s *SQLStore) AddKarma(network, thing string, delta int) (err error) {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+
+		return
+	}
+	defer func() {
+
+		if err != nil {
+
+			tx.Rollback()
+		}
+	}()
+
+	now := time.Now().Unix()
+	thing = strings.ToLower(thing)
+	r, err := tx.Exec("UPDATE karma SET score=score+?, updated=? WHERE network=? AND thing=?", delta, now, network, thing)
+	if err != nil {
+
+		return
+	}
+	if n, err := r.RowsAffected(); err != nil {
+
+		return err
+	} else if n < 1 {
+
+		_, err = tx.Exec("INSERT INTO karma (network, thing, score, updated) VALUES (?, ?, ?, ?)", network, thing, delta, now)
+		if err != nil {
+
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLStore) GetKarma(network, thing string) (score int, err error) {
+
+	err = s.db.QueryRow("SELECT score FROM karma WHERE network=? AND thing=?", network, strings.ToLower(thing)).Scan(&score)
+	if err == sql.ErrNoRows {
+
+		return 0, nil
+	}
+
+	return
+}
+
+func (s *SQLStore) TopKarma(network string, limit int, ascending bool) (karma []Karma, err error) {
+
+	order := "DESC"
+	if ascending {
+
+		order = "ASC"
+	}
+
+	rows, err := s.db.Query("SELECT thing, score, updated FROM karma WHERE network=? AND score<>0 ORDER BY score "+order+", thing LIMIT ?",
+		network, limit)
+	if err != nil {
+
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		k := Karma{Network: network}
+		var t int64
+		if err = rows.Scan(&k.Thing, &k.Score, &t); err != nil {
+
+			return
+		}
+		k.Updated = time.Unix(t, 0)
+		karma = append(karma, k)
+	}
+
+	return karma, rows.Err()
+}
+
+/*
+   Polls
+*/
+
+const pollColumns = "id, network, channel, question, options, creator, created, closes"
+
+func scanPoll(row interface{ Scan(...interface{}) error }) (p Poll, err error) {
+
+	var options string
+	var created, closes int64
+	if err = row.Scan(&p.ID, &p.Network, &p.Channel, &p.Question, &options, &p.Creator, &created, &closes); err != nil {
+
+		return
+	}
+	p.Options = strings.Split(options, "\n")
+	p.Created = time.Unix(created, 0)
+	p.Closes = time.Unix(closes, 0)
+
+	return
+}
+
+// Options are stored one per line, IRC messages can't contain newlines
+func (s *SQLStore) AddPoll(p *Poll) (err error) {
+
+	if p.Created.IsZero() {
+
+		p.Created = time.Now()
+	}
+
+	r, err := s.db.Exec("INSERT INTO polls (network, channel, question, options, creator, created, closes) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		p.Network, strings.ToLower(p.Channel), p.Question, strings.Join(p.Options, "\n"), p.Creator, p.Created.Unix(), p.Closes.Unix())
+	if err != nil {
+
+		return
+	}
+	p.ID, err = r.LastInsertId()
+
+	return
+}
+
+func (s *SQLStore) OpenPoll(network, channel string) (p *Poll, err error) {
+
+	row := s.db.QueryRow("SELECT "+pollColumns+" FROM polls WHERE network=? AND channel=? AND closed=0 ORDER BY id DESC LIMIT 1",
+		network, strings.ToLower(channel))
+	poll, err := scanPoll(row)
+	if err == sql.ErrNoRows {
+
+		return nil, ErrNotFound
+	}
+	if err != nil {
+
+		return nil, err
+	}
+
+	return &poll, nil
+}
+
+func (s *SQLStore) ClosePoll(id int64) (closed bool, err error) {
+
+	r, err := s.db.Exec("UPDATE polls SET closed=1 WHERE id=? AND closed=0", id)
+	if err != nil {
+
+		return
+	}
+	n, err := r.RowsAffected()
+
+	return n > 0, err
+}
+
+func (s *SQLStore) DuePolls(t time.Time) (polls []Poll, err error) {
+
+	rows, err := s.db.Query("SELECT "+pollColumns+" FROM polls WHERE closed=0 AND closes<=? ORDER BY closes", t.Unix())
+	if err != nil {
+
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		p, err := scanPoll(rows)
+		if err != nil {
+
+			return nil, err
+		}
+		polls = append(polls, p)
+	}
+
+	return polls, rows.Err()
+}
+
+func (s *SQLStore) Vote(pollID int64, voter string, choice int) (err error) {
+
+	_, err = s.db.Exec("REPLACE INTO poll_votes (poll_id, voter, choice, time) VALUES (?, ?, ?, ?)",
+		pollID, strings.ToLower(voter), choice, time.Now().Unix())
+	return
+}
+
+func (s *SQLStore) PollResults(p *Poll) (counts []int, err error) {
+
+	rows, err := s.db.Query("SELECT choice, COUNT(*) FROM poll_votes WHERE poll_id=? GROUP BY choice", p.ID)
+	if err != nil {
+
+		return
+	}
+	defer rows.Close()
+
+	counts = make([]int, len(p.Options))
+	for rows.Next() {
+
+		var choice, n int
+		if err = rows.Scan(&choice, &n); err != nil {
+
+			return
+		}
+		if choice >= 0 && choice < len(counts) {
+
+			counts[choice] = n
+		}
+	}
+
+	return counts, rows.Err()
+}
+
+/*
+   Key/value data
+*/
+
+func (s *SQLStore) Get(namespace, key string) (value []byte, err error) {
+
+	err = s.db.QueryRow("SELECT value FROM kv WHERE namespace=? AND name=?", namespace, key).Scan(&value)
+	if err == sql.ErrNoRows {
+
+		return nil, ErrNotFound
+	}
+
+	return
+}
+
+func (s *SQLStore) Set(namespace, key string, value []byte) (err error) {
+
+	_, err = s.db.Exec("REPLACE INTO kv (namespace, name, value) VALUES (?, ?, ?)", namespace, key, value)
+	return
+}
+
+func (s *SQLStore) Delete(namespace, key string) (err error) {
+
+	_, err = s.db.Exec("DELETE FROM kv WHERE namespace=? AND name=?", namespace, key)
+	return
+}
+
+func (s *SQLStore) Keys(namespace string) (keys []string, err error) {
+
+	rows, err := s.db.Query("SELECT name FROM kv WHERE namespace=? ORDER BY name", namespace)
+	if err != nil {
+
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var key string
+		if err = rows.Scan(&key); err != nil {
+
+			return
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *SQLStore) NamespaceSize(namespace string) (keys int, size int64, err error) {
+
+	err = s.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(LENGTH(name) + LENGTH(value)), 0) FROM kv WHERE namespace=?",
+		namespace).Scan(&keys, &size)
+	return
+}
+
+func (s *SQLStore) Entries(namespace string) (entries []Entry, err error) {
+
+	rows, err := s.db.Query("SELECT name, value FROM kv WHERE namespace=? ORDER BY name", namespace)
+	if err != nil {
+
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var e Entry
+		if err = rows.Scan(&e.Key, &e.Value); err != nil {
+
+			return
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+func (s *SQLStore) ReplaceEntries(namespace string, entries []Entry) (err error) {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+
+		return
+	}
+	defer func() {
+
+		if err != nil {
+
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM kv WHERE namespace=?", namespace); err != nil {
+
+		return
+	}
+	for _, e := range entries {
+
+		_, err = tx.Exec("INSERT INTO kv (namespace, name, value) VALUES (?, ?, ?)", namespace, e.Key, e.Value)
+		if err != nil {
+
+			return
+		}
+	}
+
+	return tx.Commit()
+}
+
+/*
+   Channel history
+*/
+
+func (s *SQLStore) AddMessage(m *Message) (err error) {
+
+	_, err = s.db.Exec("INSERT INTO messages (network, channel, nick, text, time) VALUES (?, ?, ?, ?, ?)",
+		m.Network, strings.ToLower(m.Channel), m.Nick, m.Text, m.Time.Unix())
+	return
+}
+
+// SearchMessages returns the newest matching messages of one channel first.
+// Regular expressions are matched here rather than in the database as not
+// every backend supports them.
+func (s *SQLStore) SearchMessages(network, channel string, q MessageQuery) (msgs []Message, err error) {
+
+	var re *regexp.Regexp
+	if len(q.Regexp) > 0 {
+
+		if re, err = regexp.Compile(q.Regexp); err != nil {
+
+			return
+		}
+	}
+	if q.Limit < 1 {
+
+		q.Limit = 1
+	}
+
+	where := []string{"network=?", "channel=?"}
+	args := []interface{}{network, strings.ToLower(channel)}
+	if len(q.Words) > 0 {
+
+		cond, arg := s.dialect.words(q.Words)
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if len(q.Nick) > 0 {
+
+		where = append(where, "LOWER(nick)=?")
+		args = append(args, strings.ToLower(q.Nick))
+	}
+	if !q.Since.IsZero() {
+
+		where = append(where, "time>=?")
+		args = append(args, q.Since.Unix())
+	}
+
+	limit := q.Limit
+	if re != nil {
+
+		limit = regexpScanLimit
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.Query("SELECT channel, nick, text, time FROM messages WHERE "+
+		strings.Join(where, " AND ")+" ORDER BY time DESC, id DESC LIMIT ?", args...)
+	if err != nil {
+
+		return
+	}
+	defer rows.Close()
+
+	for len(msgs) < q.Limit && rows.Next() {
+
+		m := Message{Network: network}
+		var t int64
+		if err = rows.Scan(&m.Channel, &m.Nick, &m.Text, &t); err != nil {
+
+			return
+		}
+		if re != nil && !re.MatchString(m.Text) {
+
+			continue
+		}
+		m.Time = time.Unix(t, 0)
+		msgs = append(msgs, m)
+	}
+
+	return msgs, rows.Err()
+}
+
+func (s *SQLStore) PruneMessages(t time.Time) (err error) {
+
+	_, err = s.db.Exec("DELETE FROM messages WHERE time<?", t.Unix())
+	return
+}
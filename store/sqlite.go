@@ -0,0 +1,44 @@
+package store
+
+import (
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+var sqliteDialect = &dialect{
+
+	name:       "sqlite",
+	migrations: "migrations/sqlite",
+
+	// Every word has to appear, quoted so they are not read as FTS5 syntax
+	words: func(words string) (string, interface{}) {
+
+		var terms []string
+		for _, w := range strings.Fields(words) {
+
+			terms = append(terms, `"`+strings.ReplaceAll(w, `"`, `""`)+`"`)
+		}
+		return "id IN (SELECT rowid FROM messages_fts WHERE messages_fts MATCH ?)", strings.Join(terms, " ")
+	},
+}
+
+// Open the SQLite database file at path, created if it does not exist.
+func OpenSQLite(path string) (*SQLStore, error) {
+
+	if len(path) < 1 {
+
+		path = "hackbot.db"
+	}
+
+	s, err := openSQL("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", sqliteDialect)
+	if err != nil {
+
+		return nil, err
+	}
+
+	// SQLite allows one writer at a time
+	s.db.SetMaxOpenConns(1)
+
+	return s, nil
+}
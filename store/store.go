@@ -0,0 +1,272 @@
+/*
+   Persistent storage for the bot. SQLite is the default backend, MySQL
+   is kept for installations that already run a server.
+*/
+
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// Errors
+var (
+	ErrNotFound = errors.New("Not found")
+	ErrDriver   = errors.New("Unknown database driver")
+)
+
+// A User known to the bot
+type User struct {
+	Network string
+	Nick    string
+	Host    string // user@host last seen for the nick
+	Admin   bool
+	Created time.Time
+}
+
+// A Channel the bot joins on connect
+type Channel struct {
+	Network string
+	Name    string
+	Key     string
+}
+
+// Last activity of a nick
+type Seen struct {
+	Network string
+	Nick    string
+	Channel string
+	Action  string // message, join, part, quit, nick, ...
+	Text    string
+	Time    time.Time
+}
+
+// A channel Message kept for searching
+type Message struct {
+	Network string
+	Channel string
+	Nick    string
+	Text    string
+	Time    time.Time
+}
+
+// Search parameters, empty fields are not filtered on
+type MessageQuery struct {
+	Words  string // Full text search
+	Regexp string // Regular expression on the text
+	Nick   string
+	Since  time.Time
+	Limit  int
+}
+
+// A Memo left for someone with !tell
+type Memo struct {
+	ID        int64
+	Network   string
+	Sender    string
+	Recipient string // Nick
+	Account   string // Services account of the recipient, when known
+	Channel   string // Where it was left
+	Text      string
+	Created   time.Time
+	Expires   time.Time
+}
+
+// A Reminder set with !remind
+type Reminder struct {
+	ID      int64
+	Network string
+	Channel string // Where to deliver it, the target privately when empty
+	Sender  string
+	Target  string // Nick to remind
+	Text    string
+	Created time.Time
+	Due     time.Time
+}
+
+// A Factoid in the knowledge base
+type Factoid struct {
+	Network  string
+	Name     string
+	Text     string
+	Redirect string // Name of the factoid this one is an alias of
+	Locked   bool
+	Nick     string // Last editor
+	Updated  time.Time
+}
+
+// An edit of a factoid
+type FactoidEdit struct {
+	Action   string // learn, append, forget, lock, unlock, alias
+	Text     string
+	Redirect string
+	Nick     string
+	Time     time.Time
+}
+
+// A Quote kept for a channel
+type Quote struct {
+	ID      int64
+	Network string
+	Channel string
+	Nick    string // Who said it, empty when added as free text
+	Text    string
+	AddedBy string
+	Time    time.Time
+}
+
+// Karma of a thing
+type Karma struct {
+	Network string
+	Thing   string
+	Score   int
+	Updated time.Time
+}
+
+// A Poll in a channel
+type Poll struct {
+	ID       int64
+	Network  string
+	Channel  string
+	Question string
+	Options  []string
+	Creator  string
+	Created  time.Time
+	Closes   time.Time
+}
+
+// A key/value Entry
+type Entry struct {
+	Key   string
+	Value []byte
+}
+
+type Store interface {
+
+	// Users
+	GetUser(network, nick string) (*User, error)
+	SaveUser(u *User) error
+	DeleteUser(network, nick string) error
+
+	// Channels
+	Channels(network string) ([]Channel, error)
+	AddChannel(c *Channel) error
+	RemoveChannel(network, name string) error
+
+	// Seen data
+	GetSeen(network, nick string) (*Seen, error)
+	SetSeen(s *Seen) error
+
+	// Nicks matching a pattern with * and ? wildcards, most recent first
+	FindSeen(network, pattern string, limit int) ([]Seen, error)
+
+	// Memos
+	AddMemo(m *Memo) error
+	DeleteMemo(network string, id int64) error
+
+	// Unexpired memos for a nick or account, oldest first
+	PendingMemos(network, nick, account string) ([]Memo, error)
+
+	// Unexpired memos left by a nick, oldest first
+	SentMemos(network, sender string) ([]Memo, error)
+
+	// Recipients and accounts with unexpired memos
+	MemoRecipients(network string) (nicks, accounts []string, err error)
+
+	// Remove memos that expired before t
+	ExpireMemos(t time.Time) error
+
+	// Reminders
+	AddReminder(r *Reminder) error
+	DeleteReminder(id int64) error
+
+	// Reminders set by a nick and not delivered yet
+	CountReminders(network, sender string) (int, error)
+
+	// Reminders due at t or earlier, oldest first
+	DueReminders(t time.Time) ([]Reminder, error)
+
+	// Factoids, changes are recorded in the history with the action
+	GetFactoid(network, name string) (*Factoid, error)
+	SaveFactoid(f *Factoid, action string) error
+	DeleteFactoid(network, name, nick string) error
+
+	// Edits of a factoid, newest first
+	FactoidHistory(network, name string, limit int) ([]FactoidEdit, error)
+
+	// Quotes of a channel
+	AddQuote(q *Quote) error
+	GetQuote(network, channel string, id int64) (*Quote, error)
+	DeleteQuote(network, channel string, id int64) error
+
+	// A random quote of the channel, limited to a nick when not empty
+	RandomQuote(network, channel, nick string) (*Quote, error)
+
+	// Quotes containing every word, newest first
+	SearchQuotes(network, channel, words string, limit int) ([]Quote, error)
+
+	// Every quote of the channel, oldest first
+	Quotes(network, channel string) ([]Quote, error)
+
+	// Karma, things without karma have a score of 0
+	AddKarma(network, thing string, delta int) error
+	GetKarma(network, thing string) (int, error)
+
+	// Highest scores first, or lowest when ascending
+	TopKarma(network string, limit int, ascending bool) ([]Karma, error)
+
+	// Polls, a channel has one open poll at a time
+	AddPoll(p *Poll) error
+	OpenPoll(network, channel string) (*Poll, error)
+
+	// Close an open poll, closed is false when it was closed already
+	ClosePoll(id int64) (closed bool, err error)
+
+	// Open polls closing by t
+	DuePolls(t time.Time) ([]Poll, error)
+
+	// Votes are by voter, voting again changes the choice. Results are the
+	// number of votes for each option.
+	Vote(pollID int64, voter string, choice int) error
+	PollResults(p *Poll) ([]int, error)
+
+	// Key/value data of plugins, by namespace
+	Get(namespace, key string) ([]byte, error)
+	Set(namespace, key string, value []byte) error
+	Delete(namespace, key string) error
+	Keys(namespace string) ([]string, error)
+
+	// Number of keys of a namespace and the bytes of their keys and values
+	NamespaceSize(namespace string) (keys int, size int64, err error)
+
+	// Every entry of a namespace, and replacing them all
+	Entries(namespace string) ([]Entry, error)
+	ReplaceEntries(namespace string, entries []Entry) error
+
+	// Channel history
+	AddMessage(m *Message) error
+	SearchMessages(network, channel string, q MessageQuery) ([]Message, error)
+
+	// Remove messages said before t
+	PruneMessages(t time.Time) error
+
+	Close() error
+}
+
+// Open a store by driver name, sqlite or mysql.
+func Open(driver, dsn string) (Store, error) {
+
+	switch driver {
+
+	case "", "sqlite", "sqlite3":
+
+		return OpenSQLite(dsn)
+
+	case "mysql":
+
+		return OpenMySQL(dsn)
+	}
+
+	return nil, ErrDriver
+}
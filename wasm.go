@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheCreeper/HackBot/plugin"
+	"github.com/tetratelabs/wazero"
+	wapi "github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Errors
+var (
+	ErrWasmCalls  = errors.New("WebAssembly module made too many function calls")
+	ErrWasmClosed = errors.New("WebAssembly module is not loaded")
+	ErrWasmExport = errors.New("WebAssembly module does not export")
+	ErrWasmMemory = errors.New("Out of the memory of the WebAssembly module")
+	ErrWasmInit   = errors.New("Only allowed in init")
+)
+
+// Limits of WebAssembly modules
+const (
+	defaultWasmMemory  = 64 // MB
+	defaultWasmCalls   = 10000000
+	defaultWasmTimeout = time.Second // Of events, init has the plugin call timeout
+	wasmPageSize       = 64 * 1024
+)
+
+// Function calls left for a call into a module. Loops without calls are not
+// counted, the deadline of the call ends those.
+type callBudget struct {
+	left   int
+	empty  bool
+	cancel context.CancelFunc
+}
+
+type callBudgetKey struct{}
+
+// The callMeter listens to the functions a module calls, ending the call
+// when the budget in its context runs out
+type callMeter struct{}
+
+func (f callMeter) NewFunctionListener(wapi.FunctionDefinition) experimental.FunctionListener {
+
+	return f
+}
+
+func (callMeter) Before(ctx context.Context, _ wapi.Module, _ wapi.FunctionDefinition, _ []uint64, _ experimental.StackIterator) {
+
+	t, ok := ctx.Value(callBudgetKey{}).(*callBudget)
+	if !ok {
+
+		return
+	}
+	t.left--
+	if t.left < 0 && !t.empty {
+
+		t.empty = true
+		t.cancel()
+	}
+}
+
+func (callMeter) After(context.Context, wapi.Module, wapi.FunctionDefinition, []uint64) {}
+
+func (callMeter) Abort(context.Context, wapi.Module, wapi.FunctionDefinition, error) {}
+
+// A wasmModule runs a WebAssembly module as an embedded plugin. A module
+// stopped by its limits is instantiated again.
+type wasmModule struct {
+	api     *pluginAPI
+	calls   int
+	timeout time.Duration // Of events
+
+	mu       sync.Mutex
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	module   wapi.Module
+	loading  bool
+	loaded   bool // Registrations were made by the first init
+	events   []string
+	usages   map[string]string
+}
+
+func newWasmModule(a *pluginAPI) *wasmModule {
+
+	w := &wasmModule{
+
+		api:     a,
+		calls:   defaultWasmCalls,
+		timeout: defaultWasmTimeout,
+		usages:  make(map[string]string),
+	}
+	if a.plugin.Config.Limits.Calls > 0 {
+
+		w.calls = a.plugin.Config.Limits.Calls
+	}
+	if a.plugin.Config.CallTimeoutSeconds > 0 {
+
+		w.timeout = a.plugin.callTimeout()
+	}
+
+	return w
+}
+
+// Compile and instantiate the module
+func (w *wasmModule) load(code []byte) (err error) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	memory := defaultWasmMemory
+	if w.api.plugin.Config.Limits.MemoryMB > 0 {
+
+		memory = w.api.plugin.Config.Limits.MemoryMB
+	}
+
+	ctx := experimental.WithFunctionListenerFactory(context.Background(), callMeter{})
+	w.runtime = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(memory*1024*1024/wasmPageSize)).
+		WithCloseOnContextDone(true))
+
+	_, err = w.runtime.NewHostModuleBuilder(plugin.WasmModule).
+		NewFunctionBuilder().WithFunc(w.call).Export(plugin.WasmCall).
+		Instantiate(ctx)
+	if err != nil {
+
+		return
+	}
+	if _, err = wasi_snapshot_preview1.Instantiate(ctx, w.runtime); err != nil {
+
+		return
+	}
+	if w.compiled, err = w.runtime.CompileModule(ctx, code); err != nil {
+
+		return
+	}
+
+	return w.instantiate()
+}
+
+// Instantiate the compiled module and run its init
+func (w *wasmModule) instantiate() (err error) {
+
+	p := w.api.plugin
+	config := wazero.NewModuleConfig().
+		WithName(p.Config.Name).
+		WithStartFunctions("_initialize").
+		WithStdout(&lineLogger{log: p.log, stream: "stdout"}).
+		WithStderr(&lineLogger{log: p.log, stream: "stderr"}).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader)
+
+	ctx, cancel := w.context(p.callTimeout())
+	defer cancel()
+	if w.module, err = w.runtime.InstantiateModule(ctx, w.compiled, config); err != nil {
+
+		return
+	}
+	for _, name := range []string{plugin.WasmAlloc, plugin.WasmInit, plugin.WasmEvent} {
+
+		if w.module.ExportedFunction(name) == nil {
+
+			return fmt.Errorf("%w %s", ErrWasmExport, name)
+		}
+	}
+
+	data := []byte(p.Config.Config)
+	if len(data) < 1 {
+
+		data = []byte("null")
+	}
+
+	w.loading = true
+	rc, err := w.invoke(plugin.WasmInit, data, p.callTimeout())
+	w.loading = false
+	if err != nil {
+
+		return
+	}
+	if rc != 0 {
+
+		return fmt.Errorf("WebAssembly init returned %d", rc)
+	}
+	w.loaded = true
+
+	return
+}
+
+// Context of a call, ending it after the timeout or when the budget of
+// function calls runs out
+func (w *wasmModule) context(timeout time.Duration) (context.Context, context.CancelFunc) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	t := &callBudget{left: w.calls, cancel: cancel}
+
+	return context.WithValue(ctx, callBudgetKey{}, t), cancel
+}
+
+// Call an exported function with data written to the memory of the module
+func (w *wasmModule) invoke(name string, data []byte, timeout time.Duration) (rc uint32, err error) {
+
+	ctx, cancel := w.context(timeout)
+	defer cancel()
+
+	ptr, err := w.write(ctx, w.module, data)
+	if err == nil {
+
+		var results []uint64
+		results, err = w.module.ExportedFunction(name).Call(ctx, uint64(ptr), uint64(len(data)))
+		if err == nil {
+
+			rc = uint32(results[0])
+		}
+	}
+	if t := ctx.Value(callBudgetKey{}).(*callBudget); t.empty {
+
+		return 0, ErrWasmCalls
+	}
+
+	return
+}
+
+func (w *wasmModule) write(ctx context.Context, m wapi.Module, data []byte) (ptr uint32, err error) {
+
+	results, err := m.ExportedFunction(plugin.WasmAlloc).Call(ctx, uint64(len(data)))
+	if err != nil {
+
+		return
+	}
+	ptr = uint32(results[0])
+	if !m.Memory().Write(ptr, data) {
+
+		return 0, ErrWasmMemory
+	}
+
+	return
+}
+
+// The call function imported by modules
+func (w *wasmModule) call(ctx context.Context, m wapi.Module, methodPtr, methodLen, argsPtr, argsLen uint32) uint64 {
+
+	var reply plugin.WasmReply
+	method, ok := m.Memory().Read(methodPtr, methodLen)
+	args, ok2 := m.Memory().Read(argsPtr, argsLen)
+	if !ok || !ok2 {
+
+		reply.Error = ErrWasmMemory.Error()
+	} else {
+
+		result, err := w.dispatch(string(method), args)
+		if err != nil {
+
+			reply.Error = err.Error()
+		} else if result != nil {
+
+			reply.Result, _ = json.Marshal(result)
+		}
+	}
+
+	b, _ := json.Marshal(reply)
+	ptr, err := w.write(ctx, m, b)
+	if err != nil {
+
+		return 0
+	}
+
+	return uint64(ptr)<<32 | uint64(len(b))
+}
+
+// Call a method of the Host service for the module, decoding the arguments
+// into its argument type as net/rpc does
+func (w *wasmModule) dispatch(method string, args []byte) (result interface{}, err error) {
+
+	switch method {
+
+	case plugin.WasmSubscribe:
+
+		var a plugin.SubscribeArgs
+		if err = json.Unmarshal(args, &a); err != nil {
+
+			return
+		}
+		if !w.loading {
+
+			return nil, ErrWasmInit
+		}
+		if !w.loaded {
+
+			w.events = append(w.events, a.Event)
+		}
+		return
+
+	case "RegisterCommand":
+
+		var a plugin.CommandArgs
+		if err = json.Unmarshal(args, &a); err != nil {
+
+			return
+		}
+		if !w.loading {
+
+			return nil, ErrWasmInit
+		}
+		if !w.loaded {
+
+			w.usages[strings.ToLower(a.Name)] = a.Usage
+		}
+		return
+
+	case plugin.WasmLog:
+
+		var a plugin.LogArgs
+		if err = json.Unmarshal(args, &a); err == nil {
+
+			w.api.plugin.log.Info("plugin output", "stream", "log", "line", a.Text)
+		}
+		return
+
+	case "Handshake":
+
+		return nil, fmt.Errorf("Unknown plugin method %s", method)
+	}
+
+	m := reflect.ValueOf(w.api).MethodByName(method)
+	if !m.IsValid() || m.Type().NumIn() != 2 || m.Type().In(1).Kind() != reflect.Ptr {
+
+		return nil, fmt.Errorf("Unknown plugin method %s", method)
+	}
+
+	argv := reflect.New(m.Type().In(0))
+	if err = json.Unmarshal(args, argv.Interface()); err != nil {
+
+		return
+	}
+	reply := reflect.New(m.Type().In(1).Elem())
+	out := m.Call([]reflect.Value{argv.Elem(), reply})
+	if e, ok := out[0].Interface().(error); ok && e != nil {
+
+		return nil, e
+	}
+
+	// Acknowledgements carry nothing
+	if _, ok := reply.Interface().(*bool); ok {
+
+		return nil, nil
+	}
+
+	return reply.Interface(), nil
+}
+
+func (w *wasmModule) Event(e plugin.Event) (err error) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.module == nil {
+
+		return ErrWasmClosed
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+
+		return
+	}
+	rc, err := w.invoke(plugin.WasmEvent, b, w.timeout)
+
+	// A module stopped by its limits or a trap is closed, a new instance
+	// takes its place
+	if w.module.IsClosed() {
+
+		p := w.api.plugin
+		p.log.Warn("WebAssembly module stopped, instantiating it again", "error", err)
+		if ierr := w.instantiate(); ierr != nil {
+
+			p.log.Error("WebAssembly instantiate", "error", ierr)
+			w.module = nil
+		}
+		p.mu.Lock()
+		p.restarts++
+		p.mu.Unlock()
+	}
+	if err != nil {
+
+		return
+	}
+	if rc != 0 {
+
+		return fmt.Errorf("WebAssembly event returned %d", rc)
+	}
+
+	return
+}
+
+func (w *wasmModule) Close() {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.runtime != nil {
+
+		w.runtime.Close(context.Background())
+		w.runtime = nil
+		w.module = nil
+	}
+}
+
+// Load a WebAssembly plugin, which Start does for paths ending in .wasm
+func (h *PluginHost) loadWasm(p *Plugin) (err error) {
+
+	code, err := ioutil.ReadFile(p.Config.Path)
+	if err != nil {
+
+		return
+	}
+
+	w := newWasmModule(&pluginAPI{host: h, plugin: p})
+	if err = w.load(code); err != nil {
+
+		w.Close()
+		return fmt.Errorf("plugin %s: %w", p.Config.Name, err)
+	}
+	if err = h.attach(p, w, p.Manifest.Version, w.events, w.usages, false); err != nil {
+
+		w.Close()
+		return
+	}
+	p.log.Info("plugin started", "path", p.Config.Path, "calls", w.calls, "timeout", w.timeout)
+
+	return
+}